@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SegmentChecksum 分段内容聚合（用于内容级验证）
+type SegmentChecksum struct {
+	Count   uint64            // 记录数
+	Hash    uint64            // groupBitXor(cityHash64(所有字段))
+	Sums    map[string]string // 数值字段求和（字符串形式，避免精度损失）
+	MinTime string            // 时间字段最小值
+	MaxTime string            // 时间字段最大值
+}
+
+// checksumColumns 获取参与内容验证的字段（源库与目标库都存在且类型一致的字段）
+func (v *Validator) checksumColumns(tableName string) ([]ColumnInfo, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if columns, ok := v.checksumCache[tableName]; ok {
+		return columns, nil
+	}
+
+	sourceSchema, err := DetectTableSchema(v.sourceDB, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect source schema: %w", err)
+	}
+	targetSchema, err := DetectTableSchema(v.targetDB, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect target schema: %w", err)
+	}
+
+	columns := []ColumnInfo{}
+	for _, col := range sourceSchema.Columns {
+		targetCol := targetSchema.GetColumn(col.Name)
+		if targetCol != nil && targetCol.Type == col.Type {
+			columns = append(columns, col)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no common columns between source and target for %s", tableName)
+	}
+
+	v.checksumCache[tableName] = columns
+	return columns, nil
+}
+
+// buildChecksumQuery 构建分段内容聚合 SQL（source 为 rangeSource 返回的查询来源，参数由 r.Args 提供）
+func buildChecksumQuery(source string, timeField string, columns []ColumnInfo) (string, []string) {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.Name
+	}
+
+	exprs := []string{
+		"count()",
+		fmt.Sprintf("groupBitXor(cityHash64(%s))", strings.Join(names, ", ")),
+		fmt.Sprintf("toString(min(%s))", timeField),
+		fmt.Sprintf("toString(max(%s))", timeField),
+	}
+
+	sumColumns := []string{}
+	for _, col := range columns {
+		baseType := unwrapType(col.Type)
+		switch {
+		case strings.HasPrefix(baseType, "Float"):
+			// 浮点求和与聚合顺序有关，保留 4 位小数避免误报
+			exprs = append(exprs, fmt.Sprintf("toString(round(sum(%s), 4))", col.Name))
+		case strings.HasPrefix(baseType, "Int"), strings.HasPrefix(baseType, "UInt"),
+			strings.HasPrefix(baseType, "Decimal"):
+			exprs = append(exprs, fmt.Sprintf("toString(sum(%s))", col.Name))
+		default:
+			continue
+		}
+		sumColumns = append(sumColumns, col.Name)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), source)
+	return query, sumColumns
+}
+
+// unwrapType 去掉 Nullable / LowCardinality 包装，返回基础类型
+func unwrapType(typeStr string) string {
+	for {
+		switch {
+		case strings.HasPrefix(typeStr, "Nullable(") && strings.HasSuffix(typeStr, ")"):
+			typeStr = typeStr[len("Nullable(") : len(typeStr)-1]
+		case strings.HasPrefix(typeStr, "LowCardinality(") && strings.HasSuffix(typeStr, ")"):
+			typeStr = typeStr[len("LowCardinality(") : len(typeStr)-1]
+		default:
+			return typeStr
+		}
+	}
+}
+
+// ChecksumSegment 计算某个库上指定分段的内容聚合
+func (v *Validator) ChecksumSegment(ctx context.Context, db *sql.DB, tableName string, r SegmentRange) (*SegmentChecksum, error) {
+	columns, err := v.checksumColumns(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.Name
+	}
+	query, sumColumns := buildChecksumQuery(v.rangeSource(tableName, r, names), r.Field, columns)
+
+	checksum := &SegmentChecksum{Sums: make(map[string]string, len(sumColumns))}
+	sums := make([]string, len(sumColumns))
+	dest := []interface{}{&checksum.Count, &checksum.Hash, &checksum.MinTime, &checksum.MaxTime}
+	for i := range sums {
+		dest = append(dest, &sums[i])
+	}
+
+	if err := db.QueryRowContext(ctx, query, r.Args()...).Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	for i, col := range sumColumns {
+		checksum.Sums[col] = sums[i]
+	}
+	return checksum, nil
+}
+
+// Diff 对比两个分段聚合，返回不一致的聚合项
+func (sc *SegmentChecksum) Diff(other *SegmentChecksum) []string {
+	mismatches := []string{}
+	if sc.Count != other.Count {
+		mismatches = append(mismatches, "count")
+	}
+	if sc.Hash != other.Hash {
+		mismatches = append(mismatches, "hash")
+	}
+	if sc.MinTime != other.MinTime {
+		mismatches = append(mismatches, "min_time")
+	}
+	if sc.MaxTime != other.MaxTime {
+		mismatches = append(mismatches, "max_time")
+	}
+
+	columns := make([]string, 0, len(sc.Sums))
+	for col := range sc.Sums {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	for _, col := range columns {
+		if sc.Sums[col] != other.Sums[col] {
+			mismatches = append(mismatches, fmt.Sprintf("sum(%s)", col))
+		}
+	}
+
+	return mismatches
+}
+
+// compareChecksums 对比源库与目标库的分段内容聚合
+func (v *Validator) compareChecksums(ctx context.Context, tableName string, r SegmentRange) ([]string, error) {
+	sourceChecksum, err := v.ChecksumSegment(ctx, v.sourceDB, tableName, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum source segment: %w", err)
+	}
+
+	targetChecksum, err := v.ChecksumSegment(ctx, v.targetDB, tableName, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum target segment: %w", err)
+	}
+
+	return sourceChecksum.Diff(targetChecksum), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildChecksumQueryUsesRangePredicate(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []ColumnInfo{
+		{Name: "id", Type: "UInt64"},
+		{Name: "amount", Type: "Decimal(18, 2)"},
+		{Name: "created_at", Type: "DateTime64(3, 'Asia/Shanghai')"},
+	}
+
+	tests := []struct {
+		name string
+		r    SegmentRange
+		want string
+	}{
+		{
+			name: "time range",
+			r: TimeSegmentRange("created_at", TimeSegment{
+				Start: time.Date(2024, 3, 1, 0, 0, 0, 0, shanghai),
+				End:   time.Date(2024, 3, 2, 0, 0, 0, 0, shanghai),
+			}),
+			want: "created_at >= toDateTime64(?, 9, 'UTC') AND created_at < toDateTime64(?, 9, 'UTC')",
+		},
+		{
+			name: "id range",
+			r:    SegmentRange{Field: "id", Start: uint64(100), End: uint64(200)},
+			want: "id >= ? AND id < ?",
+		},
+		{
+			name: "partition",
+			r:    PartitionRange("created_at", "202403"),
+			want: "_partition_id = ?",
+		},
+	}
+
+	validator := NewValidator(nil, nil, &Config{Tables: []TableConfig{{Name: "events"}}})
+	names := []string{"id", "amount", "created_at"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, sumColumns := buildChecksumQuery(validator.rangeSource("events", tt.r, names), tt.r.Field, columns)
+			if !strings.HasSuffix(query, " WHERE "+tt.want) {
+				t.Errorf("query = %q, want predicate %q", query, tt.want)
+			}
+			if got, want := strings.Count(query, "?"), len(tt.r.Args()); got != want {
+				t.Errorf("query has %d placeholders, range binds %d args", got, want)
+			}
+			if strings.Join(sumColumns, ",") != "id,amount" {
+				t.Errorf("sumColumns = %v", sumColumns)
+			}
+		})
+	}
+}
+
+// 配置了版本字段的表在源库与目标库上都按去重键只保留最新版本后再聚合
+func TestChecksumQueryKeepsLatestVersionPerKey(t *testing.T) {
+	columns := []ColumnInfo{
+		{Name: "id", Type: "UInt64"},
+		{Name: "amount", Type: "Decimal(18, 2)"},
+		{Name: "created_at", Type: "DateTime"},
+	}
+	validator := NewValidator(nil, nil, &Config{Tables: []TableConfig{{
+		Name:         "orders",
+		DedupeKeys:   []string{"id"},
+		VersionField: "updated_at",
+	}}})
+	r := SegmentRange{Field: "created_at", Start: "2024-03-01 00:00:00", End: "2024-03-02 00:00:00"}
+
+	query, _ := buildChecksumQuery(validator.rangeSource("orders", r, []string{"id", "amount", "created_at"}), r.Field, columns)
+	want := "FROM (SELECT id, amount, created_at, updated_at FROM orders WHERE " + r.Where() +
+		" ORDER BY updated_at DESC LIMIT 1 BY id)"
+	if !strings.HasSuffix(query, want) {
+		t.Errorf("query = %q, want suffix %q", query, want)
+	}
+	if got, want := strings.Count(query, "?"), len(r.Args()); got != want {
+		t.Errorf("query has %d placeholders, range binds %d args", got, want)
+	}
+
+	count := validator.rangeSource("orders", r, nil)
+	if want := "(SELECT id, updated_at, created_at FROM orders WHERE " + r.Where() +
+		" ORDER BY updated_at DESC LIMIT 1 BY id)"; count != want {
+		t.Errorf("count source = %q, want %q", count, want)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -19,18 +20,82 @@ type Config struct {
 
 // DatabaseConfig 数据库连接配置
 type DatabaseConfig struct {
-	Addr     []string `yaml:"addr"`
-	Database string   `yaml:"database"`
-	Username string   `yaml:"username"`
-	Password string   `yaml:"password"`
+	Addr            []string   `yaml:"addr"`
+	Database        string     `yaml:"database"`
+	Username        string     `yaml:"username"`
+	Password        string     `yaml:"password"`
+	PasswordFile    string     `yaml:"password_file"`    // 从文件读取密码（与 password、password_command 三选一）
+	PasswordCommand string     `yaml:"password_command"` // 执行命令（sh -c）获取密码，取标准输出（如密钥管理工具的命令行）
+	RemoteAddr      string     `yaml:"remote_addr"`      // 目标库访问源库使用的地址（remote 传输模式），为空时使用 addr
+	TLS             TLSConfig  `yaml:"tls"`              // TLS 连接配置（ClickHouse Cloud、9440 端口的安全原生协议等）
+	Protocol        string     `yaml:"protocol"`         // 连接协议："native"（原生 TCP 协议，默认）或 "http"
+	HTTP            HTTPConfig `yaml:"http"`             // HTTP 协议选项（protocol: http 时生效）
+}
+
+// HTTPConfig HTTP 协议连接选项
+type HTTPConfig struct {
+	Path        string            `yaml:"path"`        // 请求的 URL 路径（经反向代理访问时的路径前缀）
+	Compression string            `yaml:"compression"` // 压缩方式：none、gzip、deflate、br、lz4、zstd（为空时按 enable_compression 使用 lz4 或不压缩）
+	Headers     map[string]string `yaml:"headers"`     // 附加到每个请求的 HTTP 头（如代理的认证头）
+}
+
+// validateProtocol 验证连接协议配置
+func (d DatabaseConfig) validateProtocol() error {
+	switch d.Protocol {
+	case "native":
+		if d.HTTP.Path != "" || d.HTTP.Compression != "" || len(d.HTTP.Headers) > 0 {
+			return fmt.Errorf("http options are set but protocol is 'native'")
+		}
+	case "http":
+		switch d.HTTP.Compression {
+		case "", "none", "gzip", "deflate", "br", "lz4", "zstd":
+		default:
+			return fmt.Errorf("http compression must be one of none, gzip, deflate, br, lz4, zstd, got: %s", d.HTTP.Compression)
+		}
+	default:
+		return fmt.Errorf("protocol must be 'native' or 'http', got: %s", d.Protocol)
+	}
+	return nil
+}
+
+// TLSConfig TLS 连接配置
+type TLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`              // 验证服务端证书的 CA 证书（PEM），为空时使用系统 CA
+	CertFile           string `yaml:"cert_file"`            // 客户端证书（PEM，服务端要求双向 TLS 时配置）
+	KeyFile            string `yaml:"key_file"`             // 客户端证书的私钥（PEM）
+	ServerName         string `yaml:"server_name"`          // 验证证书使用的服务端名称，为空时使用连接地址中的主机名
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // 跳过服务端证书验证（仅用于测试环境）
+}
+
+// Validate 验证 TLS 配置
+func (t TLSConfig) Validate() error {
+	if !t.Enabled {
+		if t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.ServerName != "" || t.InsecureSkipVerify {
+			return fmt.Errorf("tls options are set but tls.enabled is false")
+		}
+		return nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("tls cert_file and key_file must be set together")
+	}
+	return nil
 }
 
 // SyncConfig 同步配置
 type SyncConfig struct {
 	Mode              string           `yaml:"mode"`
 	BatchSize         int              `yaml:"batch_size"`
+	InsertMethod      string           `yaml:"insert_method"`      // "native"（原生列式批量插入）或 "sql"（database/sql 逐行写入）
+	DedupeStrategy    string           `yaml:"dedupe_strategy"`    // "memory"（加载目标库键到内存）或 "anti_join"（目标库侧反连接）
+	MaxKeysInMemory   int              `yaml:"max_keys_in_memory"` // memory 策略下单个分段允许加载的最大键数量（0 表示不限制）
+	KeySet            string           `yaml:"key_set"`            // 去重键集合："exact"（完整键）或 "hashed"（128 位哈希）
+	WriteMode         string           `yaml:"write_mode"`         // "append"（只追加不存在的键）或 "replace_segment"（整体替换分段）
 	MaxConcurrency    int              `yaml:"max_concurrency"`
+	MaxConnections    int              `yaml:"max_connections"` // 所有表同时进行的分段同步总数（表内 segment_concurrency 共享该预算）
 	DailySegmentation bool             `yaml:"daily_segmentation"`
+	Segmentation      string           `yaml:"segmentation"`        // 历史同步的分段方式："day"（按自然日）、"adaptive"（按行数密度）或 "partition"（按表的分区）
+	SegmentTargetRows int              `yaml:"segment_target_rows"` // adaptive 分段方式下每个分段的目标行数
 	EnableCompression bool             `yaml:"enable_compression"`
 	DialTimeout       int              `yaml:"dial_timeout"`
 	QueryTimeout      int              `yaml:"query_timeout"`
@@ -39,6 +104,18 @@ type SyncConfig struct {
 	Resume            bool             `yaml:"resume"`
 	SkipValidation    bool             `yaml:"skip_validation"`
 	ValidationRatio   float64          `yaml:"validation_ratio"`
+	ValidationMethod  string           `yaml:"validation_method"` // "count" 或 "checksum"
+	Repair            RepairConfig     `yaml:"repair"`
+	LateArrivalWindow int              `yaml:"late_arrival_window"` // 实时同步从目标库最新时间往前回溯的窗口（秒）
+	Sweep             SweepConfig      `yaml:"sweep"`
+	Pipeline          PipelineConfig   `yaml:"pipeline"`
+	Limits            LimitsConfig     `yaml:"limits"` // 全局限流与资源预算（运行中可通过 SIGHUP 重新加载）
+	Retry             RetryConfig      `yaml:"retry"`
+	Health            HealthConfig     `yaml:"health"`      // 多节点健康检查、熔断与故障切换（源库和目标库分别跟踪）
+	ReplicaLag        ReplicaLagConfig `yaml:"replica_lag"` // 实时同步读取源库副本时的复制延迟处理
+	Timezone          string           `yaml:"timezone"`    // 分段边界与时间参数使用的时区（IANA 名称，为空时使用时间字段或源库服务器的时区）
+	// RealtimeValidationInterval 实时模式下每隔多少次循环验证一次（0 表示不验证）
+	RealtimeValidationInterval int `yaml:"realtime_validation_interval"`
 }
 
 // SchemaSyncConfig 表结构同步配置
@@ -49,14 +126,98 @@ type SchemaSyncConfig struct {
 	SkipColumnCheck   bool `yaml:"skip_column_check"`
 }
 
+// RepairConfig 差异分段修复配置
+type RepairConfig struct {
+	Strategy  string `yaml:"strategy"`   // "delete"（先删除再复制）或 "replacing"（依赖 ReplacingMergeTree 合并）
+	MinWindow int    `yaml:"min_window"` // 二分定位的最小窗口（秒）
+}
+
+// SweepConfig 延迟到达数据的周期性回扫配置
+type SweepConfig struct {
+	Interval int `yaml:"interval"` // 回扫间隔（秒，0 表示不回扫），与 --loop-interval 无关
+	Lookback int `yaml:"lookback"` // 每次回扫目标库最新时间之前多长时间的数据（秒）
+}
+
+// PipelineConfig 分段复制流水线配置（读取、去重、写入三个阶段并发执行）
+type PipelineConfig struct {
+	QueueSize     int `yaml:"queue_size"`     // 阶段之间的队列最多缓冲的批次数量
+	InsertWorkers int `yaml:"insert_workers"` // 每个分段并发写入目标库的协程数量
+}
+
+// RetryConfig 暂时性错误（网络、超时、TOO_MANY_PARTS、副本不可用等）的重试配置
+type RetryConfig struct {
+	MaxAttempts    int `yaml:"max_attempts"`    // 最多尝试次数（含第一次，1 表示不重试）
+	InitialBackoff int `yaml:"initial_backoff"` // 第一次重试前的最长等待时间（毫秒），之后每次翻倍
+	MaxBackoff     int `yaml:"max_backoff"`     // 等待时间上限（毫秒）
+}
+
+// HealthConfig 节点健康检查配置：连续失败的节点熔断，周期性探测恢复情况与复制延迟
+type HealthConfig struct {
+	FailureThreshold int `yaml:"failure_threshold"` // 连续失败多少次后熔断节点
+	OpenDuration     int `yaml:"open_duration"`     // 熔断持续时间（秒），之后允许试探连接
+	ProbeInterval    int `yaml:"probe_interval"`    // 探测各节点的间隔（秒）
+	LagTolerance     int `yaml:"lag_tolerance"`     // 活动节点的复制延迟比最优节点多出该值（秒）时才切换，避免来回切换
+}
+
+// ReplicaLagConfig 实时同步的复制延迟处理：同步窗口在同一个源库副本上计算，结束边界按该副本的复制延迟后移
+type ReplicaLagConfig struct {
+	SequentialConsistency bool `yaml:"sequential_consistency"` // 计算同步窗口的查询附加 select_sequential_consistency = 1（源库需使用 insert_quorum 写入）
+	MaxDelay              int  `yaml:"max_delay"`              // 副本复制延迟超过该值（秒）时本轮跳过该表（0 表示不跳过）
+}
+
+// LimitsConfig 全局限流配置：源库和目标库分别限制，所有表共享
+type LimitsConfig struct {
+	Source ClusterLimitsConfig `yaml:"source"`
+	Target ClusterLimitsConfig `yaml:"target"`
+}
+
+// ClusterLimitsConfig 单个集群的限流配置（0 表示不限制）
+type ClusterLimitsConfig struct {
+	RowsPerSecond    int                    `yaml:"rows_per_second"`    // 每秒读取的行数
+	BytesPerSecond   int64                  `yaml:"bytes_per_second"`   // 每秒读取的字节数（按服务端查询进度统计）
+	MaxQueries       int                    `yaml:"max_queries"`        // 同时执行的查询数量
+	InsertsPerSecond float64                `yaml:"inserts_per_second"` // 每秒 INSERT 语句数量
+	Settings         map[string]interface{} `yaml:"settings"`           // 附加到每条查询的 ClickHouse 设置（如 max_threads、max_memory_usage、priority）
+}
+
+// Validate 验证限流配置
+func (l LimitsConfig) Validate() error {
+	for name, limits := range map[string]ClusterLimitsConfig{"source": l.Source, "target": l.Target} {
+		if limits.RowsPerSecond < 0 || limits.BytesPerSecond < 0 || limits.MaxQueries < 0 || limits.InsertsPerSecond < 0 {
+			return fmt.Errorf("limits.%s: rows_per_second, bytes_per_second, max_queries and inserts_per_second must not be negative", name)
+		}
+		for key := range limits.Settings {
+			if key == "" {
+				return fmt.Errorf("limits.%s: setting name must not be empty", name)
+			}
+		}
+	}
+	return nil
+}
+
 // TableConfig 表同步配置
 type TableConfig struct {
-	Name       string   `yaml:"name"`
-	Mode       string   `yaml:"mode"`
-	TimeField  string   `yaml:"time_field"`
-	DedupeKeys []string `yaml:"dedupe_keys"`
-	BatchSize  int      `yaml:"batch_size"`
-	Enabled    bool     `yaml:"enabled"`
+	Name               string   `yaml:"name"`
+	Mode               string   `yaml:"mode"`
+	TimeField          string   `yaml:"time_field"`
+	DedupeKeys         []string `yaml:"dedupe_keys"`
+	VersionField       string   `yaml:"version_field"` // 版本字段：键已存在但源库版本更新时重新写入
+	CursorField        string   `yaml:"cursor_field"`  // 单调递增 ID 字段：按 ID 记录进度和分段（代替时间字段）
+	CursorSegmentSize  int      `yaml:"cursor_segment_size"`
+	SegmentConcurrency int      `yaml:"segment_concurrency"` // 表内同时同步的分段数量（默认 1）
+	LateArrivalWindow  int      `yaml:"late_arrival_window"` // 覆盖全局实时回溯窗口（秒）
+	SweepLookback      int      `yaml:"sweep_lookback"`      // 覆盖全局回扫范围（秒）
+	Timezone           string   `yaml:"timezone"`            // 覆盖全局时区
+	BatchSize          int      `yaml:"batch_size"`
+	InsertMethod       string   `yaml:"insert_method"`
+	DedupeStrategy     string   `yaml:"dedupe_strategy"`
+	KeySet             string   `yaml:"key_set"`
+	BloomFilter        bool     `yaml:"bloom_filter"` // hashed 键集合启用布隆过滤器预检查
+	WriteMode          string   `yaml:"write_mode"`
+	Segmentation       string   `yaml:"segmentation"`
+	SegmentTargetRows  int      `yaml:"segment_target_rows"`
+	Transfer           string   `yaml:"transfer"` // "stream"（经本进程读写，默认）或 "remote"（目标库 INSERT ... SELECT FROM remote()）
+	Enabled            bool     `yaml:"enabled"`
 }
 
 // TimeRangeConfig 时间范围配置
@@ -81,19 +242,58 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
+	// 解析后替换环境变量引用（${NAME}、${NAME:-默认值}），替换的值不会破坏 YAML 结构
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
+	if err := expandEnv(&document); err != nil {
+		return nil, fmt.Errorf("failed to expand environment variables in config: %w", err)
+	}
+
+	var config Config
+	if document.Kind != 0 {
+		if err := document.Decode(&config); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", err)
+		}
+	}
+
+	// 从 password_file / password_command 获取密码，并登记需要在日志中隐藏的值
+	if err := config.Source.resolveSecrets(); err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	if err := config.Target.resolveSecrets(); err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
 
 	// 设置默认值
+	if config.Source.Protocol == "" {
+		config.Source.Protocol = "native"
+	}
+	if config.Target.Protocol == "" {
+		config.Target.Protocol = "native"
+	}
 	if config.Sync.BatchSize == 0 {
 		config.Sync.BatchSize = 2000
 	}
+	if config.Sync.InsertMethod == "" {
+		config.Sync.InsertMethod = "native"
+	}
+	if config.Sync.DedupeStrategy == "" {
+		config.Sync.DedupeStrategy = "memory"
+	}
+	if config.Sync.KeySet == "" {
+		config.Sync.KeySet = "exact"
+	}
+	if config.Sync.WriteMode == "" {
+		config.Sync.WriteMode = "append"
+	}
 	if config.Sync.MaxConcurrency == 0 {
 		config.Sync.MaxConcurrency = 3
 	}
+	if config.Sync.MaxConnections == 0 {
+		config.Sync.MaxConnections = config.Sync.MaxConcurrency
+	}
 	if config.Sync.DialTimeout == 0 {
 		config.Sync.DialTimeout = 10
 	}
@@ -103,6 +303,54 @@ func LoadConfig(configPath string) (*Config, error) {
 	if config.Sync.ValidationRatio == 0 {
 		config.Sync.ValidationRatio = 0.95
 	}
+	if config.Sync.ValidationMethod == "" {
+		config.Sync.ValidationMethod = "count"
+	}
+	if config.Sync.Repair.Strategy == "" {
+		config.Sync.Repair.Strategy = "delete"
+	}
+	if config.Sync.Repair.MinWindow == 0 {
+		config.Sync.Repair.MinWindow = 3600
+	}
+	if config.Sync.Segmentation == "" {
+		config.Sync.Segmentation = "day"
+	}
+	if config.Sync.SegmentTargetRows == 0 {
+		config.Sync.SegmentTargetRows = 2000000
+	}
+	if config.Sync.Pipeline.QueueSize == 0 {
+		config.Sync.Pipeline.QueueSize = 2
+	}
+	if config.Sync.Pipeline.InsertWorkers == 0 {
+		config.Sync.Pipeline.InsertWorkers = 1
+	}
+	if config.Sync.Retry.MaxAttempts == 0 {
+		config.Sync.Retry.MaxAttempts = 5
+	}
+	if config.Sync.Retry.InitialBackoff == 0 {
+		config.Sync.Retry.InitialBackoff = 500
+	}
+	if config.Sync.Retry.MaxBackoff == 0 {
+		config.Sync.Retry.MaxBackoff = 30000
+	}
+	if config.Sync.Health.FailureThreshold == 0 {
+		config.Sync.Health.FailureThreshold = 3
+	}
+	if config.Sync.Health.OpenDuration == 0 {
+		config.Sync.Health.OpenDuration = 30
+	}
+	if config.Sync.Health.ProbeInterval == 0 {
+		config.Sync.Health.ProbeInterval = 10
+	}
+	if config.Sync.Health.LagTolerance == 0 {
+		config.Sync.Health.LagTolerance = 5
+	}
+	if config.Sync.LateArrivalWindow == 0 {
+		config.Sync.LateArrivalWindow = 5
+	}
+	if config.Sync.Sweep.Lookback == 0 {
+		config.Sync.Sweep.Lookback = 86400
+	}
 	if config.TimeRange.FallbackDays == 0 {
 		config.TimeRange.FallbackDays = 30
 	}
@@ -129,6 +377,94 @@ func (tc *TableConfig) GetEffectiveBatchSize(globalBatchSize int) int {
 	return globalBatchSize
 }
 
+// GetEffectiveInsertMethod 获取表的有效插入方式
+func (tc *TableConfig) GetEffectiveInsertMethod(globalMethod string) string {
+	if tc.InsertMethod != "" {
+		return tc.InsertMethod
+	}
+	return globalMethod
+}
+
+// GetEffectiveDedupeStrategy 获取表的有效去重策略
+func (tc *TableConfig) GetEffectiveDedupeStrategy(globalStrategy string) string {
+	if tc.DedupeStrategy != "" {
+		return tc.DedupeStrategy
+	}
+	return globalStrategy
+}
+
+// GetEffectiveKeySet 获取表的有效去重键集合类型
+func (tc *TableConfig) GetEffectiveKeySet(globalKeySet string) string {
+	if tc.KeySet != "" {
+		return tc.KeySet
+	}
+	return globalKeySet
+}
+
+// GetEffectiveCursorSegmentSize 获取 ID 分段大小（默认 1000000）
+func (tc *TableConfig) GetEffectiveCursorSegmentSize() int {
+	if tc.CursorSegmentSize > 0 {
+		return tc.CursorSegmentSize
+	}
+	return 1000000
+}
+
+// GetEffectiveSegmentConcurrency 获取表内同时同步的分段数量（默认 1）
+func (tc *TableConfig) GetEffectiveSegmentConcurrency() int {
+	if tc.SegmentConcurrency > 0 {
+		return tc.SegmentConcurrency
+	}
+	return 1
+}
+
+// GetEffectiveLateArrivalWindow 获取表的实时回溯窗口
+func (tc *TableConfig) GetEffectiveLateArrivalWindow(globalWindow int) time.Duration {
+	if tc.LateArrivalWindow > 0 {
+		return time.Duration(tc.LateArrivalWindow) * time.Second
+	}
+	return time.Duration(globalWindow) * time.Second
+}
+
+// GetEffectiveSweepLookback 获取表的回扫范围
+func (tc *TableConfig) GetEffectiveSweepLookback(globalLookback int) time.Duration {
+	if tc.SweepLookback > 0 {
+		return time.Duration(tc.SweepLookback) * time.Second
+	}
+	return time.Duration(globalLookback) * time.Second
+}
+
+// GetEffectiveSegmentation 获取表的有效分段方式
+func (tc *TableConfig) GetEffectiveSegmentation(globalSegmentation string) string {
+	if tc.Segmentation != "" {
+		return tc.Segmentation
+	}
+	return globalSegmentation
+}
+
+// GetEffectiveSegmentTargetRows 获取 adaptive 分段方式下每个分段的目标行数
+func (tc *TableConfig) GetEffectiveSegmentTargetRows(globalTargetRows int) int {
+	if tc.SegmentTargetRows > 0 {
+		return tc.SegmentTargetRows
+	}
+	return globalTargetRows
+}
+
+// GetEffectiveTimezone 获取表的时区名称（为空表示未配置）
+func (tc *TableConfig) GetEffectiveTimezone(globalTimezone string) string {
+	if tc.Timezone != "" {
+		return tc.Timezone
+	}
+	return globalTimezone
+}
+
+// GetEffectiveWriteMode 获取表的有效写入方式
+func (tc *TableConfig) GetEffectiveWriteMode(globalWriteMode string) string {
+	if tc.WriteMode != "" {
+		return tc.WriteMode
+	}
+	return globalWriteMode
+}
+
 // Validate 验证配置的合法性
 func (c *Config) Validate() error {
 	// 验证数据库配置
@@ -138,18 +474,67 @@ func (c *Config) Validate() error {
 	if c.Source.Database == "" {
 		return fmt.Errorf("source database name is required")
 	}
+	if err := c.Source.TLS.Validate(); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	if err := c.Source.validateProtocol(); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
 	if len(c.Target.Addr) == 0 {
 		return fmt.Errorf("target database address is required")
 	}
 	if c.Target.Database == "" {
 		return fmt.Errorf("target database name is required")
 	}
+	if err := c.Target.TLS.Validate(); err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	if err := c.Target.validateProtocol(); err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
 
 	// 验证同步模式
 	if c.Sync.Mode != "full" && c.Sync.Mode != "incremental" {
 		return fmt.Errorf("sync mode must be 'full' or 'incremental', got: %s", c.Sync.Mode)
 	}
 
+	// 验证数据验证方式
+	if c.Sync.ValidationMethod != "count" && c.Sync.ValidationMethod != "checksum" {
+		return fmt.Errorf("validation_method must be 'count' or 'checksum', got: %s", c.Sync.ValidationMethod)
+	}
+
+	// 验证修复策略
+	if c.Sync.Repair.Strategy != "delete" && c.Sync.Repair.Strategy != "replacing" {
+		return fmt.Errorf("repair strategy must be 'delete' or 'replacing', got: %s", c.Sync.Repair.Strategy)
+	}
+
+	// 验证流水线配置
+	if c.Sync.Pipeline.QueueSize < 1 {
+		return fmt.Errorf("pipeline queue_size must be at least 1, got: %d", c.Sync.Pipeline.QueueSize)
+	}
+	if c.Sync.Pipeline.InsertWorkers < 1 {
+		return fmt.Errorf("pipeline insert_workers must be at least 1, got: %d", c.Sync.Pipeline.InsertWorkers)
+	}
+
+	// 验证重试配置
+	if c.Sync.Retry.MaxAttempts < 1 || c.Sync.Retry.InitialBackoff < 0 || c.Sync.Retry.MaxBackoff < c.Sync.Retry.InitialBackoff {
+		return fmt.Errorf("retry: max_attempts must be at least 1 and max_backoff must not be less than initial_backoff")
+	}
+
+	// 验证健康检查配置
+	if c.Sync.Health.FailureThreshold < 1 || c.Sync.Health.OpenDuration < 1 || c.Sync.Health.ProbeInterval < 1 || c.Sync.Health.LagTolerance < 0 {
+		return fmt.Errorf("health: failure_threshold, open_duration and probe_interval must be at least 1, lag_tolerance must not be negative")
+	}
+
+	if c.Sync.ReplicaLag.MaxDelay < 0 {
+		return fmt.Errorf("replica_lag max_delay must not be negative, got: %d", c.Sync.ReplicaLag.MaxDelay)
+	}
+
+	// 验证限流配置
+	if err := c.Sync.Limits.Validate(); err != nil {
+		return err
+	}
+
 	// 验证表配置
 	if len(c.Tables) == 0 {
 		return fmt.Errorf("no tables configured for sync")
@@ -177,6 +562,67 @@ func (c *Config) Validate() error {
 		if mode != "full" && mode != "incremental" {
 			return fmt.Errorf("table[%d] (%s): invalid mode: %s", i, table.Name, mode)
 		}
+
+		// 验证表的插入方式
+		insertMethod := table.GetEffectiveInsertMethod(c.Sync.InsertMethod)
+		if insertMethod != "native" && insertMethod != "sql" {
+			return fmt.Errorf("table[%d] (%s): invalid insert_method: %s", i, table.Name, insertMethod)
+		}
+
+		// 验证表的去重策略
+		dedupeStrategy := table.GetEffectiveDedupeStrategy(c.Sync.DedupeStrategy)
+		if dedupeStrategy != "memory" && dedupeStrategy != "anti_join" {
+			return fmt.Errorf("table[%d] (%s): dedupe_strategy must be 'memory' or 'anti_join', got: %s", i, table.Name, dedupeStrategy)
+		}
+
+		// 验证表的去重键集合
+		keySet := table.GetEffectiveKeySet(c.Sync.KeySet)
+		if keySet != "exact" && keySet != "hashed" {
+			return fmt.Errorf("table[%d] (%s): key_set must be 'exact' or 'hashed', got: %s", i, table.Name, keySet)
+		}
+		if table.BloomFilter && keySet != "hashed" {
+			return fmt.Errorf("table[%d] (%s): bloom_filter requires key_set 'hashed'", i, table.Name)
+		}
+
+		// 验证表的分段方式
+		segmentation := table.GetEffectiveSegmentation(c.Sync.Segmentation)
+		if segmentation != "day" && segmentation != "adaptive" && segmentation != "partition" {
+			return fmt.Errorf("table[%d] (%s): segmentation must be 'day', 'adaptive' or 'partition', got: %s", i, table.Name, segmentation)
+		}
+		if segmentation != "day" && table.CursorField != "" {
+			return fmt.Errorf("table[%d] (%s): segmentation '%s' cannot be used with cursor_field", i, table.Name, segmentation)
+		}
+		if segmentation == "partition" && table.Transfer == "remote" {
+			return fmt.Errorf("table[%d] (%s): segmentation 'partition' cannot be used with transfer 'remote'", i, table.Name)
+		}
+
+		// 验证表的时区
+		if timezone := table.GetEffectiveTimezone(c.Sync.Timezone); timezone != "" {
+			if _, err := time.LoadLocation(timezone); err != nil {
+				return fmt.Errorf("table[%d] (%s): invalid timezone %q: %w", i, table.Name, timezone, err)
+			}
+		}
+
+		// 验证表的写入方式
+		writeMode := table.GetEffectiveWriteMode(c.Sync.WriteMode)
+		if writeMode != "append" && writeMode != "replace_segment" {
+			return fmt.Errorf("table[%d] (%s): write_mode must be 'append' or 'replace_segment', got: %s", i, table.Name, writeMode)
+		}
+
+		// 游标字段按 ID 分段，替换写入依赖时间分段
+		if table.CursorField != "" && writeMode == "replace_segment" {
+			return fmt.Errorf("table[%d] (%s): cursor_field cannot be combined with write_mode 'replace_segment'", i, table.Name)
+		}
+
+		// 多个时间分段可能落在同一个分区：并行替换时各自判断分区已对齐，后执行的 REPLACE PARTITION 会删除先写入的分段
+		if writeMode == "replace_segment" && segmentation != "partition" && table.GetEffectiveSegmentConcurrency() > 1 {
+			return fmt.Errorf("table[%d] (%s): segment_concurrency > 1 cannot be combined with write_mode 'replace_segment' unless segmentation is 'partition'", i, table.Name)
+		}
+
+		// 验证表的传输方式
+		if table.Transfer != "" && table.Transfer != "stream" && table.Transfer != "remote" {
+			return fmt.Errorf("table[%d] (%s): transfer must be 'stream' or 'remote', got: %s", i, table.Name, table.Transfer)
+		}
 	}
 
 	if enabledCount == 0 {
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestConfig 把 tables 下的单个表配置（与 sync 配置）写入临时配置文件并加载（应用默认值）
+func loadTestConfig(t *testing.T, syncYAML, tableYAML string) *Config {
+	t.Helper()
+	content := `
+source:
+  addr: ["127.0.0.1:9000"]
+  database: "source_db"
+target:
+  addr: ["127.0.0.1:9001"]
+  database: "target_db"
+sync:
+  mode: "incremental"
+` + indent(syncYAML, "  ") + `
+tables:
+  - name: "events"
+    enabled: true
+    time_field: "created_at"
+    dedupe_keys: ["id"]
+` + indent(tableYAML, "    ")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func indent(text, prefix string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = prefix + strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestValidateRejectsUnsafeCombinations(t *testing.T) {
+	tests := []struct {
+		name      string
+		syncYAML  string
+		tableYAML string
+		wantErr   string // 为空表示配置合法
+	}{
+		{
+			name:      "parallel replace_segment by day",
+			tableYAML: "write_mode: \"replace_segment\"\nsegment_concurrency: 4",
+			wantErr:   "segment_concurrency > 1 cannot be combined with write_mode 'replace_segment'",
+		},
+		{
+			name:      "parallel replace_segment by adaptive segments",
+			syncYAML:  "write_mode: \"replace_segment\"\nsegmentation: \"adaptive\"",
+			tableYAML: "segment_concurrency: 2",
+			wantErr:   "segment_concurrency > 1 cannot be combined with write_mode 'replace_segment'",
+		},
+		{
+			name:      "parallel replace_segment by partition",
+			tableYAML: "write_mode: \"replace_segment\"\nsegmentation: \"partition\"\nsegment_concurrency: 4",
+		},
+		{
+			name:      "sequential replace_segment",
+			tableYAML: "write_mode: \"replace_segment\"",
+		},
+		{
+			name:      "parallel append",
+			tableYAML: "segment_concurrency: 4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadTestConfig(t, tt.syncYAML, tt.tableYAML).Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
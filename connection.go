@@ -2,15 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	sqldriver "database/sql/driver"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 )
 
-// ConnectClickHouse 连接到 ClickHouse 数据库
-func ConnectClickHouse(dbConfig DatabaseConfig, syncConfig SyncConfig) (*sql.DB, error) {
+// buildClickHouseOptions 构建 ClickHouse 连接参数（health 不为 nil 时由集群健康状态选择节点）
+func buildClickHouseOptions(dbConfig DatabaseConfig, syncConfig SyncConfig, health *ClusterHealth) (*clickhouse.Options, error) {
+	tlsConfig, err := buildTLSConfig(dbConfig.TLS)
+	if err != nil {
+		return nil, err
+	}
+
 	options := &clickhouse.Options{
 		Addr: dbConfig.Addr,
 		Auth: clickhouse.Auth{
@@ -25,6 +35,7 @@ func ConnectClickHouse(dbConfig DatabaseConfig, syncConfig SyncConfig) (*sql.DB,
 		Settings: clickhouse.Settings{
 			"max_execution_time": syncConfig.QueryTimeout,
 		},
+		TLS: tlsConfig,
 	}
 
 	// 如果禁用压缩，则不设置压缩
@@ -34,7 +45,98 @@ func ConnectClickHouse(dbConfig DatabaseConfig, syncConfig SyncConfig) (*sql.DB,
 		}
 	}
 
-	conn := clickhouse.OpenDB(options)
+	// HTTP 协议：路径、附加请求头和 HTTP 压缩方式
+	if dbConfig.Protocol == "http" {
+		options.Protocol = clickhouse.HTTP
+		options.HttpUrlPath = dbConfig.HTTP.Path
+		options.HttpHeaders = dbConfig.HTTP.Headers
+		if dbConfig.HTTP.Compression != "" {
+			options.Compression = &clickhouse.Compression{
+				Method: httpCompressionMethods[dbConfig.HTTP.Compression],
+				Level:  3,
+			}
+		}
+	}
+
+	// 原生协议节点的选择与故障切换在 DialContext 中完成（包括 TLS 握手），驱动只需拨号一次；
+	// HTTP 协议由 openConnector 为每个节点单独创建连接器
+	if health != nil && options.Protocol != clickhouse.HTTP {
+		options.DialContext = health.DialContext
+		options.Addr = options.Addr[:1]
+	}
+
+	return options, nil
+}
+
+// httpCompressionMethods HTTP 协议支持的压缩方式（gzip、deflate、br 为 HTTP 内容编码，lz4、zstd 为 Native 格式的块压缩）
+var httpCompressionMethods = map[string]clickhouse.CompressionMethod{
+	"none":    clickhouse.CompressionNone,
+	"gzip":    clickhouse.CompressionGZIP,
+	"deflate": clickhouse.CompressionDeflate,
+	"br":      clickhouse.CompressionBrotli,
+	"lz4":     clickhouse.CompressionLZ4,
+	"zstd":    clickhouse.CompressionZSTD,
+}
+
+// buildTLSConfig 根据 TLS 配置加载 CA 与客户端证书（未启用 TLS 时返回 nil）
+func buildTLSConfig(config TLSConfig) (*tls.Config, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		caPEM, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no valid PEM certificates found in tls ca_file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// openConnector 创建 database/sql 连接器：HTTP 协议在集群健康状态选择的节点上建立连接（见 ClusterHealth.Connector）
+func openConnector(options *clickhouse.Options, health *ClusterHealth) sqldriver.Connector {
+	if health != nil && options.Protocol == clickhouse.HTTP {
+		return health.Connector(options)
+	}
+	return clickhouse.Connector(options)
+}
+
+// poolSize 连接池大小：每个进行中的分段最多同时占用目标库 insert_workers + 1 个连接（查询已有键 + 并发写入），
+// 另外保留两个连接给元数据查询，至少 10 个
+func poolSize(syncConfig SyncConfig) int {
+	size := syncConfig.MaxConnections*(syncConfig.Pipeline.InsertWorkers+1) + 2
+	if size < 10 {
+		size = 10
+	}
+	return size
+}
+
+// ConnectClickHouse 连接到 ClickHouse 数据库（所有查询经集群限流器，新连接由集群健康状态选择节点）
+func ConnectClickHouse(dbConfig DatabaseConfig, syncConfig SyncConfig, limiter *ClusterLimiter, health *ClusterHealth) (*sql.DB, error) {
+	options, err := buildClickHouseOptions(dbConfig, syncConfig, health)
+	if err != nil {
+		return nil, err
+	}
+	conn := sql.OpenDB(&limitedConnector{Connector: openConnector(options, health), limiter: limiter})
 
 	// 测试连接
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(syncConfig.DialTimeout)*time.Second)
@@ -45,13 +147,44 @@ func ConnectClickHouse(dbConfig DatabaseConfig, syncConfig SyncConfig) (*sql.DB,
 	}
 
 	// 设置连接池参数
-	conn.SetMaxOpenConns(10)
-	conn.SetMaxIdleConns(5)
+	conn.SetMaxOpenConns(poolSize(syncConfig))
+	conn.SetMaxIdleConns(poolSize(syncConfig) / 2)
 	conn.SetConnMaxLifetime(time.Hour)
 
 	return conn, nil
 }
 
+// ConnectClickHouseNative 建立原生协议连接（用于按类型流式读取和列式批量插入，所有查询经集群限流器，
+// 新连接由集群健康状态选择节点）。protocol: http 时返回经 HTTP 协议实现的同一接口
+func ConnectClickHouseNative(dbConfig DatabaseConfig, syncConfig SyncConfig, limiter *ClusterLimiter, health *ClusterHealth) (driver.Conn, error) {
+	options, err := buildClickHouseOptions(dbConfig, syncConfig, health)
+	if err != nil {
+		return nil, err
+	}
+	if options.Protocol == clickhouse.HTTP {
+		return connectHTTP(options, syncConfig, limiter, health)
+	}
+	options.MaxOpenConns = poolSize(syncConfig)
+	options.MaxIdleConns = poolSize(syncConfig) / 2
+	options.ConnMaxLifetime = time.Hour
+
+	conn, err := clickhouse.Open(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open native connection: %w", err)
+	}
+
+	// 测试连接
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(syncConfig.DialTimeout)*time.Second)
+	defer cancel()
+
+	if err := conn.Ping(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &limitedConn{Conn: conn, limiter: limiter}, nil
+}
+
 // TestConnection 测试数据库连接
 func TestConnection(db *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert 测试用证书及其 PEM 文件
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert 生成证书：parent 为空时生成自签名 CA，否则由 parent 签发
+func newTestCert(t *testing.T, dir, name string, parent *testCert, configure func(*x509.Certificate)) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	configure(template)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	writePEM(t, tc.certFile, "CERTIFICATE", der)
+	writePEM(t, tc.keyFile, "EC PRIVATE KEY", keyDER)
+	return tc
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func caTemplate(c *x509.Certificate) {
+	c.IsCA = true
+	c.BasicConstraintsValid = true
+	c.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+}
+
+// startTLSServer 启动要求客户端证书（由 clientCA 签发）的 TLS 服务端，握手成功后向客户端写入 "ok"
+func startTLSServer(t *testing.T, server *testCert, clientCA *testCert) string {
+	t.Helper()
+	serverCert, err := tls.LoadX509KeyPair(server.certFile, server.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCA.cert)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				if err := conn.(*tls.Conn).Handshake(); err != nil {
+					return
+				}
+				conn.Write([]byte("ok"))
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// dialTLS 按配置连接并等待服务端确认握手（TLS 1.3 下客户端证书在客户端握手完成后才由服务端验证）
+func dialTLS(addr string, config TLSConfig) error {
+	tlsConfig, err := buildTLSConfig(config)
+	if err != nil {
+		return err
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, tlsConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 2)
+	if _, err := conn.Read(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+func TestBuildTLSConfigAgainstLocalListener(t *testing.T) {
+	dir := t.TempDir()
+	serverCA := newTestCert(t, dir, "server-ca", nil, caTemplate)
+	otherCA := newTestCert(t, dir, "other-ca", nil, caTemplate)
+	clientCA := newTestCert(t, dir, "client-ca", nil, caTemplate)
+	server := newTestCert(t, dir, "server", serverCA, func(c *x509.Certificate) {
+		c.DNSNames = []string{"clickhouse.test"}
+		c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	})
+	client := newTestCert(t, dir, "client", clientCA, func(c *x509.Certificate) {
+		c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	})
+	untrustedClient := newTestCert(t, dir, "untrusted-client", otherCA, func(c *x509.Certificate) {
+		c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	})
+
+	addr := startTLSServer(t, server, clientCA)
+
+	valid := TLSConfig{
+		Enabled:    true,
+		CAFile:     serverCA.certFile,
+		CertFile:   client.certFile,
+		KeyFile:    client.keyFile,
+		ServerName: "clickhouse.test",
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *TLSConfig)
+		wantErr bool
+	}{
+		{"ca bundle, client certificate and server_name", func(c *TLSConfig) {}, false},
+		{"wrong ca is rejected", func(c *TLSConfig) { c.CAFile = otherCA.certFile }, true},
+		{"system ca is rejected", func(c *TLSConfig) { c.CAFile = "" }, true},
+		{"missing client certificate", func(c *TLSConfig) { c.CertFile, c.KeyFile = "", "" }, true},
+		{"untrusted client certificate", func(c *TLSConfig) {
+			c.CertFile, c.KeyFile = untrustedClient.certFile, untrustedClient.keyFile
+		}, true},
+		{"wrong server_name", func(c *TLSConfig) { c.ServerName = "other.test" }, true},
+		{"address host is used without server_name", func(c *TLSConfig) { c.ServerName = "" }, true},
+		{"insecure_skip_verify skips server verification", func(c *TLSConfig) {
+			c.CAFile, c.ServerName, c.InsecureSkipVerify = otherCA.certFile, "other.test", true
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid
+			tt.modify(&config)
+			if config.ServerName == "" {
+				// 与驱动相同：未配置 server_name 时使用连接地址中的主机名
+				config.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+			err := dialTLS(addr, config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dial error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildTLSConfigOptions(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil, caTemplate)
+
+	config, err := buildTLSConfig(TLSConfig{})
+	if err != nil || config != nil {
+		t.Fatalf("disabled tls: config = %v, err = %v", config, err)
+	}
+
+	invalidCA := filepath.Join(dir, "invalid.pem")
+	os.WriteFile(invalidCA, []byte("not a certificate"), 0600)
+	if _, err := buildTLSConfig(TLSConfig{Enabled: true, CAFile: invalidCA}); err == nil {
+		t.Error("invalid ca_file accepted")
+	}
+	if _, err := buildTLSConfig(TLSConfig{Enabled: true, CertFile: ca.certFile, KeyFile: filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Error("missing key_file accepted")
+	}
+
+	options, err := buildClickHouseOptions(DatabaseConfig{
+		Addr: []string{"127.0.0.1:9440"},
+		TLS:  TLSConfig{Enabled: true, CAFile: ca.certFile, ServerName: "clickhouse.test"},
+	}, SyncConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.TLS == nil || options.TLS.ServerName != "clickhouse.test" || options.TLS.RootCAs == nil {
+		t.Errorf("options.TLS = %+v", options.TLS)
+	}
+}
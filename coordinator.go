@@ -8,27 +8,46 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 )
 
 // SyncCoordinator 同步协调器
 type SyncCoordinator struct {
-	sourceDB *sql.DB
-	targetDB *sql.DB
-	config   *Config
-	state    *StateManager
+	sourceDB   *sql.DB
+	targetDB   *sql.DB
+	sourceConn driver.Conn
+	targetConn driver.Conn
+	config     *Config
+	state      *StateManager
+	cycleCount int               // 智能模式已执行的循环次数（用于周期性验证）
+	budget     *ConnectionBudget // 所有表共享的连接预算
+	limiter    *ResourceLimiter  // 所有表共享的源库/目标库限流
+	health     *ClusterHealth    // 源库节点健康状态（实时同步据此判断源库是否切换了节点）
 }
 
 // NewSyncCoordinator 创建同步协调器
-func NewSyncCoordinator(sourceDB, targetDB *sql.DB, config *Config) *SyncCoordinator {
+func NewSyncCoordinator(sourceDB, targetDB *sql.DB, sourceConn, targetConn driver.Conn, config *Config, limiter *ResourceLimiter, health *ClusterHealth) *SyncCoordinator {
 	state := NewStateManager(config.Sync.StateFile)
 	return &SyncCoordinator{
-		sourceDB: sourceDB,
-		targetDB: targetDB,
-		config:   config,
-		state:    state,
+		sourceDB:   sourceDB,
+		targetDB:   targetDB,
+		sourceConn: sourceConn,
+		targetConn: targetConn,
+		config:     config,
+		state:      state,
+		budget:     NewConnectionBudget(config.Sync.MaxConnections),
+		limiter:    limiter,
+		health:     health,
 	}
 }
 
+// UpdateLimits 运行中调整限流配置，之后的读取、查询和插入按新配置限流
+func (c *SyncCoordinator) UpdateLimits(limits LimitsConfig) {
+	c.limiter.Update(limits)
+	log.Printf("🔧 限流配置已更新 - 源库: %s | 目标库: %s", describeLimits(limits.Source), describeLimits(limits.Target))
+}
+
 // SyncAllTables 并行同步所有表
 func (c *SyncCoordinator) SyncAllTables(ctx context.Context) error {
 	// 过滤出启用的表
@@ -67,12 +86,14 @@ func (c *SyncCoordinator) SyncAllTables(ctx context.Context) error {
 			c.state.MarkTableInProgress(tc.Name)
 
 			// 创建同步器
-			syncer, err := NewUniversalSyncer(tc, c.sourceDB, c.targetDB, c.config, c.state)
+			syncer, err := NewUniversalSyncer(tc, c.sourceDB, c.targetDB, c.sourceConn, c.targetConn, c.config, c.state)
 			if err != nil {
 				log.Printf("❌ %s: 创建同步器失败: %v", tc.Name, err)
 				errChan <- fmt.Errorf("%s: %w", tc.Name, err)
 				return
 			}
+			syncer.budget = c.budget
+			syncer.sourceHealth = c.health
 
 			// 执行同步
 			startTime := time.Now()
@@ -82,6 +103,9 @@ func (c *SyncCoordinator) SyncAllTables(ctx context.Context) error {
 					log.Printf("⏭️  %s: 源表为空，跳过同步", tc.Name)
 					return
 				}
+				if errors.Is(err, ErrValidationFailed) {
+					c.state.MarkTableFailed(tc.Name)
+				}
 				log.Printf("❌ %s: 同步失败: %v", tc.Name, err)
 				errChan <- fmt.Errorf("%s: %w", tc.Name, err)
 				return
@@ -145,6 +169,11 @@ func (c *SyncCoordinator) SyncAllTablesWithSmartMode(ctx context.Context, realti
 		len(enabledTables), c.config.Sync.MaxConcurrency)
 	log.Printf("⚙️  实时模式阈值: %s（延迟超过此值将先追平历史数据）", FormatDuration(realtimeThreshold))
 
+	// 每 N 次循环在实时同步后执行一次验证
+	c.cycleCount++
+	interval := c.config.Sync.RealtimeValidationInterval
+	validateRealtime := interval > 0 && c.cycleCount%interval == 0
+
 	// 并发控制
 	semaphore := make(chan struct{}, c.config.Sync.MaxConcurrency)
 	errChan := make(chan error, len(enabledTables))
@@ -166,13 +195,17 @@ func (c *SyncCoordinator) SyncAllTablesWithSmartMode(ctx context.Context, realti
 			c.state.MarkTableInProgress(tc.Name)
 
 			// 创建同步器
-			syncer, err := NewUniversalSyncer(tc, c.sourceDB, c.targetDB, c.config, c.state)
+			syncer, err := NewUniversalSyncer(tc, c.sourceDB, c.targetDB, c.sourceConn, c.targetConn, c.config, c.state)
 			if err != nil {
 				log.Printf("❌ %s: 创建同步器失败: %v", tc.Name, err)
 				errChan <- fmt.Errorf("%s: %w", tc.Name, err)
 				return
 			}
 
+			syncer.validateRealtime = validateRealtime
+			syncer.budget = c.budget
+			syncer.sourceHealth = c.health
+
 			// 执行智能同步
 			startTime := time.Now()
 			if err := syncer.SyncWithRealtimeMode(ctx, realtimeThreshold); err != nil {
@@ -181,6 +214,9 @@ func (c *SyncCoordinator) SyncAllTablesWithSmartMode(ctx context.Context, realti
 					log.Printf("⏭️  %s: 源表为空，跳过同步", tc.Name)
 					return
 				}
+				if errors.Is(err, ErrValidationFailed) {
+					c.state.MarkTableFailed(tc.Name)
+				}
 				log.Printf("❌ %s: 同步失败: %v", tc.Name, err)
 				errChan <- fmt.Errorf("%s: %w", tc.Name, err)
 				return
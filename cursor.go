@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SegmentRange 分段的查询范围：Field >= Start AND Field < End，或 Partition 非空时为单个分区
+// 时间分段、ID 分段与分区共用，去重、读取、写入与验证都按该范围构建查询
+type SegmentRange struct {
+	Field     string // 范围字段（分区范围中只用于排序）
+	Start     interface{}
+	End       interface{}
+	Partition string // 分区 ID（_partition_id）
+}
+
+// TimeSegmentRange 把时间分段转换为查询范围
+func TimeSegmentRange(field string, segment TimeSegment) SegmentRange {
+	return SegmentRange{Field: field, Start: segment.Start, End: segment.End}
+}
+
+// Where 返回范围条件（参数由 Args 提供）
+// 时间边界按纳秒精度的 UTC 时刻比较，与字段是 DateTime 还是 DateTime64(3/6/9)、字段声明的时区无关
+func (r SegmentRange) Where() string {
+	if r.Partition != "" {
+		return "_partition_id = ?"
+	}
+	_, startOK := r.Start.(time.Time)
+	_, endOK := r.End.(time.Time)
+	if startOK && endOK {
+		return fmt.Sprintf("%s >= toDateTime64(?, 9, 'UTC') AND %s < toDateTime64(?, 9, 'UTC')", r.Field, r.Field)
+	}
+	return fmt.Sprintf("%s >= ? AND %s < ?", r.Field, r.Field)
+}
+
+// Args 返回范围条件的参数
+func (r SegmentRange) Args() []interface{} {
+	if r.Partition != "" {
+		return []interface{}{r.Partition}
+	}
+	start, startOK := r.Start.(time.Time)
+	end, endOK := r.End.(time.Time)
+	if startOK && endOK {
+		return []interface{}{bindTime(start), bindTime(end)}
+	}
+	return []interface{}{r.Start, r.End}
+}
+
+// String 返回用于日志的范围描述
+func (r SegmentRange) String() string {
+	if r.Partition != "" {
+		return "分区 " + r.Partition
+	}
+	return fmt.Sprintf("%s ~ %s", formatCursorValue(r.Start), formatCursorValue(r.End))
+}
+
+// formatCursorValue 格式化范围边界
+func formatCursorValue(val interface{}) string {
+	if t, ok := val.(time.Time); ok {
+		return t.Format("2006-01-02 15:04:05 MST")
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// queryRower 可执行单行查询的连接（连接池 *sql.DB，或固定在同一副本上的 *sql.Conn）
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// syncCursor 同步游标：同步进度由游标字段的取值推导，历史追平与实时同步都通过游标查询
+// 源库/目标库的边界并构建分段的查询范围。时间字段游标的值为 time.Time，ID 字段游标的值为 uint64
+type syncCursor[T any] interface {
+	Max(ctx context.Context, db queryRower) (T, bool, error) // 字段最大值，表为空（或值无效）时 ok 为 false
+	Min(ctx context.Context, db queryRower) (T, bool, error) // 字段最小值，表为空（或值无效）时 ok 为 false
+	Range(start, end T) SegmentRange                         // [start, end) 的查询范围
+}
+
+var (
+	_ syncCursor[time.Time] = timeCursor{}
+	_ syncCursor[uint64]    = idCursor{}
+)
+
+// timeCursor 时间字段游标：同步进度由目标库时间字段的最大值推导
+type timeCursor struct {
+	field     string
+	tableName string
+	location  *time.Location // 查询结果转换到的时区
+}
+
+// Max 查询时间字段的最大有效值（ClickHouse 有效范围内且不超过当前时间 24 小时），
+// 表为空或时间无效时 ok 为 false
+func (c timeCursor) Max(ctx context.Context, db queryRower) (time.Time, bool, error) {
+	return c.aggregate(ctx, db, "MAX")
+}
+
+// Min 查询时间字段的最小有效值，表为空或时间无效时 ok 为 false
+func (c timeCursor) Min(ctx context.Context, db queryRower) (time.Time, bool, error) {
+	return c.aggregate(ctx, db, "MIN")
+}
+
+// Range 返回时间分段 [start, end) 的查询范围
+func (c timeCursor) Range(start, end time.Time) SegmentRange {
+	return SegmentRange{Field: c.field, Start: start, End: end}
+}
+
+func (c timeCursor) aggregate(ctx context.Context, db queryRower, fn string) (time.Time, bool, error) {
+	query := fmt.Sprintf("SELECT %s(%s) FROM %s", fn, c.field, c.tableName)
+
+	var value sql.NullTime
+	err := db.QueryRowContext(ctx, query).Scan(&value)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, false, fmt.Errorf("failed to query %s %s: %w", fn, c.field, err)
+	}
+
+	// ClickHouse 有效范围: 1900-01-01 到 2262-04-11
+	minValidTime := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxFutureTime := time.Now().Add(24 * time.Hour)
+	valid := value.Valid && value.Time.After(minValidTime) && value.Time.Before(maxFutureTime)
+	if c.location != nil {
+		return value.Time.In(c.location), valid, nil
+	}
+	return value.Time, valid, nil
+}
+
+// IDSegment ID 分段 [Start, End)
+type IDSegment struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// idCursor 单调递增 ID 字段游标：同步进度为最后同步的 ID，记录在状态文件中
+type idCursor struct {
+	field       string
+	tableName   string
+	segmentSize uint64
+}
+
+// Max 查询 ID 字段的最大值，表为空时 ok 为 false
+func (c idCursor) Max(ctx context.Context, db queryRower) (uint64, bool, error) {
+	return c.aggregate(ctx, db, "max")
+}
+
+// Min 查询 ID 字段的最小值，表为空时 ok 为 false
+func (c idCursor) Min(ctx context.Context, db queryRower) (uint64, bool, error) {
+	return c.aggregate(ctx, db, "min")
+}
+
+func (c idCursor) aggregate(ctx context.Context, db queryRower, fn string) (uint64, bool, error) {
+	// 非 Nullable 整数列在空表上 max/min 返回 0，需要结合 count 判断
+	query := fmt.Sprintf("SELECT toUInt64(%s(%s)), count() FROM %s", fn, c.field, c.tableName)
+
+	var value, count uint64
+	if err := db.QueryRowContext(ctx, query).Scan(&value, &count); err != nil {
+		return 0, false, fmt.Errorf("failed to query %s %s: %w", fn, c.field, err)
+	}
+	return value, count > 0, nil
+}
+
+// Segments 把 [start, max] 按分段大小拆分为 ID 分段
+func (c idCursor) Segments(start, max uint64) []IDSegment {
+	segments := []IDSegment{}
+	for lo := start; lo <= max; {
+		hi := lo + c.segmentSize
+		if hi > max || hi < lo {
+			hi = max + 1
+		}
+		segments = append(segments, IDSegment{Start: lo, End: hi})
+		if hi <= lo {
+			break // max 为 uint64 最大值时溢出
+		}
+		lo = hi
+	}
+	return segments
+}
+
+// Range 返回 ID 分段 [start, end) 的查询范围
+func (c idCursor) Range(start, end uint64) SegmentRange {
+	return SegmentRange{Field: c.field, Start: start, End: end}
+}
+
+// idCursor 返回表的 ID 游标
+func (s *UniversalSyncer) idCursor() idCursor {
+	return idCursor{
+		field:       s.tableConfig.CursorField,
+		tableName:   s.tableName,
+		segmentSize: uint64(s.tableConfig.GetEffectiveCursorSegmentSize()),
+	}
+}
+
+// cursorSync 按单调递增 ID 同步：从最后同步的 ID 之后按 ID 分段同步到源库最大 ID
+// 历史追平与实时循环使用同一流程，不依赖时间字段，因此不受延迟到达的时间戳影响
+func (s *UniversalSyncer) cursorSync(ctx context.Context) error {
+	cursor := s.idCursor()
+
+	// 1. 查询源库最大 ID
+	sourceMax, ok, err := cursor.Max(ctx, s.sourceDB)
+	if err != nil {
+		return fmt.Errorf("failed to query source max %s: %w", cursor.field, err)
+	}
+	if !ok {
+		log.Printf("⏭️  %s: 源库无数据，跳过同步", s.tableName)
+		return ErrSourceTableEmpty
+	}
+
+	// 2. 确定起始 ID：状态文件 → 目标库最大 ID → 源库最小 ID
+	var start uint64
+	if last, found := s.state.GetLastCursor(s.tableName); found {
+		start = last + 1
+	} else if targetMax, ok, err := cursor.Max(ctx, s.targetDB); err != nil {
+		return fmt.Errorf("failed to query target max %s: %w", cursor.field, err)
+	} else if ok {
+		start = targetMax + 1
+		log.Printf("🔍 %s: 检测到目标库最大 %s = %d，从该值之后开始同步", s.tableName, cursor.field, targetMax)
+	} else if sourceMin, _, err := cursor.Min(ctx, s.sourceDB); err != nil {
+		return fmt.Errorf("failed to query source min %s: %w", cursor.field, err)
+	} else {
+		start = sourceMin
+		log.Printf("📊 %s: 目标库为空，从源库最小 %s = %d 开始同步", s.tableName, cursor.field, sourceMin)
+	}
+
+	if start > sourceMax {
+		return nil // 已是最新
+	}
+
+	// 3. 按 ID 分段同步
+	segments := cursor.Segments(start, sourceMax)
+	if len(segments) > 1 {
+		log.Printf("📦 %s: 同步 %s 范围 %d ~ %d，分为 %d 个分段",
+			s.tableName, cursor.field, start, sourceMax, len(segments))
+	}
+
+	totalRecords := 0
+	for i, segment := range segments {
+		r := cursor.Range(segment.Start, segment.End)
+
+		// 与时间分段相同，每个分段占用连接预算中的一个名额（游标按顺序推进，分段不并行）
+		if err := s.budget.Acquire(ctx); err != nil {
+			return err
+		}
+		recordCount, err := s.syncCursorSegment(ctx, r)
+		s.budget.Release()
+		if err != nil {
+			return fmt.Errorf("%s range %s: %w", cursor.field, r, err)
+		}
+		totalRecords += recordCount
+
+		s.state.MarkCursorSegmentCompleted(s.tableName, segment, recordCount)
+
+		if len(segments) > 1 {
+			log.Printf("✅ %s: 分段 %d/%d 完成，同步 %d 条记录", s.tableName, i+1, len(segments), recordCount)
+		}
+	}
+
+	if totalRecords > 0 {
+		log.Printf("✅ %s: 同步完成，新增 %d 条记录（%s 已到 %d）", s.tableName, totalRecords, cursor.field, sourceMax)
+	}
+	return nil
+}
+
+// syncCursorSegment 同步并验证一个 ID 分段，验证结果记录到状态文件（未通过则返回错误，游标不推进）
+func (s *UniversalSyncer) syncCursorSegment(ctx context.Context, r SegmentRange) (int, error) {
+	var recordCount int
+	var err error
+	if s.tableConfig.Transfer == "remote" {
+		recordCount, err = s.syncSegmentRemote(ctx, r)
+	} else {
+		recordCount, err = s.copyRange(ctx, r)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to sync: %w", err)
+	}
+
+	if s.config.Sync.SkipValidation {
+		return recordCount, nil
+	}
+	result, err := s.validator.ValidateRange(ctx, s.tableName, r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to validate segment: %w", err)
+	}
+	result.Range = r.String()
+	s.state.RecordValidation(s.tableName, *result)
+
+	if !result.Passed {
+		log.Printf("❌ %s: 分段 %s 验证失败 - 源库 %d 条，目标库 %d 条 (%.2f%%) %s",
+			s.tableName, r, result.SourceCount, result.TargetCount,
+			result.Ratio()*100, formatMismatches(result.Mismatches))
+		return 0, s.validator.validationError(result)
+	}
+	return recordCount, nil
+}
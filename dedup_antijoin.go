@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// 目标库侧反连接去重：源库数据按批次缓冲，把多个批次的去重键写入目标库的临时键表，
+// 再读取目标表该时间段中键在键表内的行，找出已存在的键。内存占用只与键块大小有关，与分段内的记录数无关。
+// 键表是临时表（会话结束时由服务端删除，进程崩溃不会留下键表），只存在于创建它的连接上，
+// 因此键表的创建、写入、连接查询与删除都在同一个固定的目标库连接上执行
+
+// antiJoinChunkRows 一次连接查询最多携带的键数：流水线把多个批次合并为一个键块，
+// 目标表分段范围按键块扫描一次，而不是每个批次扫描一次
+const antiJoinChunkRows = 50000
+
+// dedupKeyTable 去重键表及其所在的目标库连接
+type dedupKeyTable struct {
+	name     string
+	conn     *sql.Conn
+	settings clickhouse.Settings // 键表语句附加的设置（HTTP 协议下临时表依赖会话）
+}
+
+// context 为键表上的语句附加会话设置
+func (kt *dedupKeyTable) context(ctx context.Context) context.Context {
+	return withQuerySettings(ctx, kt.settings)
+}
+
+// createKeyTable 在固定的目标库连接上创建本分段使用的临时去重键表
+func (s *UniversalSyncer) createKeyTable(ctx context.Context) (*dedupKeyTable, error) {
+	name := fmt.Sprintf("_ch_sync_keys_%s_%d",
+		strings.ReplaceAll(s.tableName, ".", "_"), time.Now().UnixNano())
+
+	columnDefs := make([]string, len(s.tableConfig.DedupeKeys))
+	for i, key := range s.tableConfig.DedupeKeys {
+		col := s.tableSchema.GetColumn(key)
+		if col == nil {
+			return nil, fmt.Errorf("dedupe key %s not found in table %s", key, s.tableName)
+		}
+		columnDefs[i] = fmt.Sprintf("%s %s", col.Name, col.Type)
+	}
+
+	conn, err := s.targetDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pin target connection: %w", err)
+	}
+
+	keyTable := &dedupKeyTable{name: name, conn: conn, settings: clickhouse.Settings{}}
+	if s.config.Target.Protocol == "http" {
+		// HTTP 协议每条语句是独立的请求，临时表只在同一个会话中可见
+		keyTable.settings["session_id"] = name
+		keyTable.settings["session_timeout"] = 3600
+	}
+
+	query := fmt.Sprintf("CREATE TEMPORARY TABLE %s (%s) ENGINE = Memory", name, strings.Join(columnDefs, ", "))
+	if _, err := conn.ExecContext(keyTable.context(ctx), query); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create key table: %w", err)
+	}
+	return keyTable, nil
+}
+
+// dropKeyTable 删除去重键表并归还连接（使用独立 context，保证分段失败时也能清理）
+func (s *UniversalSyncer) dropKeyTable(keyTable *dedupKeyTable) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	keyTable.conn.ExecContext(keyTable.context(ctx), fmt.Sprintf("DROP TEMPORARY TABLE IF EXISTS %s", keyTable.name))
+	keyTable.conn.Close()
+}
+
+// filterExistingRows 通过目标库反连接剔除键块（多个批次）中已存在的记录，返回跳过与按新版本保留的条数
+func (s *UniversalSyncer) filterExistingRows(ctx context.Context, batches []*RowBatch, r SegmentRange, keyTable *dedupKeyTable) (int, int, error) {
+	total := 0
+	for _, batch := range batches {
+		total += batch.Len()
+	}
+	if total == 0 {
+		return 0, 0, nil
+	}
+
+	keys := s.tableConfig.DedupeKeys
+	keysStr := strings.Join(keys, ", ")
+	ctx = keyTable.context(ctx)
+
+	// 1. 清空键表并写入键块的去重键
+	if _, err := keyTable.conn.ExecContext(ctx, fmt.Sprintf("TRUNCATE TABLE %s", keyTable.name)); err != nil {
+		return 0, 0, fmt.Errorf("failed to truncate key table: %w", err)
+	}
+
+	keyBatch := &sqlBatch{
+		ctx:    ctx,
+		db:     keyTable.conn,
+		query:  fmt.Sprintf("INSERT INTO %s (%s)", keyTable.name, keysStr),
+		filled: make(map[int]int),
+	}
+	for _, batch := range batches {
+		for i, idx := range s.deduplicator.keyIndexes {
+			if err := keyBatch.Column(i).Append(batch.Column(idx)); err != nil {
+				return 0, 0, fmt.Errorf("failed to append key column %s: %w", keys[i], err)
+			}
+		}
+	}
+	if err := keyBatch.Send(); err != nil {
+		return 0, 0, fmt.Errorf("failed to send key batch: %w", err)
+	}
+
+	// 2. 读取目标表该时间段中键在键表内的行（配置了版本字段时同时读取版本）：
+	// IN 右侧是键表（只有键块大小），目标表分段范围按主键顺序扫描，不在服务端构建整个分段的键集合
+	columns := s.deduplicator.keyQueryColumns()
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s AND (%s) IN (SELECT %s FROM %s)",
+		strings.Join(columns, ", "), s.tableName, r.Where(), keysStr, keysStr, keyTable.name,
+	)
+
+	// NULL 键视为相等（与内存去重中 <NULL> 的处理保持一致）
+	queryCtx := withQuerySettings(ctx, clickhouse.Settings{
+		"transform_null_in": 1,
+	})
+	result, err := keyTable.conn.QueryContext(queryCtx, query, r.Args()...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to anti-join keys: %w", err)
+	}
+	rows := &sqlRows{Rows: result}
+	defer rows.Close()
+
+	scanner, err := NewRowScanner(s.tableSchema, columns)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	existingKeys := s.deduplicator.NewKeySet()
+	values := make([]interface{}, len(keys))
+	for rows.Next() {
+		if err := scanner.Scan(rows); err != nil {
+			return 0, 0, fmt.Errorf("failed to scan key: %w", err)
+		}
+		s.deduplicator.AddScanned(existingKeys, scanner, values)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("error iterating keys: %w", err)
+	}
+
+	if existingKeys.Len() == 0 {
+		return 0, 0, nil
+	}
+
+	// 3. 剔除已存在的记录（源库版本更新的记录保留）
+	skipped, updated := 0, 0
+	for _, batch := range batches {
+		keep := make([]bool, batch.Len())
+		for row := range keep {
+			action := s.deduplicator.Classify(existingKeys,
+				s.deduplicator.BatchKeyValues(batch, row), s.deduplicator.BatchVersion(batch, row))
+			keep[row] = action != rowSkip
+			switch action {
+			case rowSkip:
+				skipped++
+			case rowUpdate:
+				updated++
+			}
+		}
+		batch.Filter(keep)
+	}
+
+	return skipped, updated, nil
+}
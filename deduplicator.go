@@ -1,33 +1,57 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"strings"
-	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 )
 
 // Deduplicator 去重器
 type Deduplicator struct {
-	dedupeKeys []string // 去重字段列表
-	timeField  string   // 时间字段（用于查询范围）
+	dedupeKeys   []string    // 去重字段列表
+	timeField    string      // 时间字段（用于查询范围）
+	versionField string      // 版本字段（为空表示不检测更新）
+	versionIndex int         // 版本字段在查询字段中的下标（由 BindColumns 设置）
+	keyIndexes   []int       // 去重字段在查询字段中的下标（由 BindColumns 设置）
+	encoder      *KeyEncoder // 去重键编码器（由 BindColumns 按字段类型设置）
+	rowEncoder   *KeyEncoder // 整行编码器，用于批次内容指纹（由 BindColumns 按查询字段类型设置）
+	keySetType   string      // 键集合类型："exact" 或 "hashed"
+	bloomFilter  bool        // hashed 键集合是否启用布隆过滤器预检查
 }
 
 // NewDeduplicator 创建去重器
-func NewDeduplicator(dedupeKeys []string, timeField string) *Deduplicator {
+func NewDeduplicator(dedupeKeys []string, timeField, versionField string, keySetType string, bloomFilter bool) *Deduplicator {
 	return &Deduplicator{
-		dedupeKeys: dedupeKeys,
-		timeField:  timeField,
+		dedupeKeys:   dedupeKeys,
+		timeField:    timeField,
+		versionField: versionField,
+		versionIndex: -1,
+		keySetType:   keySetType,
+		bloomFilter:  bloomFilter,
+		encoder:      NewKeyEncoder(nil),
+		rowEncoder:   NewKeyEncoder(nil),
+	}
+}
+
+// NewKeySet 按配置创建空的去重键集合
+func (d *Deduplicator) NewKeySet() KeySet {
+	if d.keySetType == "hashed" {
+		return newHashedKeySet(d.encoder, d.bloomFilter)
 	}
+	return newExactKeySet(d.encoder)
 }
 
-// FetchExistingKeys 查询目标库已存在的去重键
+// FetchExistingKeys 查询目标库已存在的去重键（配置了版本字段时同时记录每个键的最大版本）
+// 使用原生连接按字段类型扫描，保证与源库扫描结果的 Go 类型一致
 func (d *Deduplicator) FetchExistingKeys(
-	db *sql.DB,
+	ctx context.Context,
+	conn driver.Conn,
 	tableName string,
-	segment TimeSegment,
+	r SegmentRange,
 	schema *TableSchema,
-) (map[string]bool, error) {
+) (KeySet, error) {
 	// 验证所有去重字段是否存在于目标表中
 	missingKeys := []string{}
 	for _, key := range d.dedupeKeys {
@@ -42,35 +66,30 @@ func (d *Deduplicator) FetchExistingKeys(
 	}
 
 	// 构建查询 SQL
-	keysStr := strings.Join(d.dedupeKeys, ", ")
-	query := fmt.Sprintf(
-		"SELECT %s FROM %s WHERE %s >= ? AND %s < ?",
-		keysStr, tableName, d.timeField, d.timeField,
-	)
+	columns := d.keyQueryColumns()
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(columns, ", "), tableName, r.Where())
 
-	rows, err := db.Query(query, segment.Start, segment.End)
+	rows, err := conn.Query(ctx, query, r.Args()...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	existingKeys := make(map[string]bool)
+	scanner, err := NewRowScanner(schema, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	existingKeys := d.NewKeySet()
+	values := make([]interface{}, len(d.dedupeKeys))
 
 	for rows.Next() {
 		// 扫描去重字段
-		values := make([]interface{}, len(d.dedupeKeys))
-		valuePtrs := make([]interface{}, len(d.dedupeKeys))
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		if err := rows.Scan(valuePtrs...); err != nil {
+		if err := scanner.Scan(rows); err != nil {
 			return nil, err
 		}
 
-		// 构建复合键
-		key := d.buildKeyFromValues(values)
-		existingKeys[key] = true
+		d.AddScanned(existingKeys, scanner, values)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -80,48 +99,135 @@ func (d *Deduplicator) FetchExistingKeys(
 	return existingKeys, nil
 }
 
-// BuildKey 从记录构建去重键
-func (d *Deduplicator) BuildKey(record map[string]interface{}) string {
-	values := make([]interface{}, len(d.dedupeKeys))
+// keyQueryColumns 查询已存在键时需要的字段：去重字段 + 版本字段
+func (d *Deduplicator) keyQueryColumns() []string {
+	columns := append([]string{}, d.dedupeKeys...)
+	if d.versionField != "" {
+		columns = append(columns, d.versionField)
+	}
+	return columns
+}
+
+// AddScanned 把按 keyQueryColumns 顺序扫描的一行加入键集合（values 为复用的缓冲区）
+func (d *Deduplicator) AddScanned(keySet KeySet, scanner *RowScanner, values []interface{}) {
+	for i := range values {
+		values[i] = scanner.Value(i)
+	}
+	if d.versionField != "" {
+		keySet.AddVersion(values, scanner.Value(len(values)))
+	} else {
+		keySet.Add(values)
+	}
+}
+
+// BindColumns 根据查询字段顺序计算去重字段下标，并按字段类型创建键编码器与整行编码器
+func (d *Deduplicator) BindColumns(schema *TableSchema, columns []string) {
+	keyColumns := make([]ColumnInfo, len(d.dedupeKeys))
 	for i, key := range d.dedupeKeys {
-		values[i] = record[key]
+		if col := schema.GetColumn(key); col != nil {
+			keyColumns[i] = *col
+		}
+	}
+	d.encoder = NewKeyEncoder(keyColumns)
+
+	rowColumns := make([]ColumnInfo, len(columns))
+	for i, name := range columns {
+		if col := schema.GetColumn(name); col != nil {
+			rowColumns[i] = *col
+		}
 	}
-	return d.buildKeyFromValues(values)
+	d.rowEncoder = NewKeyEncoder(rowColumns)
+
+	d.versionIndex = -1
+	for j, col := range columns {
+		if d.versionField != "" && col == d.versionField {
+			d.versionIndex = j
+			break
+		}
+	}
+
+	d.keyIndexes = make([]int, len(d.dedupeKeys))
+	for i, key := range d.dedupeKeys {
+		d.keyIndexes[i] = -1
+		for j, col := range columns {
+			if col == key {
+				d.keyIndexes[i] = j
+				break
+			}
+		}
+	}
+}
+
+// RowKeyValues 按下标从扫描器当前行读取去重字段的值
+func (d *Deduplicator) RowKeyValues(scanner *RowScanner) []interface{} {
+	values := make([]interface{}, len(d.keyIndexes))
+	for i, idx := range d.keyIndexes {
+		if idx >= 0 {
+			values[i] = scanner.Value(idx)
+		}
+	}
+	return values
 }
 
-// buildKeyFromValues 从值列表构建复合键
-func (d *Deduplicator) buildKeyFromValues(values []interface{}) string {
-	parts := make([]string, len(values))
-	for i, val := range values {
-		parts[i] = d.formatValue(val)
+// BatchKeyValues 按下标从列式行缓冲的第 row 行读取去重字段的值
+func (d *Deduplicator) BatchKeyValues(batch *RowBatch, row int) []interface{} {
+	values := make([]interface{}, len(d.keyIndexes))
+	for i, idx := range d.keyIndexes {
+		if idx >= 0 {
+			values[i] = batch.Value(idx, row)
+		}
 	}
-	return strings.Join(parts, "|") // 用 | 分隔多个字段
+	return values
 }
 
-// formatValue 格式化值为字符串
-func (d *Deduplicator) formatValue(val interface{}) string {
-	if val == nil {
-		return "<NULL>"
-	}
-
-	switch v := val.(type) {
-	case time.Time:
-		return v.Format(time.RFC3339Nano)
-	case string:
-		return v
-	case []byte:
-		return string(v)
-	case int, int8, int16, int32, int64:
-		return fmt.Sprintf("%d", v)
-	case uint, uint8, uint16, uint32, uint64:
-		return fmt.Sprintf("%d", v)
-	case float32, float64:
-		return fmt.Sprintf("%f", v)
-	case bool:
-		return fmt.Sprintf("%t", v)
+// rowAction 去重判定结果
+type rowAction int
+
+const (
+	rowInsert rowAction = iota // 键不存在，插入
+	rowUpdate                  // 键已存在但源库版本更新，重新插入
+	rowSkip                    // 键已存在，跳过
+)
+
+// Classify 判定一行是否需要写入：键不存在时插入；配置了版本字段且源库版本更新时重新插入
+func (d *Deduplicator) Classify(existingKeys KeySet, keyValues []interface{}, version interface{}) rowAction {
+	if d.versionField == "" {
+		if existingKeys.Contains(keyValues) {
+			return rowSkip
+		}
+		return rowInsert
+	}
+
+	existing, exists := existingKeys.Version(keyValues)
+	switch {
+	case !exists:
+		return rowInsert
+	case compareVersions(version, existing) > 0:
+		return rowUpdate
 	default:
-		return fmt.Sprintf("%v", v)
+		return rowSkip
+	}
+}
+
+// RowVersion 读取扫描器当前行的版本值
+func (d *Deduplicator) RowVersion(scanner *RowScanner) interface{} {
+	if d.versionIndex < 0 {
+		return nil
+	}
+	return scanner.Value(d.versionIndex)
+}
+
+// BatchVersion 读取列式行缓冲第 row 行的版本值
+func (d *Deduplicator) BatchVersion(batch *RowBatch, row int) interface{} {
+	if d.versionIndex < 0 {
+		return nil
 	}
+	return batch.Value(d.versionIndex, row)
+}
+
+// GetVersionField 获取版本字段
+func (d *Deduplicator) GetVersionField() string {
+	return d.versionField
 }
 
 // GetDedupeKeys 获取去重字段列表
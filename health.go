@@ -0,0 +1,573 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// ErrAllNodesUnavailable 集群所有节点都处于熔断状态，不再尝试连接
+var ErrAllNodesUnavailable = errors.New("all nodes are unavailable (circuit open)")
+
+// breakerState 节点熔断器状态
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // 正常
+	breakerOpen                         // 熔断：open_duration 内不再连接该节点
+	breakerHalfOpen                     // 试探：熔断时间结束后允许连接，成功则恢复，失败则再次熔断
+)
+
+// nodeHealth 单个节点的健康状态
+type nodeHealth struct {
+	addr     string
+	state    breakerState
+	failures int           // 连续失败次数
+	openedAt time.Time     // 最近一次熔断的时间
+	lag      time.Duration // 最近一次探测到的复制延迟（system.replicas.absolute_delay 的最大值）
+	epoch    int           // 不再是活动节点的次数，此前建立的连接视为过期
+	conns    map[*trackedConn]struct{}
+	probeDB  *sql.DB // 只连接该节点的探测连接（不经限流器）
+}
+
+// ClusterHealth 集群各节点的健康状态：连续失败的节点熔断，周期性探测恢复情况与复制延迟，
+// 新连接优先使用复制延迟最低的健康节点（活动节点）。活动节点切换时输出日志并递增切换代数，
+// 实时同步据此确定地判断源库是否发生了切换
+type ClusterHealth struct {
+	name        string // 日志中的集群名称（源库/目标库）
+	config      HealthConfig
+	dialTimeout time.Duration
+	tls         *tls.Config // 拨号后进行 TLS 握手（为 nil 时不握手）
+	nodes       []*nodeHealth
+
+	mu         sync.Mutex
+	active     int            // 活动节点下标
+	generation int            // 活动节点切换次数
+	seen       map[string]int // 各表上次检查时的切换代数
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewClusterHealth 为配置中的每个地址创建健康状态（初始活动节点为第一个地址）
+func NewClusterHealth(name string, dbConfig DatabaseConfig, syncConfig SyncConfig) (*ClusterHealth, error) {
+	tlsConfig, err := buildTLSConfig(dbConfig.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	// HTTP 协议由 http.Transport 在返回的连接上完成 TLS 握手
+	if dbConfig.Protocol == "http" {
+		tlsConfig = nil
+	}
+
+	h := &ClusterHealth{
+		name:        name,
+		config:      syncConfig.Health,
+		dialTimeout: time.Duration(syncConfig.DialTimeout) * time.Second,
+		tls:         tlsConfig,
+		seen:        make(map[string]int),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, addr := range dbConfig.Addr {
+		nodeConfig := dbConfig
+		nodeConfig.Addr = []string{addr}
+		options, err := buildClickHouseOptions(nodeConfig, syncConfig, nil)
+		if err != nil {
+			return nil, err
+		}
+		probeDB := clickhouse.OpenDB(options)
+		probeDB.SetMaxOpenConns(1)
+
+		h.nodes = append(h.nodes, &nodeHealth{
+			addr:    addr,
+			conns:   make(map[*trackedConn]struct{}),
+			probeDB: probeDB,
+		})
+	}
+	return h, nil
+}
+
+// Start 立即探测一次所有节点（选出初始活动节点），之后每隔 probe_interval 探测一次
+func (h *ClusterHealth) Start() {
+	h.Probe()
+	if len(h.nodes) > 1 {
+		log.Printf("📍 %s活动节点: %s（共 %d 个节点）", h.name, h.ActiveNode(), len(h.nodes))
+	}
+
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(time.Duration(h.config.ProbeInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.Probe()
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close 停止探测并关闭探测连接
+func (h *ClusterHealth) Close() {
+	close(h.stop)
+	<-h.done
+	for _, node := range h.nodes {
+		node.probeDB.Close()
+	}
+}
+
+// Probe 探测各节点：连接测试通过后查询复制延迟，然后重新选择活动节点
+// 熔断中的节点在 open_duration 结束前不探测，结束后的探测即为试探
+func (h *ClusterHealth) Probe() {
+	for i, node := range h.nodes {
+		if !h.available(i) {
+			continue
+		}
+		lag, err := probeNode(node.probeDB)
+		if err != nil {
+			h.recordFailure(i, err)
+			continue
+		}
+		h.recordSuccess(i, lag)
+	}
+
+	h.mu.Lock()
+	h.selectActive()
+	h.mu.Unlock()
+}
+
+// probeNode 测试节点连接并查询复制延迟（没有复制表或无权限查询 system.replicas 时视为无延迟）
+func probeNode(db *sql.DB) (time.Duration, error) {
+	if err := TestConnection(db); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var delay uint64
+	if err := db.QueryRowContext(ctx, "SELECT max(absolute_delay) FROM system.replicas").Scan(&delay); err != nil {
+		return 0, nil
+	}
+	return time.Duration(delay) * time.Second, nil
+}
+
+// ReplicaDelay 查询表在当前连接所在副本上的复制延迟（system.replicas.absolute_delay），
+// 非复制表或无权限查询 system.replicas 时视为无延迟
+func ReplicaDelay(ctx context.Context, db queryRower, tableName string) time.Duration {
+	query := "SELECT absolute_delay FROM system.replicas WHERE database = currentDatabase() AND table = ?"
+
+	var delay uint64
+	if err := db.QueryRowContext(ctx, query, tableName).Scan(&delay); err != nil {
+		return 0
+	}
+	return time.Duration(delay) * time.Second
+}
+
+// DialContext 原生协议的驱动建立新连接时调用（驱动传入的是第一个节点的地址）：依次尝试活动节点和其余可用节点，
+// 连接失败计入熔断器。所有节点都熔断时直接返回 ErrAllNodesUnavailable，不再反复连接故障节点
+func (h *ClusterHealth) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	// HTTP 协议经代理（HTTP_PROXY 等环境变量）访问时请求的是代理地址，直接连接
+	if !h.isNode(addr) {
+		dialer := net.Dialer{Timeout: h.dialTimeout}
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	candidates := h.candidates()
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%s: %w", h.name, ErrAllNodesUnavailable)
+	}
+
+	var lastErr error
+	for _, i := range candidates {
+		conn, err := h.dialNode(ctx, h.nodes[i].addr)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			h.recordFailure(i, err)
+			lastErr = err
+			continue
+		}
+		h.recordSuccess(i, -1)
+		return h.track(i, conn), nil
+	}
+	return nil, lastErr
+}
+
+// Connector 返回 HTTP 协议的 database/sql 连接器。HTTP 驱动按拨号地址生成请求 URL（Host 头），
+// 由 http.Transport 按同一地址验证 TLS 证书，因此不能像原生协议那样在拨号时替换节点：
+// 每个节点单独创建一个驱动连接器，新连接按活动节点优先的顺序直接连接所选节点
+func (h *ClusterHealth) Connector(options *clickhouse.Options) driver.Connector {
+	c := &healthConnector{health: h}
+	for i, node := range h.nodes {
+		nodeOptions := *options
+		nodeOptions.Addr = []string{node.addr}
+		nodeOptions.DialContext = h.nodeDialer(i)
+		c.nodes = append(c.nodes, clickhouse.Connector(&nodeOptions))
+	}
+	return c
+}
+
+// nodeDialer 只连接指定节点的拨号函数（HTTP 连接断开后 http.Transport 会重新拨号），连接失败计入熔断器
+func (h *ClusterHealth) nodeDialer(i int) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		// 经代理访问时请求的是代理地址，直接连接
+		if addr != h.nodes[i].addr {
+			dialer := net.Dialer{Timeout: h.dialTimeout}
+			return dialer.DialContext(ctx, "tcp", addr)
+		}
+
+		conn, err := h.dialNode(ctx, addr)
+		if err != nil {
+			if ctx.Err() == nil {
+				h.recordFailure(i, err)
+			}
+			return nil, err
+		}
+		h.recordSuccess(i, -1)
+		return h.track(i, conn), nil
+	}
+}
+
+// healthConnector 按节点健康状态选择节点的 database/sql 连接器（HTTP 协议）
+type healthConnector struct {
+	health *ClusterHealth
+	nodes  []driver.Connector // 与 health.nodes 一一对应
+}
+
+// Connect 依次尝试活动节点和其余可用节点
+func (c *healthConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	candidates := c.health.candidates()
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%s: %w", c.health.name, ErrAllNodesUnavailable)
+	}
+
+	var lastErr error
+	for _, i := range candidates {
+		epoch := c.health.epoch(i)
+		conn, err := c.nodes[i].Connect(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		std, ok := conn.(stdConn)
+		if !ok {
+			conn.Close()
+			return nil, fmt.Errorf("unexpected database/sql connection type %T", conn)
+		}
+		return &nodeSQLConn{stdConn: std, health: c.health, node: i, epoch: epoch}, nil
+	}
+	return nil, lastErr
+}
+
+// Driver 返回驱动
+func (c *healthConnector) Driver() driver.Driver {
+	return c.nodes[0].Driver()
+}
+
+// nodeSQLConn 记录所属节点的 database/sql 连接，活动节点切换后不再复用
+type nodeSQLConn struct {
+	stdConn
+	health *ClusterHealth
+	node   int
+	epoch  int
+}
+
+// ResetSession database/sql 复用连接前调用：所属节点已不是活动节点时丢弃连接，之后的查询转到新的活动节点
+func (c *nodeSQLConn) ResetSession(ctx context.Context) error {
+	if c.health.expired(c.node, c.epoch) {
+		return driver.ErrBadConn
+	}
+	return c.stdConn.ResetSession(ctx)
+}
+
+// isNode 地址是否为集群节点
+func (h *ClusterHealth) isNode(addr string) bool {
+	for _, node := range h.nodes {
+		if node.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// dialNode 建立到节点的连接，启用 TLS 时完成握手（握手失败同样计入熔断器）
+func (h *ClusterHealth) dialNode(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: h.dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil || h.tls == nil {
+		return conn, err
+	}
+
+	// 与驱动自身的 TLS 拨号一致：未配置 server_name 时按地址中的主机名验证证书
+	config := h.tls.Clone()
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			config.ServerName = host
+		}
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake with %s failed: %w", addr, err)
+	}
+	return tlsConn, nil
+}
+
+// candidates 可连接的节点：活动节点优先，其余按熔断状态（正常优先于试探）、复制延迟和配置顺序排列
+func (h *ClusterHealth) candidates() []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	order := []int{}
+	if h.availableLocked(h.active) {
+		order = append(order, h.active)
+	}
+	for _, state := range []breakerState{breakerClosed, breakerHalfOpen} {
+		rest := []int{}
+		for i, node := range h.nodes {
+			if i != h.active && node.state == state && h.availableLocked(i) {
+				rest = append(rest, i)
+			}
+		}
+		for j := 1; j < len(rest); j++ {
+			for k := j; k > 0 && h.nodes[rest[k]].lag < h.nodes[rest[k-1]].lag; k-- {
+				rest[k], rest[k-1] = rest[k-1], rest[k]
+			}
+		}
+		order = append(order, rest...)
+	}
+	return order
+}
+
+// available 节点当前是否允许连接
+func (h *ClusterHealth) available(i int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.availableLocked(i)
+}
+
+// availableLocked 节点当前是否允许连接，熔断时间已结束的节点转为试探状态（调用方持有锁）
+func (h *ClusterHealth) availableLocked(i int) bool {
+	node := h.nodes[i]
+	if node.state == breakerOpen {
+		if time.Since(node.openedAt) < time.Duration(h.config.OpenDuration)*time.Second {
+			return false
+		}
+		node.state = breakerHalfOpen
+	}
+	return true
+}
+
+// recordSuccess 记录节点连接或探测成功，lag 为负数表示复制延迟未知（保留上次探测结果）
+func (h *ClusterHealth) recordSuccess(i int, lag time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node := h.nodes[i]
+	if node.state != breakerClosed {
+		log.Printf("✅ %s节点 %s 已恢复", h.name, node.addr)
+	}
+	node.state = breakerClosed
+	node.failures = 0
+	if lag >= 0 {
+		node.lag = lag
+	}
+}
+
+// recordConnError 已建立的连接在查询中读写失败（连接被重置、超时等）时计入熔断器；
+// 对端正常关闭（EOF，如服务端关闭空闲连接）和本端主动关闭不计入
+func (h *ClusterHealth) recordConnError(i int, err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	h.recordFailure(i, err)
+}
+
+// recordFailure 记录节点连接、探测或查询失败：连续失败达到 failure_threshold 或试探失败时熔断节点，
+// 关闭到该节点的已有连接；熔断的是活动节点时立即切换
+func (h *ClusterHealth) recordFailure(i int, err error) {
+	h.mu.Lock()
+	node := h.nodes[i]
+	node.failures++
+	if node.state == breakerOpen || (node.state == breakerClosed && node.failures < h.config.FailureThreshold) {
+		h.mu.Unlock()
+		return
+	}
+
+	node.state = breakerOpen
+	node.openedAt = time.Now()
+	conns := node.conns
+	node.conns = make(map[*trackedConn]struct{})
+	log.Printf("🚫 %s节点 %s 熔断（连续失败 %d 次），%d 秒内不再连接: %v",
+		h.name, node.addr, node.failures, h.config.OpenDuration, err)
+	if i == h.active {
+		h.selectActive()
+	}
+	h.mu.Unlock()
+
+	// 在锁外关闭：连接关闭时需要加锁注销自身
+	for conn := range conns {
+		conn.Close()
+	}
+}
+
+// selectActive 重新选择活动节点（调用方持有锁）：活动节点熔断时切换到复制延迟最低的健康节点；
+// 活动节点正常时，只有其复制延迟比最优节点多出 lag_tolerance 以上才切换。没有健康节点时保持不变
+func (h *ClusterHealth) selectActive() {
+	best := -1
+	for i, node := range h.nodes {
+		if node.state == breakerClosed && (best < 0 || node.lag < h.nodes[best].lag) {
+			best = i
+		}
+	}
+	if best < 0 || best == h.active {
+		return
+	}
+
+	current := h.nodes[h.active]
+	var reason string
+	if current.state != breakerClosed {
+		reason = "原节点不可用"
+	} else {
+		tolerance := time.Duration(h.config.LagTolerance) * time.Second
+		if current.lag <= h.nodes[best].lag+tolerance {
+			return
+		}
+		reason = fmt.Sprintf("复制延迟 %s → %s", FormatDuration(current.lag), FormatDuration(h.nodes[best].lag))
+	}
+
+	log.Printf("🔀 %s活动节点切换: %s → %s（%s）", h.name, current.addr, h.nodes[best].addr, reason)
+	current.epoch++
+	h.active = best
+	h.generation++
+}
+
+// epoch 返回节点当前的过期代数
+func (h *ClusterHealth) epoch(i int) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.nodes[i].epoch
+}
+
+// expired 在 epoch 时建立的到节点 i 的连接是否已过期（之后该节点不再是活动节点）
+func (h *ClusterHealth) expired(i, epoch int) bool {
+	return h.epoch(i) != epoch
+}
+
+// ActiveNode 返回当前活动节点的地址
+func (h *ClusterHealth) ActiveNode() string {
+	if h == nil {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.nodes[h.active].addr
+}
+
+// Available 集群是否有允许连接的节点（所有节点都熔断时返回 false）
+func (h *ClusterHealth) Available() bool {
+	if h == nil {
+		return true
+	}
+	return len(h.candidates()) > 0
+}
+
+// NodeChanged 返回自该表上次检查以来活动节点是否切换过，以及当前活动节点（第一次检查时视为未切换）
+func (h *ClusterHealth) NodeChanged(table string) (bool, string) {
+	if h == nil {
+		return false, ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	last, ok := h.seen[table]
+	h.seen[table] = h.generation
+	return ok && last != h.generation, h.nodes[h.active].addr
+}
+
+// trackedConn 记录所属节点的连接，节点熔断时由 ClusterHealth 主动关闭，读写错误计入节点的熔断器
+type trackedConn struct {
+	net.Conn
+	health *ClusterHealth
+	node   int
+	epoch  int
+	once   sync.Once
+}
+
+// errConnExpired 连接所属节点已不是活动节点
+var errConnExpired = errors.New("connection to inactive node expired")
+
+// track 登记到节点的新连接
+func (h *ClusterHealth) track(i int, conn net.Conn) *trackedConn {
+	tracked := &trackedConn{Conn: conn, health: h, node: i}
+	h.mu.Lock()
+	tracked.epoch = h.nodes[i].epoch
+	h.nodes[i].conns[tracked] = struct{}{}
+	h.mu.Unlock()
+	return tracked
+}
+
+// Read 读取数据，失败时计入所属节点的熔断器
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.health.recordConnError(c.node, err)
+	}
+	return n, err
+}
+
+// Write 写入数据，失败时计入所属节点的熔断器
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		c.health.recordConnError(c.node, err)
+	}
+	return n, err
+}
+
+// SyscallConn 原生协议的驱动从连接池取出空闲连接时经此检查连接是否可用：所属节点已不是活动节点时返回错误，
+// 驱动随即关闭该连接并重新拨号（转到新的活动节点）；正在执行的查询不受影响
+func (c *trackedConn) SyscallConn() (syscall.RawConn, error) {
+	if c.health.expired(c.node, c.epoch) {
+		return nil, errConnExpired
+	}
+	return idleRawConn{}, nil
+}
+
+// idleRawConn 不访问套接字的 syscall.RawConn：驱动的空闲检查直接视为通过
+// （直接读取 TLS 连接的套接字会误读服务端握手后发送的会话票据）
+type idleRawConn struct{}
+
+func (idleRawConn) Control(func(fd uintptr)) error    { return nil }
+func (idleRawConn) Read(func(fd uintptr) bool) error  { return nil }
+func (idleRawConn) Write(func(fd uintptr) bool) error { return nil }
+
+// Close 关闭连接并注销
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.health.mu.Lock()
+		delete(c.health.nodes[c.node].conns, c)
+		c.health.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// newTestHealth 不带探测连接的集群健康状态（初始活动节点为第一个地址）
+func newTestHealth(config HealthConfig, addrs ...string) *ClusterHealth {
+	h := &ClusterHealth{
+		name:        "测试库",
+		config:      config,
+		dialTimeout: time.Second,
+		seen:        make(map[string]int),
+	}
+	for _, addr := range addrs {
+		h.nodes = append(h.nodes, &nodeHealth{addr: addr, conns: make(map[*trackedConn]struct{})})
+	}
+	return h
+}
+
+// reopenAfterDuration 把节点的熔断时间提前到 open_duration 之前
+func reopenAfterDuration(h *ClusterHealth, i int) {
+	h.mu.Lock()
+	h.nodes[i].openedAt = time.Now().Add(-time.Duration(h.config.OpenDuration+1) * time.Second)
+	h.mu.Unlock()
+}
+
+func TestBreakerOpenHalfOpenClose(t *testing.T) {
+	h := newTestHealth(HealthConfig{FailureThreshold: 2, OpenDuration: 30}, "a:9000", "b:9000")
+	errDial := syscall.ECONNREFUSED
+
+	h.recordFailure(0, errDial)
+	if h.nodes[0].state != breakerClosed || !h.available(0) {
+		t.Fatalf("state after 1 failure = %v, want closed", h.nodes[0].state)
+	}
+
+	// 连续失败达到阈值：熔断，活动节点切换到 b
+	h.recordFailure(0, errDial)
+	if h.nodes[0].state != breakerOpen || h.available(0) {
+		t.Fatalf("state after 2 failures = %v, want open", h.nodes[0].state)
+	}
+	if got := h.ActiveNode(); got != "b:9000" {
+		t.Fatalf("ActiveNode() = %s, want b:9000", got)
+	}
+	if got := h.candidates(); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("candidates() = %v, want [1]", got)
+	}
+
+	// 熔断时间结束后转为试探，试探失败一次即再次熔断
+	reopenAfterDuration(h, 0)
+	if !h.available(0) || h.nodes[0].state != breakerHalfOpen {
+		t.Fatalf("state after open_duration = %v, want half-open", h.nodes[0].state)
+	}
+	h.recordFailure(0, errDial)
+	if h.nodes[0].state != breakerOpen {
+		t.Fatalf("state after half-open failure = %v, want open", h.nodes[0].state)
+	}
+
+	// 试探成功则恢复，连续失败次数清零
+	reopenAfterDuration(h, 0)
+	h.available(0)
+	h.recordSuccess(0, 0)
+	if h.nodes[0].state != breakerClosed || h.nodes[0].failures != 0 {
+		t.Fatalf("state after half-open success = %v (failures %d), want closed", h.nodes[0].state, h.nodes[0].failures)
+	}
+
+	// 所有节点熔断时不可用
+	for i := range h.nodes {
+		h.recordFailure(i, errDial)
+		h.recordFailure(i, errDial)
+	}
+	if h.Available() {
+		t.Fatal("Available() = true with all nodes open")
+	}
+	if _, err := h.DialContext(context.Background(), "a:9000"); err == nil || !strings.Contains(err.Error(), ErrAllNodesUnavailable.Error()) {
+		t.Fatalf("DialContext() = %v, want %v", err, ErrAllNodesUnavailable)
+	}
+}
+
+// failingConn 读写都返回指定错误的连接
+type failingConn struct {
+	net.Conn
+	err    error
+	closed bool
+}
+
+func (c *failingConn) Read([]byte) (int, error)  { return 0, c.err }
+func (c *failingConn) Write([]byte) (int, error) { return 0, c.err }
+func (c *failingConn) Close() error              { c.closed = true; return nil }
+
+func TestQueryErrorsOpenBreaker(t *testing.T) {
+	h := newTestHealth(HealthConfig{FailureThreshold: 2, OpenDuration: 30}, "a:9000", "b:9000")
+
+	// 服务端正常关闭连接（EOF）不计入
+	eof := h.track(0, &failingConn{err: io.EOF})
+	for i := 0; i < 3; i++ {
+		eof.Read(make([]byte, 1))
+	}
+	if h.nodes[0].failures != 0 {
+		t.Fatalf("failures after EOF = %d, want 0", h.nodes[0].failures)
+	}
+
+	// 查询中连接被重置：计入熔断器，熔断后关闭到该节点的其余连接
+	idle := &failingConn{err: io.EOF}
+	h.track(0, idle)
+	reset := h.track(0, &failingConn{err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}})
+	reset.Read(make([]byte, 1))
+	reset.Write([]byte("SELECT 1"))
+
+	if h.nodes[0].state != breakerOpen {
+		t.Fatalf("state after query errors = %v, want open", h.nodes[0].state)
+	}
+	if !idle.closed || len(h.nodes[0].conns) != 0 {
+		t.Fatalf("connections to open node not closed (closed=%v, tracked=%d)", idle.closed, len(h.nodes[0].conns))
+	}
+	if got := h.ActiveNode(); got != "b:9000" {
+		t.Fatalf("ActiveNode() = %s, want b:9000", got)
+	}
+}
+
+func TestSelectActiveByLag(t *testing.T) {
+	h := newTestHealth(HealthConfig{FailureThreshold: 1, OpenDuration: 30, LagTolerance: 10}, "a:9000", "b:9000", "c:9000")
+	h.NodeChanged("events")
+
+	setLags := func(lags ...time.Duration) {
+		for i, lag := range lags {
+			h.recordSuccess(i, lag)
+		}
+		h.mu.Lock()
+		h.selectActive()
+		h.mu.Unlock()
+	}
+
+	// 活动节点的延迟比最优节点多出不到 lag_tolerance 时不切换
+	setLags(12*time.Second, 5*time.Second, 8*time.Second)
+	if changed, active := h.NodeChanged("events"); changed || active != "a:9000" {
+		t.Fatalf("NodeChanged() = %v, %s; want false, a:9000", changed, active)
+	}
+
+	// 超出 lag_tolerance：切换到延迟最低的节点
+	setLags(30*time.Second, 5*time.Second, 8*time.Second)
+	if changed, active := h.NodeChanged("events"); !changed || active != "b:9000" {
+		t.Fatalf("NodeChanged() = %v, %s; want true, b:9000", changed, active)
+	}
+
+	// 其余候选节点按延迟排序，熔断中的节点不参与选择
+	if got := h.candidates(); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 0 {
+		t.Fatalf("candidates() = %v, want [1 2 0]", got)
+	}
+	h.recordFailure(2, syscall.ECONNREFUSED)
+	setLags(30*time.Second, 20*time.Second)
+	if got := h.ActiveNode(); got != "b:9000" {
+		t.Fatalf("ActiveNode() = %s, want b:9000 (c is open)", got)
+	}
+
+	// 活动节点熔断时立即切换，不考虑 lag_tolerance
+	h.recordFailure(1, syscall.ECONNREFUSED)
+	if got := h.ActiveNode(); got != "a:9000" {
+		t.Fatalf("ActiveNode() = %s, want a:9000", got)
+	}
+}
+
+func TestNodeSwitchExpiresConnections(t *testing.T) {
+	h := newTestHealth(HealthConfig{FailureThreshold: 1, OpenDuration: 30, LagTolerance: 0}, "a:9000", "b:9000")
+	old := h.track(0, &failingConn{})
+	if _, err := old.SyscallConn(); err != nil {
+		t.Fatalf("SyscallConn() before switch = %v, want nil", err)
+	}
+
+	h.recordSuccess(0, 10*time.Second)
+	h.recordSuccess(1, 0)
+	h.mu.Lock()
+	h.selectActive()
+	h.mu.Unlock()
+
+	// 到原活动节点的空闲连接在下次取出时被驱动丢弃，新连接不受影响
+	if _, err := old.SyscallConn(); err == nil {
+		t.Fatal("SyscallConn() after switch = nil, want expired")
+	}
+	if current := h.track(1, &failingConn{}); current.health.expired(1, current.epoch) {
+		t.Fatal("connection to new active node expired")
+	}
+	if err := (&nodeSQLConn{health: h, node: 0, epoch: 0}).ResetSession(context.Background()); err == nil {
+		t.Fatal("ResetSession() on connection to previous node = nil, want driver.ErrBadConn")
+	}
+}
+
+// HTTP 协议的请求发往健康状态选择的节点，Host 头与该节点一致
+func TestHTTPConnectorUsesSelectedNode(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		hosts = map[string][]string{}
+	)
+	newNode := func() *httptest.Server {
+		var srv *httptest.Server
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hosts[srv.Listener.Addr().String()] = append(hosts[srv.Listener.Addr().String()], r.Host)
+			mu.Unlock()
+			http.Error(w, "Code: 999. test node", http.StatusInternalServerError)
+		}))
+		return srv
+	}
+	nodeA, nodeB := newNode(), newNode()
+	defer nodeA.Close()
+	defer nodeB.Close()
+	addrA, addrB := nodeA.Listener.Addr().String(), nodeB.Listener.Addr().String()
+
+	h := newTestHealth(HealthConfig{FailureThreshold: 1, OpenDuration: 30}, addrA, addrB)
+	h.recordFailure(0, syscall.ECONNREFUSED)
+
+	options := &clickhouse.Options{
+		Protocol:    clickhouse.HTTP,
+		Addr:        []string{addrA, addrB},
+		DialTimeout: time.Second,
+	}
+	if _, err := h.Connector(options).Connect(context.Background()); err == nil {
+		t.Fatal("Connect() = nil, want server error")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hosts[addrA]) != 0 {
+		t.Fatalf("open node received requests: %v", hosts[addrA])
+	}
+	if len(hosts[addrB]) == 0 || hosts[addrB][0] != addrB {
+		t.Fatalf("requests to %s used Host %v, want %s", addrB, hosts[addrB], addrB)
+	}
+}
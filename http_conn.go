@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// errHTTPUnsupported HTTP 协议连接不支持的操作（同步流程不使用这些操作）
+var errHTTPUnsupported = errors.New("operation is not supported over the http protocol")
+
+// connectHTTP 经 HTTP 协议建立连接池，并以 driver.Conn 接口提供流式读取和批量插入
+// clickhouse-go 的 clickhouse.Open 只支持原生协议，HTTP 协议只能通过 database/sql 使用
+func connectHTTP(options *clickhouse.Options, syncConfig SyncConfig, limiter *ClusterLimiter, health *ClusterHealth) (driver.Conn, error) {
+	db := sql.OpenDB(openConnector(options, health))
+	db.SetMaxOpenConns(poolSize(syncConfig))
+	db.SetMaxIdleConns(poolSize(syncConfig) / 2)
+	db.SetConnMaxLifetime(time.Hour)
+
+	// 测试连接
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(syncConfig.DialTimeout)*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &limitedConn{Conn: &httpConn{db: db}, limiter: limiter}, nil
+}
+
+// httpConn 基于 database/sql（HTTP 协议）实现的 driver.Conn
+// 查询结果按行扫描到与原生协议相同的扫描目标；批量插入在客户端按行缓冲，发送时由驱动组装为一个 Native 格式的块
+type httpConn struct {
+	db *sql.DB
+}
+
+func (c *httpConn) Contributors() []string { return nil }
+
+func (c *httpConn) ServerVersion() (*driver.ServerVersion, error) {
+	return nil, errHTTPUnsupported
+}
+
+func (c *httpConn) Select(ctx context.Context, dest any, query string, args ...any) error {
+	return errHTTPUnsupported
+}
+
+func (c *httpConn) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlRows{Rows: rows}, nil
+}
+
+func (c *httpConn) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	return &sqlRow{row: c.db.QueryRowContext(ctx, query, args...)}
+}
+
+func (c *httpConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	return &sqlBatch{ctx: ctx, db: c.db, query: query, filled: make(map[int]int)}, nil
+}
+
+func (c *httpConn) Exec(ctx context.Context, query string, args ...any) error {
+	_, err := c.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (c *httpConn) AsyncInsert(ctx context.Context, query string, wait bool, args ...any) error {
+	return errHTTPUnsupported
+}
+
+func (c *httpConn) Ping(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+func (c *httpConn) Stats() driver.Stats {
+	stats := c.db.Stats()
+	return driver.Stats{
+		MaxOpenConns: stats.MaxOpenConnections,
+		Open:         stats.OpenConnections,
+		Idle:         stats.Idle,
+	}
+}
+
+func (c *httpConn) Close() error {
+	return c.db.Close()
+}
+
+// sqlRows database/sql 查询结果的 driver.Rows 实现（HTTP 协议连接与固定的源库连接使用，不提供列类型与 totals）
+type sqlRows struct {
+	*sql.Rows
+	scanners []any // 复用的扫描包装
+}
+
+// Scan 扫描当前行（与原生协议的扫描目标相同）
+func (r *sqlRows) Scan(dest ...any) error {
+	if len(r.scanners) != len(dest) {
+		r.scanners = make([]any, len(dest))
+		for i := range r.scanners {
+			r.scanners[i] = &directScanner{}
+		}
+	}
+	for i, d := range dest {
+		r.scanners[i].(*directScanner).dest = d
+	}
+	return r.Rows.Scan(r.scanners...)
+}
+
+func (r *sqlRows) ScanStruct(dest any) error { return errHTTPUnsupported }
+
+func (r *sqlRows) ColumnTypes() []driver.ColumnType { return nil }
+
+func (r *sqlRows) Totals(dest ...any) error { return errHTTPUnsupported }
+
+func (r *sqlRows) Columns() []string {
+	columns, _ := r.Rows.Columns()
+	return columns
+}
+
+// sqlRow database/sql 单行查询结果的 driver.Row 实现
+type sqlRow struct {
+	row *sql.Row
+}
+
+func (r *sqlRow) Err() error { return r.row.Err() }
+
+func (r *sqlRow) Scan(dest ...any) error {
+	scanners := make([]any, len(dest))
+	for i, d := range dest {
+		scanners[i] = &directScanner{dest: d}
+	}
+	return r.row.Scan(scanners...)
+}
+
+func (r *sqlRow) ScanStruct(dest any) error { return errHTTPUnsupported }
+
+// directScanner 把驱动返回的值直接赋给扫描目标。database/sql 会优先调用目标自身的 sql.Scanner
+// （如 decimal.Decimal 只接受字符串和数字），而驱动返回的已经是与目标相同的 Go 类型
+type directScanner struct {
+	dest any
+}
+
+func (s *directScanner) Scan(src any) error {
+	target := reflect.ValueOf(s.dest).Elem()
+	if src == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	value := reflect.ValueOf(src)
+	switch {
+	case value.Type().AssignableTo(target.Type()):
+		target.Set(value)
+	case target.Kind() == reflect.Ptr && value.Type().AssignableTo(target.Type().Elem()):
+		// Nullable 字段的扫描目标为指针，驱动返回的是值
+		ptr := reflect.New(target.Type().Elem())
+		ptr.Elem().Set(value)
+		target.Set(ptr)
+	case value.Type().ConvertibleTo(target.Type()):
+		target.Set(value.Convert(target.Type()))
+	default:
+		return fmt.Errorf("cannot scan %T into %T", src, s.dest)
+	}
+	return nil
+}
+
+// txBeginner 可开始事务的 database/sql 连接（连接池 *sql.DB，或固定的 *sql.Conn）
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// sqlConn database/sql 的连接池 *sql.DB 或固定的连接 *sql.Conn
+type sqlConn interface {
+	txBeginner
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqlBatch 经 database/sql 的批量插入（HTTP 协议连接与固定的目标库连接使用）：
+// 按行缓冲，Send 时在一个事务中写入（驱动把整个事务作为一次 INSERT 发送）
+type sqlBatch struct {
+	ctx    context.Context
+	db     txBeginner
+	query  string
+	rows   [][]any
+	filled map[int]int // 按列追加时各列已填充的行数
+	sent   bool
+}
+
+func (b *sqlBatch) Abort() error {
+	b.rows = nil
+	return nil
+}
+
+func (b *sqlBatch) Append(v ...any) error {
+	b.rows = append(b.rows, v)
+	return nil
+}
+
+func (b *sqlBatch) AppendStruct(v any) error { return errHTTPUnsupported }
+
+func (b *sqlBatch) Column(i int) driver.BatchColumn {
+	return &sqlBatchColumn{batch: b, index: i}
+}
+
+func (b *sqlBatch) Flush() error { return nil }
+
+func (b *sqlBatch) Send() error {
+	if b.sent {
+		return errors.New("batch has already been sent")
+	}
+	b.sent = true
+
+	tx, err := b.db.BeginTx(b.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(b.ctx, b.query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, row := range b.rows {
+		if _, err := stmt.ExecContext(b.ctx, row...); err != nil {
+			return fmt.Errorf("failed to append row: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (b *sqlBatch) IsSent() bool { return b.sent }
+
+func (b *sqlBatch) Rows() int { return len(b.rows) }
+
+// set 设置第 row 行第 col 列的值（按列追加时行按需扩展）
+func (b *sqlBatch) set(col, row int, v any) {
+	for len(b.rows) <= row {
+		b.rows = append(b.rows, nil)
+	}
+	for len(b.rows[row]) <= col {
+		b.rows[row] = append(b.rows[row], nil)
+	}
+	b.rows[row][col] = v
+}
+
+// sqlBatchColumn 按列追加到 sqlBatch
+type sqlBatchColumn struct {
+	batch *sqlBatch
+	index int
+}
+
+// Append 追加整列数据（切片，与原生协议的列式追加相同）
+func (c *sqlBatchColumn) Append(v any) error {
+	values := reflect.ValueOf(v)
+	if values.Kind() != reflect.Slice {
+		return fmt.Errorf("column append expects a slice, got %T", v)
+	}
+	for i := 0; i < values.Len(); i++ {
+		if err := c.AppendRow(values.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendRow 追加一个值
+func (c *sqlBatchColumn) AppendRow(v any) error {
+	row := c.batch.filled[c.index]
+	c.batch.set(c.index, row, v)
+	c.batch.filled[c.index] = row + 1
+	return nil
+}
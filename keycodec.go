@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// keyKind 去重字段的值类别（由 ClickHouse 字段类型决定）
+type keyKind int
+
+const (
+	keyKindOther keyKind = iota
+	keyKindString
+	keyKindInt
+	keyKindUint
+	keyKindFloat32
+	keyKindFloat64
+	keyKindDecimal
+	keyKindTime
+	keyKindUUID
+	keyKindBool
+)
+
+// KeyEncoder 去重键编码器
+// 按字段类型把值归一化后编码为：空值标记 + 长度前缀 + 内容，
+// 同一个值无论扫描为何种 Go 类型（string / *string、decimal / string 等）都得到相同的字节序列
+type KeyEncoder struct {
+	kinds []keyKind
+}
+
+// NewKeyEncoder 根据去重字段的类型创建编码器
+func NewKeyEncoder(columns []ColumnInfo) *KeyEncoder {
+	kinds := make([]keyKind, len(columns))
+	for i, col := range columns {
+		kinds[i] = keyKindOf(col.Type)
+	}
+	return &KeyEncoder{kinds: kinds}
+}
+
+// keyKindOf 把 ClickHouse 字段类型映射为值类别
+func keyKindOf(typeStr string) keyKind {
+	base := unwrapType(typeStr)
+	switch {
+	case base == "String", strings.HasPrefix(base, "FixedString("), strings.HasPrefix(base, "Enum"):
+		return keyKindString
+	case base == "Int8", base == "Int16", base == "Int32", base == "Int64":
+		return keyKindInt
+	case base == "UInt8", base == "UInt16", base == "UInt32", base == "UInt64":
+		return keyKindUint
+	case base == "Float32":
+		return keyKindFloat32
+	case base == "Float64":
+		return keyKindFloat64
+	case strings.HasPrefix(base, "Decimal"):
+		return keyKindDecimal
+	case strings.HasPrefix(base, "Date"):
+		return keyKindTime
+	case base == "UUID":
+		return keyKindUUID
+	case base == "Bool":
+		return keyKindBool
+	default:
+		return keyKindOther
+	}
+}
+
+// Encode 把一组去重字段值追加编码到 buf
+func (e *KeyEncoder) Encode(buf []byte, values []interface{}) []byte {
+	for i, val := range values {
+		kind := keyKindOther
+		if i < len(e.kinds) {
+			kind = e.kinds[i]
+		}
+		buf = appendKeyValue(buf, kind, val)
+	}
+	return buf
+}
+
+// Key 返回一组去重字段值的编码字符串
+func (e *KeyEncoder) Key(values []interface{}) string {
+	return string(e.Encode(nil, values))
+}
+
+// appendKeyValue 追加单个值：0 表示 NULL；1 + uvarint 长度 + 归一化内容
+func appendKeyValue(buf []byte, kind keyKind, val interface{}) []byte {
+	// Nullable 字段的扫描结果为指针，统一解引用
+	val = derefValue(val)
+	if val == nil {
+		return append(buf, 0)
+	}
+
+	var scratch [16]byte
+	payload := normalizeKeyValue(scratch[:0], kind, val)
+
+	buf = append(buf, 1)
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	return append(buf, payload...)
+}
+
+// normalizeKeyValue 按值类别输出归一化内容，无法按类别转换的值退回文本表示
+func normalizeKeyValue(out []byte, kind keyKind, val interface{}) []byte {
+	switch kind {
+	case keyKindString:
+		switch v := val.(type) {
+		case string:
+			return append(out, v...)
+		case []byte:
+			return append(out, v...)
+		}
+
+	case keyKindInt:
+		if n, ok := toInt64(val); ok {
+			return binary.BigEndian.AppendUint64(out, uint64(n))
+		}
+
+	case keyKindUint:
+		if n, ok := toUint64(val); ok {
+			return binary.BigEndian.AppendUint64(out, n)
+		}
+
+	case keyKindFloat32, keyKindFloat64:
+		if f, ok := toFloat64(val); ok {
+			if kind == keyKindFloat32 {
+				f = float64(float32(f))
+			}
+			return binary.BigEndian.AppendUint64(out, canonicalFloatBits(f))
+		}
+
+	case keyKindDecimal:
+		if d, ok := toDecimal(val); ok {
+			// String 去掉末尾多余的 0，使 1.50 与 1.5 编码一致
+			return append(out, d.String()...)
+		}
+
+	case keyKindTime:
+		if t, ok := val.(time.Time); ok {
+			// 秒 + 纳秒，与时区无关且覆盖 DateTime64 的完整范围
+			out = binary.BigEndian.AppendUint64(out, uint64(t.Unix()))
+			return binary.BigEndian.AppendUint32(out, uint32(t.Nanosecond()))
+		}
+
+	case keyKindUUID:
+		switch v := val.(type) {
+		case string:
+			return append(out, strings.ToLower(v)...)
+		case fmt.Stringer:
+			return append(out, strings.ToLower(v.String())...)
+		}
+
+	case keyKindBool:
+		switch v := val.(type) {
+		case bool:
+			if v {
+				return append(out, 1)
+			}
+			return append(out, 0)
+		default:
+			if n, ok := toInt64(val); ok {
+				if n != 0 {
+					return append(out, 1)
+				}
+				return append(out, 0)
+			}
+		}
+	}
+
+	switch v := val.(type) {
+	case string:
+		return append(out, v...)
+	case []byte:
+		return append(out, v...)
+	default:
+		return appendTextValue(out, reflect.ValueOf(v))
+	}
+}
+
+// appendTextValue 输出其它类型（Array、Map、Tuple 等）的归一化内容：元素逐个按长度前缀编码，
+// 指针解引用（Array(Nullable(T)) 扫描为指针切片，直接格式化会输出地址，跨进程不稳定），Map 按键排序
+func appendTextValue(out []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return out
+		}
+		return appendTextValue(out, v.Elem())
+
+	case reflect.Slice, reflect.Array:
+		out = binary.AppendUvarint(out, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			out = appendNestedValue(out, v.Index(i))
+		}
+		return out
+
+	case reflect.Map:
+		entries := make([][]byte, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entry := appendNestedValue(nil, iter.Key())
+			entries = append(entries, appendNestedValue(entry, iter.Value()))
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i], entries[j]) < 0 })
+		out = binary.AppendUvarint(out, uint64(len(entries)))
+		for _, entry := range entries {
+			out = append(out, entry...)
+		}
+		return out
+
+	case reflect.Struct:
+		// time.Time、decimal.Decimal 等按值编码，Tuple 等结构体逐字段编码
+		if v.CanInterface() {
+			switch val := v.Interface().(type) {
+			case time.Time:
+				return normalizeKeyValue(out, keyKindTime, val)
+			case fmt.Stringer:
+				return append(out, val.String()...)
+			}
+		}
+		for i := 0; i < v.NumField(); i++ {
+			out = appendNestedValue(out, v.Field(i))
+		}
+		return out
+
+	default:
+		return fmt.Appendf(out, "%v", v)
+	}
+}
+
+// appendNestedValue 追加嵌套元素：0 表示空指针；1 + uvarint 长度 + 内容
+func appendNestedValue(out []byte, v reflect.Value) []byte {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return append(out, 0)
+	}
+	payload := appendTextValue(nil, v)
+	out = append(out, 1)
+	out = binary.AppendUvarint(out, uint64(len(payload)))
+	return append(out, payload...)
+}
+
+// canonicalFloatBits 返回浮点数的规范位表示（-0 与 0 相同，所有 NaN 相同）
+func canonicalFloatBits(f float64) uint64 {
+	switch {
+	case f == 0:
+		return 0
+	case math.IsNaN(f):
+		return math.Float64bits(math.NaN())
+	default:
+		return math.Float64bits(f)
+	}
+}
+
+func toInt64(val interface{}) (int64, bool) {
+	switch v := val.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint:
+		if uint64(v) <= math.MaxInt64 {
+			return int64(v), true
+		}
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v), true
+		}
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func toUint64(val interface{}) (uint64, bool) {
+	switch v := val.(type) {
+	case uint:
+		return uint64(v), true
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case string:
+		if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+			return n, true
+		}
+	default:
+		if n, ok := toInt64(val); ok && n >= 0 {
+			return uint64(n), true
+		}
+	}
+	return 0, false
+}
+
+func toFloat64(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case decimal.Decimal:
+		return v.InexactFloat64(), true
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, true
+		}
+	default:
+		if n, ok := toInt64(val); ok {
+			return float64(n), true
+		}
+	}
+	return 0, false
+}
+
+func toDecimal(val interface{}) (decimal.Decimal, bool) {
+	switch v := val.(type) {
+	case decimal.Decimal:
+		return v, true
+	case string:
+		if d, err := decimal.NewFromString(v); err == nil {
+			return d, true
+		}
+	case []byte:
+		if d, err := decimal.NewFromString(string(v)); err == nil {
+			return d, true
+		}
+	case float32:
+		return decimal.NewFromFloat32(v), true
+	case float64:
+		return decimal.NewFromFloat(v), true
+	default:
+		if n, ok := toInt64(val); ok {
+			return decimal.NewFromInt(n), true
+		}
+	}
+	return decimal.Decimal{}, false
+}
+
+// compareVersions 比较两个版本值：a 较新返回 1，相同返回 0，较旧返回 -1（NULL 最旧）
+func compareVersions(a, b interface{}) int {
+	a, b = derefValue(a), derefValue(b)
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+	if ia, ok := toInt64(a); ok {
+		if ib, ok := toInt64(b); ok {
+			return compareOrdered(ia, ib)
+		}
+	}
+	if ua, ok := toUint64(a); ok {
+		if ub, ok := toUint64(b); ok {
+			return compareOrdered(ua, ub)
+		}
+	}
+	if da, ok := toDecimal(a); ok {
+		if db, ok := toDecimal(b); ok {
+			return da.Cmp(db)
+		}
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+func compareOrdered[T int64 | uint64](a, b T) int {
+	switch {
+	case a > b:
+		return 1
+	case a < b:
+		return -1
+	default:
+		return 0
+	}
+}
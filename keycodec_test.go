@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 源库与目标库对同一个值的扫描结果可能是不同的 Go 类型（Nullable 为指针、Decimal 精度不同、
+// 时间在不同时区等），编码后的去重键必须相同
+func TestKeyEncoderSourceTargetScansAgree(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		sourceType string
+		targetType string
+		property   interface{} // func(随机值...) (源库扫描结果, 目标库扫描结果)
+	}{
+		{
+			name:       "Nullable(String) vs String",
+			sourceType: "Nullable(String)",
+			targetType: "String",
+			property: func(s string) (interface{}, interface{}) {
+				return &s, s
+			},
+		},
+		{
+			name:       "LowCardinality(String) vs LowCardinality(Nullable(String))",
+			sourceType: "LowCardinality(String)",
+			targetType: "LowCardinality(Nullable(String))",
+			property: func(s string) (interface{}, interface{}) {
+				return s, &s
+			},
+		},
+		{
+			name:       "Nullable(Int64) vs Int64",
+			sourceType: "Nullable(Int64)",
+			targetType: "Int64",
+			property: func(n int64) (interface{}, interface{}) {
+				return &n, n
+			},
+		},
+		{
+			name:       "UInt32 vs UInt64",
+			sourceType: "UInt32",
+			targetType: "UInt64",
+			property: func(n uint32) (interface{}, interface{}) {
+				return n, uint64(n)
+			},
+		},
+		{
+			name:       "Decimal scale 2 vs scale 6",
+			sourceType: "Decimal(18, 2)",
+			targetType: "Decimal(18, 6)",
+			property: func(n int64) (interface{}, interface{}) {
+				d := decimal.New(n%1e12, -2)
+				// 目标库精度更高：末尾补 0（1.50 与 1.500000）
+				return d, decimal.RequireFromString(d.StringFixed(6))
+			},
+		},
+		{
+			name:       "Nullable(Decimal) vs Decimal scanned as string",
+			sourceType: "Nullable(Decimal(38, 4))",
+			targetType: "Decimal(38, 4)",
+			property: func(n int64) (interface{}, interface{}) {
+				d := decimal.New(n, -4)
+				return &d, d.StringFixed(4)
+			},
+		},
+		{
+			name:       "DateTime64(3) in different timezones",
+			sourceType: "DateTime64(3, 'UTC')",
+			targetType: "DateTime64(3, 'Asia/Shanghai')",
+			property: func(sec int32, ms uint16) (interface{}, interface{}) {
+				ts := time.Unix(int64(sec), int64(ms%1000)*int64(time.Millisecond))
+				return ts.UTC(), ts.In(shanghai)
+			},
+		},
+		{
+			name:       "Nullable(DateTime64(9)) vs DateTime64(9)",
+			sourceType: "Nullable(DateTime64(9))",
+			targetType: "DateTime64(9)",
+			property: func(sec int32, ns uint32) (interface{}, interface{}) {
+				ts := time.Unix(int64(sec), int64(ns%1e9)).UTC()
+				return &ts, ts
+			},
+		},
+		{
+			name:       "Float64 vs Nullable(Float64)",
+			sourceType: "Float64",
+			targetType: "Nullable(Float64)",
+			property: func(f float64) (interface{}, interface{}) {
+				return f, &f
+			},
+		},
+		{
+			name:       "Float32 scanned as float32 vs float64",
+			sourceType: "Float32",
+			targetType: "Float32",
+			property: func(f float32) (interface{}, interface{}) {
+				return f, float64(f)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := NewKeyEncoder([]ColumnInfo{{Name: "k", Type: tt.sourceType}})
+			target := NewKeyEncoder([]ColumnInfo{{Name: "k", Type: tt.targetType}})
+			if keyKindOf(tt.sourceType) != keyKindOf(tt.targetType) {
+				t.Fatalf("%s and %s map to different key kinds", tt.sourceType, tt.targetType)
+			}
+
+			gen := reflect.ValueOf(tt.property)
+			property := reflect.MakeFunc(
+				reflect.FuncOf(argTypes(gen.Type()), []reflect.Type{reflect.TypeOf(true)}, false),
+				func(args []reflect.Value) []reflect.Value {
+					out := gen.Call(args)
+					sourceKey := source.Key([]interface{}{out[0].Interface()})
+					targetKey := target.Key([]interface{}{out[1].Interface()})
+					if sourceKey != targetKey {
+						t.Logf("source %#v → %x, target %#v → %x",
+							out[0].Interface(), sourceKey, out[1].Interface(), targetKey)
+					}
+					return []reflect.Value{reflect.ValueOf(sourceKey == targetKey)}
+				})
+			if err := quick.Check(property.Interface(), nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// 特殊的浮点值：-0 与 0、不同的 NaN 编码相同
+func TestKeyEncoderCanonicalFloats(t *testing.T) {
+	encoder := NewKeyEncoder([]ColumnInfo{{Name: "f", Type: "Float64"}})
+	pairs := [][2]float64{
+		{0, math.Copysign(0, -1)},
+		{math.NaN(), math.Float64frombits(0x7ff8000000000001)},
+	}
+	for _, pair := range pairs {
+		if encoder.Key([]interface{}{pair[0]}) != encoder.Key([]interface{}{pair[1]}) {
+			t.Errorf("%v and %v encode differently", pair[0], pair[1])
+		}
+	}
+}
+
+// 值中包含空值标记、长度前缀等字节时，不同的组合键不会得到相同的编码
+func TestKeyEncoderSeparatorBytesDoNotCollide(t *testing.T) {
+	columns := []ColumnInfo{
+		{Name: "a", Type: "Nullable(String)"},
+		{Name: "b", Type: "String"},
+		{Name: "c", Type: "Nullable(String)"},
+	}
+	encoder := NewKeyEncoder(columns)
+
+	// 小字母表（包含编码中使用的 0x00、0x01 与常见分隔符），使不同组合之间的拼接边界经常重叠
+	alphabet := []byte{0x00, 0x01, 0x02, '|', ':', ',', 'a'}
+	randomValue := func(r *rand.Rand) interface{} {
+		if r.Intn(6) == 0 {
+			return (*string)(nil)
+		}
+		b := make([]byte, r.Intn(4))
+		for i := range b {
+			b[i] = alphabet[r.Intn(len(alphabet))]
+		}
+		s := string(b)
+		if r.Intn(2) == 0 {
+			return &s
+		}
+		return s
+	}
+	config := &quick.Config{
+		MaxCount: 20000,
+		Values: func(args []reflect.Value, r *rand.Rand) {
+			for i := range args {
+				tuple := []interface{}{randomValue(r), randomValue(r), randomValue(r)}
+				tuple[1] = derefValue(tuple[1]) // b 不可为 NULL
+				if tuple[1] == nil {
+					tuple[1] = ""
+				}
+				args[i] = reflect.ValueOf(tuple)
+			}
+		},
+	}
+
+	property := func(x, y []interface{}) bool {
+		sameValues := reflect.DeepEqual(normalizeTuple(x), normalizeTuple(y))
+		sameKeys := bytes.Equal(encoder.Encode(nil, x), encoder.Encode(nil, y))
+		if sameValues != sameKeys {
+			t.Logf("%q vs %q: same values %v, same keys %v", normalizeTuple(x), normalizeTuple(y), sameValues, sameKeys)
+		}
+		return sameValues == sameKeys
+	}
+	if err := quick.Check(property, config); err != nil {
+		t.Error(err)
+	}
+
+	// 拼接后字节相同的典型组合
+	collisions := [][2][]interface{}{
+		{{"a\x00", "b", nil}, {"a", "\x00b", nil}},
+		{{"", "", "x"}, {"", "x", ""}},
+		{{nil, "", ""}, {"", "", ""}},
+		{{"\x01\x00", "", nil}, {nil, "\x00", nil}},
+	}
+	for _, pair := range collisions {
+		if encoder.Key(pair[0]) == encoder.Key(pair[1]) {
+			t.Errorf("%q and %q encode to the same key", pair[0], pair[1])
+		}
+	}
+}
+
+// normalizeTuple 解引用组合键中的指针，用于比较值是否相同
+func normalizeTuple(values []interface{}) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = derefValue(v)
+	}
+	return out
+}
+
+func argTypes(fn reflect.Type) []reflect.Type {
+	types := make([]reflect.Type, fn.NumIn())
+	for i := range types {
+		types[i] = fn.In(i)
+	}
+	return types
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"hash/maphash"
+)
+
+// KeySet 去重键集合
+type KeySet interface {
+	Add(values []interface{})
+	AddVersion(values []interface{}, version interface{}) // 添加键并记录版本（同一键保留最大版本）
+	Contains(values []interface{}) bool
+	Version(values []interface{}) (interface{}, bool) // 键存在时返回记录的版本
+	Len() int
+	MemoryBytes() int // 估算的内存占用（字节）
+}
+
+// exactKeySet 精确键集合：保存编码后的完整复合键（值为版本，未记录版本时为 nil）
+type exactKeySet struct {
+	keys    map[string]interface{}
+	encoder *KeyEncoder
+	buf     []byte // 编码缓冲区，查询时复用
+	bytes   int
+}
+
+func newExactKeySet(encoder *KeyEncoder) *exactKeySet {
+	return &exactKeySet{keys: make(map[string]interface{}), encoder: encoder}
+}
+
+func (ks *exactKeySet) Add(values []interface{}) {
+	ks.AddVersion(values, nil)
+}
+
+func (ks *exactKeySet) AddVersion(values []interface{}, version interface{}) {
+	ks.buf = ks.encoder.Encode(ks.buf[:0], values)
+	existing, exists := ks.keys[string(ks.buf)]
+	if !exists {
+		ks.bytes += len(ks.buf)
+	}
+	if !exists || compareVersions(version, existing) > 0 {
+		ks.keys[string(ks.buf)] = version
+	}
+}
+
+func (ks *exactKeySet) Contains(values []interface{}) bool {
+	_, exists := ks.Version(values)
+	return exists
+}
+
+func (ks *exactKeySet) Version(values []interface{}) (interface{}, bool) {
+	ks.buf = ks.encoder.Encode(ks.buf[:0], values)
+	version, exists := ks.keys[string(ks.buf)]
+	return version, exists
+}
+
+func (ks *exactKeySet) Len() int {
+	return len(ks.keys)
+}
+
+func (ks *exactKeySet) MemoryBytes() int {
+	// 字符串内容 + 字符串头、版本与 map 槽位开销（约 48 字节/键）
+	return ks.bytes + len(ks.keys)*48
+}
+
+// hashedKeySet 哈希键集合：每个键只保存 128 位哈希（两个独立的 64 位哈希）
+// 第一段哈希相同但第二段不同（64 位碰撞候选）时，改为保存完整键做精确比较
+type hashedKeySet struct {
+	sum        func(key []byte) (uint64, uint64) // 编码后键的两段哈希
+	hashes     map[uint64]uint64
+	collisions *exactKeySet
+	useBloom   bool
+	bloom      *bloomFilter           // 按需重建：Add 之后首次 Contains 时根据当前键数量构建
+	versions   map[uint64]interface{} // 按第一段哈希记录的版本（只在 AddVersion 时分配）
+	encoder    *KeyEncoder
+	buf        []byte // 编码缓冲区，计算哈希时复用
+}
+
+func newHashedKeySet(encoder *KeyEncoder, bloom bool) *hashedKeySet {
+	seed1, seed2 := maphash.MakeSeed(), maphash.MakeSeed()
+	return &hashedKeySet{
+		sum: func(key []byte) (uint64, uint64) {
+			return maphash.Bytes(seed1, key), maphash.Bytes(seed2, key)
+		},
+		hashes:     make(map[uint64]uint64),
+		collisions: newExactKeySet(encoder),
+		useBloom:   bloom,
+		encoder:    encoder,
+	}
+}
+
+// hash 计算键值的两个独立 64 位哈希
+func (ks *hashedKeySet) hash(values []interface{}) (uint64, uint64) {
+	ks.buf = ks.encoder.Encode(ks.buf[:0], values)
+	return ks.sum(ks.buf)
+}
+
+func (ks *hashedKeySet) Add(values []interface{}) {
+	h1, h2 := ks.hash(values)
+	ks.bloom = nil
+
+	existing, exists := ks.hashes[h1]
+	switch {
+	case !exists:
+		ks.hashes[h1] = h2
+	case existing != h2:
+		// 64 位碰撞：保存完整键
+		ks.collisions.Add(values)
+	}
+}
+
+func (ks *hashedKeySet) AddVersion(values []interface{}, version interface{}) {
+	h1, h2 := ks.hash(values)
+	ks.bloom = nil
+	if ks.versions == nil {
+		ks.versions = make(map[uint64]interface{})
+	}
+
+	existing, exists := ks.hashes[h1]
+	switch {
+	case !exists:
+		ks.hashes[h1] = h2
+		ks.versions[h1] = version
+	case existing == h2:
+		if compareVersions(version, ks.versions[h1]) > 0 {
+			ks.versions[h1] = version
+		}
+	default:
+		// 64 位碰撞：保存完整键及其版本
+		ks.collisions.AddVersion(values, version)
+	}
+}
+
+func (ks *hashedKeySet) Contains(values []interface{}) bool {
+	_, exists := ks.Version(values)
+	return exists
+}
+
+func (ks *hashedKeySet) Version(values []interface{}) (interface{}, bool) {
+	h1, h2 := ks.hash(values)
+	if ks.useBloom {
+		if ks.bloom == nil {
+			ks.buildBloom()
+		}
+		if !ks.bloom.mayContain(h1) {
+			return nil, false
+		}
+	}
+
+	existing, exists := ks.hashes[h1]
+	if !exists {
+		return nil, false
+	}
+	if existing == h2 {
+		return ks.versions[h1], true
+	}
+	// 碰撞候选：精确比较
+	if ks.collisions.Len() == 0 {
+		return nil, false
+	}
+	return ks.collisions.Version(values)
+}
+
+// buildBloom 根据当前所有键构建布隆过滤器（碰撞键与主哈希共享第一段哈希，无需单独加入）
+func (ks *hashedKeySet) buildBloom() {
+	ks.bloom = newBloomFilter(len(ks.hashes))
+	for h1 := range ks.hashes {
+		ks.bloom.add(h1)
+	}
+}
+
+func (ks *hashedKeySet) Len() int {
+	return len(ks.hashes) + ks.collisions.Len()
+}
+
+func (ks *hashedKeySet) MemoryBytes() int {
+	// 16 字节哈希 + map 槽位开销（约 8 字节/键）
+	total := len(ks.hashes)*24 + len(ks.versions)*32 + ks.collisions.MemoryBytes()
+	if ks.bloom != nil {
+		total += ks.bloom.memoryBytes()
+	}
+	return total
+}
+
+// bloomFilter 布隆过滤器（约 10 位/键，7 个哈希函数，误判率约 1%）
+// 位置由 64 位哈希的高低 32 位做双重哈希得到
+type bloomFilter struct {
+	bits []uint64
+}
+
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+)
+
+// newBloomFilter 按预计键数量创建布隆过滤器
+func newBloomFilter(expected int) *bloomFilter {
+	words := (expected*bloomBitsPerKey + 63) / 64
+	if words < 1 {
+		words = 1
+	}
+	return &bloomFilter{bits: make([]uint64, words)}
+}
+
+func (bf *bloomFilter) positions(h uint64, fn func(pos uint64) bool) {
+	nbits := uint64(len(bf.bits) * 64)
+	lo, hi := h&0xffffffff, (h>>32)|1
+	for i := uint64(0); i < bloomHashes; i++ {
+		if !fn((lo + i*hi) % nbits) {
+			return
+		}
+	}
+}
+
+func (bf *bloomFilter) add(h uint64) {
+	bf.positions(h, func(pos uint64) bool {
+		bf.bits[pos/64] |= 1 << (pos % 64)
+		return true
+	})
+}
+
+func (bf *bloomFilter) mayContain(h uint64) bool {
+	found := true
+	bf.positions(h, func(pos uint64) bool {
+		found = bf.bits[pos/64]&(1<<(pos%64)) != 0
+		return found
+	})
+	return found
+}
+
+func (bf *bloomFilter) memoryBytes() int {
+	return len(bf.bits) * 8
+}
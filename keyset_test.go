@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"testing"
+)
+
+// collidingKeySet 第一段哈希恒为同一个值的哈希键集合：除第一个键外都走 64 位碰撞的精确比较分支
+func collidingKeySet(bloom bool) *hashedKeySet {
+	ks := newHashedKeySet(NewKeyEncoder([]ColumnInfo{{Name: "k", Type: "String"}}), bloom)
+	ks.sum = func(key []byte) (uint64, uint64) {
+		h := fnv.New64a()
+		h.Write(key)
+		return 42, h.Sum64()
+	}
+	return ks
+}
+
+func keyOf(s string) []interface{} {
+	return []interface{}{s}
+}
+
+func TestHashedKeySetCollisionFallback(t *testing.T) {
+	for _, bloom := range []bool{false, true} {
+		t.Run(fmt.Sprintf("bloom=%v", bloom), func(t *testing.T) {
+			ks := collidingKeySet(bloom)
+			for _, k := range []string{"a", "b", "c", "b"} {
+				ks.Add(keyOf(k))
+			}
+
+			if ks.Len() != 3 || ks.collisions.Len() != 2 {
+				t.Fatalf("Len = %d, collisions = %d, want 3 and 2", ks.Len(), ks.collisions.Len())
+			}
+			for _, k := range []string{"a", "b", "c"} {
+				if !ks.Contains(keyOf(k)) {
+					t.Errorf("Contains(%q) = false", k)
+				}
+			}
+			// 第一段哈希相同但不在集合中的键不能误判为存在
+			if ks.Contains(keyOf("d")) {
+				t.Error(`Contains("d") = true`)
+			}
+		})
+	}
+}
+
+func TestHashedKeySetCollisionVersions(t *testing.T) {
+	ks := collidingKeySet(false)
+	ks.AddVersion(keyOf("a"), int64(1))
+	ks.AddVersion(keyOf("b"), int64(5))
+	ks.AddVersion(keyOf("b"), int64(3)) // 更小的版本不覆盖
+	ks.AddVersion(keyOf("a"), int64(7))
+
+	tests := []struct {
+		key     string
+		version interface{}
+		exists  bool
+	}{
+		{"a", int64(7), true},
+		{"b", int64(5), true},
+		{"c", nil, false},
+	}
+	for _, tt := range tests {
+		version, exists := ks.Version(keyOf(tt.key))
+		if version != tt.version || exists != tt.exists {
+			t.Errorf("Version(%q) = %v, %v, want %v, %v", tt.key, version, exists, tt.version, tt.exists)
+		}
+	}
+}
+
+// 同一键重复添加时保留最大版本（精确与哈希键集合行为一致）
+func TestKeySetAddVersionKeepsMaximum(t *testing.T) {
+	encoder := NewKeyEncoder([]ColumnInfo{{Name: "k", Type: "String"}})
+	sets := map[string]KeySet{
+		"exact":  newExactKeySet(encoder),
+		"hashed": newHashedKeySet(encoder, false),
+		"bloom":  newHashedKeySet(encoder, true),
+	}
+
+	for name, ks := range sets {
+		t.Run(name, func(t *testing.T) {
+			ks.AddVersion(keyOf("a"), uint64(2))
+			ks.AddVersion(keyOf("a"), uint64(9))
+			ks.AddVersion(keyOf("a"), uint64(4))
+			ks.AddVersion(keyOf("b"), nil)
+			ks.AddVersion(keyOf("b"), uint64(1)) // 任何版本都比 NULL 新
+
+			if version, ok := ks.Version(keyOf("a")); !ok || version != uint64(9) {
+				t.Errorf(`Version("a") = %v, %v, want 9`, version, ok)
+			}
+			if version, ok := ks.Version(keyOf("b")); !ok || version != uint64(1) {
+				t.Errorf(`Version("b") = %v, %v, want 1`, version, ok)
+			}
+			if _, ok := ks.Version(keyOf("c")); ok {
+				t.Error(`Version("c") exists`)
+			}
+			if ks.Len() != 2 {
+				t.Errorf("Len = %d, want 2", ks.Len())
+			}
+		})
+	}
+}
+
+// 布隆过滤器在 Contains 时按当前键构建，之后的 Add 使其失效并在下次 Contains 时重建
+func TestHashedKeySetBloomRebuiltAfterAdd(t *testing.T) {
+	ks := newHashedKeySet(NewKeyEncoder([]ColumnInfo{{Name: "k", Type: "String"}}), true)
+	for i := 0; i < 100; i++ {
+		ks.Add(keyOf(fmt.Sprintf("first-%d", i)))
+	}
+	if !ks.Contains(keyOf("first-0")) || ks.bloom == nil {
+		t.Fatal("bloom filter not built on first Contains")
+	}
+	firstSize := ks.bloom.memoryBytes()
+
+	for i := 0; i < 1000; i++ {
+		ks.Add(keyOf(fmt.Sprintf("second-%d", i)))
+	}
+	if ks.bloom != nil {
+		t.Fatal("Add did not invalidate the bloom filter")
+	}
+	// 新加入的键必须可见（过滤器按新的键数量重建，没有假阴性）
+	for i := 0; i < 1000; i++ {
+		if !ks.Contains(keyOf(fmt.Sprintf("second-%d", i))) {
+			t.Fatalf("second-%d missing after rebuild", i)
+		}
+	}
+	if ks.bloom.memoryBytes() <= firstSize {
+		t.Errorf("rebuilt bloom filter has %d bytes, want more than %d", ks.bloom.memoryBytes(), firstSize)
+	}
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	const n = 10000
+	bf := newBloomFilter(n)
+	h := func(i int) uint64 {
+		f := fnv.New64a()
+		fmt.Fprintf(f, "key-%d", i)
+		return f.Sum64()
+	}
+	for i := 0; i < n; i++ {
+		bf.add(h(i))
+	}
+	for i := 0; i < n; i++ {
+		if !bf.mayContain(h(i)) {
+			t.Fatalf("false negative for key %d", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := n; i < 2*n; i++ {
+		if bf.mayContain(h(i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.03 {
+		t.Errorf("false positive rate %.2f%%, want about 1%%", rate*100)
+	}
+}
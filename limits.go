@@ -0,0 +1,618 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	chdriver "github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// ResourceLimiter 全局限流与资源预算：源库和目标库各一个，所有表、所有连接共享，运行中可调整
+type ResourceLimiter struct {
+	Source *ClusterLimiter
+	Target *ClusterLimiter
+}
+
+// NewResourceLimiter 按配置创建限流器
+// HTTP 协议的集群收不到查询进度，读取字节数改由服务端按 max_network_bandwidth 限速；
+// 目标库执行的服务端传输（remote()）读取源库，按源库的读取字节数限速
+func NewResourceLimiter(config *Config) *ResourceLimiter {
+	source := &ClusterLimiter{networkBandwidth: config.Source.Protocol == "http"}
+	l := &ResourceLimiter{
+		Source: source,
+		Target: &ClusterLimiter{networkBandwidth: config.Target.Protocol == "http", remoteSource: source},
+	}
+	l.Update(config.Sync.Limits)
+	return l
+}
+
+// Update 应用新的限流配置（进行中的查询不受影响，之后的读取、查询和插入按新配置限流）
+func (l *ResourceLimiter) Update(limits LimitsConfig) {
+	l.Source.update(limits.Source)
+	l.Target.update(limits.Target)
+}
+
+// ClusterLimiter 单个集群的限流：读取行数/字节数速率、并发查询数、插入速率，以及附加到查询的设置
+type ClusterLimiter struct {
+	queries querySlots
+	rows    rateLimiter
+	bytes   rateLimiter
+	inserts rateLimiter
+
+	networkBandwidth bool            // 读取字节数由服务端按 max_network_bandwidth 限速（HTTP 协议不上报进度）
+	remoteSource     *ClusterLimiter // 服务端传输读取的源库（目标库的 remote() 查询按其读取字节数限速）
+
+	mu       sync.RWMutex
+	settings clickhouse.Settings
+}
+
+// update 应用集群的限流配置
+func (c *ClusterLimiter) update(limits ClusterLimitsConfig) {
+	c.queries.setLimit(limits.MaxQueries)
+	c.rows.setRate(float64(limits.RowsPerSecond))
+	c.bytes.setRate(float64(limits.BytesPerSecond))
+	c.inserts.setRate(limits.InsertsPerSecond)
+
+	settings := clickhouse.Settings{}
+	for k, v := range limits.Settings {
+		settings[k] = v
+	}
+	c.mu.Lock()
+	c.settings = settings
+	c.mu.Unlock()
+}
+
+// acquire 获取一个并发查询名额，返回的 release 只生效一次
+func (c *ClusterLimiter) acquire(ctx context.Context) (func(), error) {
+	if err := c.queries.acquire(ctx); err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(c.queries.release) }, nil
+}
+
+// queryContext 为查询附加集群设置与单条查询的设置，并按服务端进度统计读取的字节数
+func (c *ClusterLimiter) queryContext(ctx context.Context) context.Context {
+	return clickhouse.Context(ctx,
+		clickhouse.WithSettings(c.querySettings(ctx)),
+		clickhouse.WithProgress(func(p *clickhouse.Progress) {
+			c.bytes.take(float64(p.Bytes))
+		}),
+	)
+}
+
+// querySettings 合并集群设置、服务端限速与单条查询的设置（单条查询的设置优先）
+func (c *ClusterLimiter) querySettings(ctx context.Context) clickhouse.Settings {
+	settings := clickhouse.Settings{}
+	c.mu.RLock()
+	for k, v := range c.settings {
+		settings[k] = v
+	}
+	c.mu.RUnlock()
+	// 收不到进度的读取由服务端限速（配置的 settings 中已设置 max_network_bandwidth 时以其为准）
+	bandwidth := 0.0
+	if c.networkBandwidth {
+		bandwidth = c.bytes.currentRate()
+	}
+	if _, remote := ctx.Value(remoteReadKey{}).(bool); remote && c.remoteSource != nil {
+		bandwidth = c.remoteSource.bytes.currentRate()
+	}
+	if _, exists := settings["max_network_bandwidth"]; !exists && bandwidth > 0 {
+		settings["max_network_bandwidth"] = uint64(bandwidth)
+	}
+	if extra, ok := ctx.Value(querySettingsKey{}).(clickhouse.Settings); ok {
+		for k, v := range extra {
+			settings[k] = v
+		}
+	}
+	return settings
+}
+
+// admit 开始查询前等待读取速率恢复（字节数按服务端进度透支时在此等待），INSERT 语句另按插入速率限流
+func (c *ClusterLimiter) admit(ctx context.Context, query string) error {
+	if err := c.bytes.wait(ctx, 0); err != nil {
+		return err
+	}
+	if isInsertQuery(query) {
+		return c.inserts.wait(ctx, 1)
+	}
+	return nil
+}
+
+// throttleRows 按读取行数限流，并在字节数透支时等待
+func (c *ClusterLimiter) throttleRows(ctx context.Context, n int) error {
+	if err := c.rows.wait(ctx, float64(n)); err != nil {
+		return err
+	}
+	return c.bytes.wait(ctx, 0)
+}
+
+// isInsertQuery 判断是否为 INSERT 语句
+func isInsertQuery(query string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "INSERT")
+}
+
+// querySettingsKey 单条查询附加设置的 context 键
+type querySettingsKey struct{}
+
+// withQuerySettings 为单条查询附加 ClickHouse 设置（与 sync.limits 中的集群设置合并，单条查询的设置优先）
+// 连接经 ClusterLimiter 包装后会重新设置查询选项，因此不要直接使用 clickhouse.Context 附加设置
+func withQuerySettings(ctx context.Context, settings clickhouse.Settings) context.Context {
+	merged := clickhouse.Settings{}
+	if existing, ok := ctx.Value(querySettingsKey{}).(clickhouse.Settings); ok {
+		for k, v := range existing {
+			merged[k] = v
+		}
+	}
+	for k, v := range settings {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, querySettingsKey{}, merged)
+}
+
+// remoteReadKey 服务端传输查询的 context 键
+type remoteReadKey struct{}
+
+// withRemoteRead 标记查询在目标库经 remote() 读取源库：按源库的读取字节数设置 max_network_bandwidth
+// （数据不经过本进程，客户端无法按进度限速）
+func withRemoteRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, remoteReadKey{}, true)
+}
+
+// rowsPerThrottle 读取多少行检查一次行数限流（逐行等待的粒度过细）
+const rowsPerThrottle = 1024
+
+// querySlots 可调整上限的并发名额（上限为 0 表示不限制）
+type querySlots struct {
+	mu      sync.Mutex
+	limit   int
+	inUse   int
+	changed chan struct{} // 名额释放或上限变化时关闭，唤醒等待者
+}
+
+// setLimit 调整上限
+func (s *querySlots) setLimit(limit int) {
+	s.mu.Lock()
+	s.limit = limit
+	s.broadcastLocked()
+	s.mu.Unlock()
+}
+
+// acquire 获取一个名额，没有空闲名额时等待
+func (s *querySlots) acquire(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.limit <= 0 || s.inUse < s.limit {
+			s.inUse++
+			s.mu.Unlock()
+			return nil
+		}
+		if s.changed == nil {
+			s.changed = make(chan struct{})
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release 归还一个名额
+func (s *querySlots) release() {
+	s.mu.Lock()
+	s.inUse--
+	s.broadcastLocked()
+	s.mu.Unlock()
+}
+
+// broadcastLocked 唤醒所有等待者（调用方持有锁）
+func (s *querySlots) broadcastLocked() {
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// rateLimiter 可调整速率的令牌桶（速率为 0 表示不限制），允许透支：透支后的调用方按欠额等待
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的令牌数
+	tokens float64 // 可用令牌，最多积累 1 秒的量
+	last   time.Time
+}
+
+// setRate 调整速率
+func (r *rateLimiter) setRate(rate float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refillLocked(time.Now())
+	r.rate = rate
+	switch {
+	case rate <= 0:
+		r.tokens = 0
+	case r.tokens > rate:
+		// 调低速率时保留不超过新速率 1 秒的令牌（透支的欠额保持不变）
+		r.tokens = rate
+	}
+}
+
+// currentRate 返回当前速率（0 表示不限制）
+func (r *rateLimiter) currentRate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rate
+}
+
+// take 扣除 n 个令牌，返回需要等待的时间
+func (r *rateLimiter) take(n float64) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rate <= 0 {
+		return 0
+	}
+	r.refillLocked(time.Now())
+	r.tokens -= n
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / r.rate * float64(time.Second))
+}
+
+// wait 扣除 n 个令牌并等待到欠额补足
+func (r *rateLimiter) wait(ctx context.Context, n float64) error {
+	delay := r.take(n)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refillLocked 按经过的时间补充令牌（调用方持有锁）
+func (r *rateLimiter) refillLocked(now time.Time) {
+	if !r.last.IsZero() && r.rate > 0 {
+		r.tokens += now.Sub(r.last).Seconds() * r.rate
+		if r.tokens > r.rate {
+			r.tokens = r.rate
+		}
+	}
+	r.last = now
+}
+
+// describeLimits 描述集群的限流配置（用于同步计划与重新加载日志）
+func describeLimits(limits ClusterLimitsConfig) string {
+	parts := []string{}
+	if limits.RowsPerSecond > 0 {
+		parts = append(parts, fmt.Sprintf("读取 %s 行/秒", FormatNumber(limits.RowsPerSecond)))
+	}
+	if limits.BytesPerSecond > 0 {
+		parts = append(parts, fmt.Sprintf("读取 %s/秒", FormatBytes(int(limits.BytesPerSecond))))
+	}
+	if limits.MaxQueries > 0 {
+		parts = append(parts, fmt.Sprintf("最多 %d 个并发查询", limits.MaxQueries))
+	}
+	if limits.InsertsPerSecond > 0 {
+		parts = append(parts, fmt.Sprintf("插入 %g 次/秒", limits.InsertsPerSecond))
+	}
+	if len(limits.Settings) > 0 {
+		keys := make([]string, 0, len(limits.Settings))
+		for k := range limits.Settings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		settings := make([]string, len(keys))
+		for i, k := range keys {
+			settings[i] = fmt.Sprintf("%s=%v", k, limits.Settings[k])
+		}
+		parts = append(parts, "设置 "+strings.Join(settings, ", "))
+	}
+	if len(parts) == 0 {
+		return "不限制"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// limitedConn 经集群限流的原生连接
+type limitedConn struct {
+	chdriver.Conn
+	limiter *ClusterLimiter
+}
+
+// Query 流式查询：名额在 rows 关闭时归还，读取的行按行数速率限流
+func (c *limitedConn) Query(ctx context.Context, query string, args ...any) (chdriver.Rows, error) {
+	release, err := c.begin(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.Conn.Query(c.limiter.queryContext(ctx), query, args...)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &limitedRows{Rows: rows, ctx: ctx, limiter: c.limiter, release: release}, nil
+}
+
+// QueryRow 单行查询
+func (c *limitedConn) QueryRow(ctx context.Context, query string, args ...any) chdriver.Row {
+	release, err := c.begin(ctx, query)
+	if err != nil {
+		return errRow{err: err}
+	}
+	defer release()
+	return c.Conn.QueryRow(c.limiter.queryContext(ctx), query, args...)
+}
+
+// Select 查询到结构体切片
+func (c *limitedConn) Select(ctx context.Context, dest any, query string, args ...any) error {
+	release, err := c.begin(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.Conn.Select(c.limiter.queryContext(ctx), dest, query, args...)
+}
+
+// Exec 执行语句
+func (c *limitedConn) Exec(ctx context.Context, query string, args ...any) error {
+	release, err := c.begin(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.Conn.Exec(c.limiter.queryContext(ctx), query, args...)
+}
+
+// PrepareBatch 批量插入：准备与发送批次时各占用一个名额，客户端缓冲数据期间（包括等待按序提交）不占用名额
+func (c *limitedConn) PrepareBatch(ctx context.Context, query string, opts ...chdriver.PrepareBatchOption) (chdriver.Batch, error) {
+	release, err := c.begin(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	batch, err := c.Conn.PrepareBatch(c.limiter.queryContext(ctx), query, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedBatch{Batch: batch, ctx: ctx, limiter: c.limiter}, nil
+}
+
+// begin 等待速率限制并获取并发查询名额
+func (c *limitedConn) begin(ctx context.Context, query string) (func(), error) {
+	if err := c.limiter.admit(ctx, query); err != nil {
+		return nil, err
+	}
+	return c.limiter.acquire(ctx)
+}
+
+// limitedRows 经限流的原生查询结果
+type limitedRows struct {
+	chdriver.Rows
+	ctx     context.Context
+	limiter *ClusterLimiter
+	release func()
+	pending int
+	err     error
+}
+
+// Next 读取下一行，每 rowsPerThrottle 行检查一次速率限制
+func (r *limitedRows) Next() bool {
+	if r.err != nil || !r.Rows.Next() {
+		return false
+	}
+	r.pending++
+	if r.pending >= rowsPerThrottle {
+		r.err = r.limiter.throttleRows(r.ctx, r.pending)
+		r.pending = 0
+	}
+	return r.err == nil
+}
+
+// Err 返回读取错误（包括限流等待被取消）
+func (r *limitedRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.Rows.Err()
+}
+
+// Close 关闭结果并归还名额
+func (r *limitedRows) Close() error {
+	defer r.release()
+	r.limiter.rows.take(float64(r.pending))
+	r.pending = 0
+	return r.Rows.Close()
+}
+
+// limitedBatch 经限流的原生批量插入
+type limitedBatch struct {
+	chdriver.Batch
+	ctx     context.Context
+	limiter *ClusterLimiter
+}
+
+// Send 获取名额并发送批次
+func (b *limitedBatch) Send() error {
+	release, err := b.limiter.acquire(b.ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return b.Batch.Send()
+}
+
+// errRow 获取名额失败时返回的单行结果
+type errRow struct {
+	err error
+}
+
+func (r errRow) Err() error                { return r.err }
+func (r errRow) Scan(dest ...any) error    { return r.err }
+func (r errRow) ScanStruct(dest any) error { return r.err }
+
+// limitedConnector 经集群限流的 database/sql 连接器
+type limitedConnector struct {
+	driver.Connector
+	limiter *ClusterLimiter
+}
+
+// stdConn clickhouse-go database/sql 连接实现的接口
+type stdConn interface {
+	driver.Conn
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.NamedValueChecker
+}
+
+// stdRows clickhouse-go database/sql 查询结果实现的接口
+type stdRows interface {
+	driver.Rows
+	driver.RowsColumnTypeScanType
+	driver.RowsColumnTypeDatabaseTypeName
+	driver.RowsColumnTypeNullable
+	driver.RowsColumnTypePrecisionScale
+}
+
+// Connect 建立连接并包装
+func (c *limitedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	std, ok := conn.(stdConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected database/sql connection type %T", conn)
+	}
+	return &limitedSQLConn{stdConn: std, limiter: c.limiter}, nil
+}
+
+// limitedSQLConn 经集群限流的 database/sql 连接
+type limitedSQLConn struct {
+	stdConn
+	limiter *ClusterLimiter
+}
+
+// PrepareContext 预编译语句（database/sql 的批量 INSERT 经此开始，按插入速率限流）
+// 与原生批量插入相同，准备语句与提交事务（发送批次）时各占用一个名额
+func (c *limitedSQLConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.limiter.admit(ctx, query); err != nil {
+		return nil, err
+	}
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.stdConn.PrepareContext(c.limiter.queryContext(ctx), query)
+}
+
+// Begin 开始事务（database/sql 批量插入的数据在提交时发送）
+func (c *limitedSQLConn) Begin() (driver.Tx, error) {
+	tx, err := c.stdConn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &limitedSQLTx{Tx: tx, limiter: c.limiter}, nil
+}
+
+// limitedSQLTx 经限流的 database/sql 事务
+type limitedSQLTx struct {
+	driver.Tx
+	limiter *ClusterLimiter
+}
+
+// Commit 获取名额并提交（发送缓冲的批次）
+func (t *limitedSQLTx) Commit() error {
+	release, err := t.limiter.acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer release()
+	return t.Tx.Commit()
+}
+
+// ExecContext 执行语句
+func (c *limitedSQLConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.limiter.admit(ctx, query); err != nil {
+		return nil, err
+	}
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.stdConn.ExecContext(c.limiter.queryContext(ctx), query, args)
+}
+
+// QueryContext 查询：名额在结果关闭时归还
+func (c *limitedSQLConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.limiter.admit(ctx, query); err != nil {
+		return nil, err
+	}
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.stdConn.QueryContext(c.limiter.queryContext(ctx), query, args)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	std, ok := rows.(stdRows)
+	if !ok {
+		release()
+		rows.Close()
+		return nil, fmt.Errorf("unexpected database/sql rows type %T", rows)
+	}
+	return &limitedSQLRows{stdRows: std, ctx: ctx, limiter: c.limiter, release: release}, nil
+}
+
+// limitedSQLRows 经限流的 database/sql 查询结果
+type limitedSQLRows struct {
+	stdRows
+	ctx     context.Context
+	limiter *ClusterLimiter
+	release func()
+	pending int
+}
+
+// Next 读取下一行，每 rowsPerThrottle 行检查一次速率限制
+func (r *limitedSQLRows) Next(dest []driver.Value) error {
+	if err := r.stdRows.Next(dest); err != nil {
+		return err
+	}
+	r.pending++
+	if r.pending >= rowsPerThrottle {
+		pending := r.pending
+		r.pending = 0
+		return r.limiter.throttleRows(r.ctx, pending)
+	}
+	return nil
+}
+
+// Close 关闭结果并归还名额
+func (r *limitedSQLRows) Close() error {
+	defer r.release()
+	r.limiter.rows.take(float64(r.pending))
+	r.pending = 0
+	return r.stdRows.Close()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	var r rateLimiter
+	if delay := r.take(1e12); delay != 0 {
+		t.Fatalf("take() with rate 0 = %s, want 0", delay)
+	}
+}
+
+func TestRateLimiterOverdraft(t *testing.T) {
+	var r rateLimiter
+	r.setRate(100)
+
+	// 初始没有积累的令牌：扣除 50 个需要等待约 0.5 秒
+	delay := r.take(50)
+	if delay < 400*time.Millisecond || delay > 500*time.Millisecond {
+		t.Fatalf("take(50) = %s, want about 500ms", delay)
+	}
+	// 透支累加：再扣除 100 个需要等待约 1.5 秒
+	delay = r.take(100)
+	if delay < 1400*time.Millisecond || delay > 1500*time.Millisecond {
+		t.Fatalf("take(100) after overdraft = %s, want about 1.5s", delay)
+	}
+}
+
+func TestRateLimiterTokensCappedAtOneSecond(t *testing.T) {
+	var r rateLimiter
+	r.setRate(100)
+	r.last = time.Now().Add(-10 * time.Second) // 空闲 10 秒只积累 1 秒的令牌
+
+	if delay := r.take(100); delay != 0 {
+		t.Fatalf("take(100) after idle = %s, want 0", delay)
+	}
+	if delay := r.take(50); delay < 400*time.Millisecond {
+		t.Fatalf("take(50) beyond the burst = %s, want about 500ms", delay)
+	}
+}
+
+// 调低速率时积累的令牌按新速率截断，而不是清零
+func TestRateLimiterSetRateCapsTokens(t *testing.T) {
+	var r rateLimiter
+	r.setRate(1000)
+	r.last = time.Now().Add(-time.Second)
+
+	r.setRate(100)
+	if r.tokens < 99 || r.tokens > 100 {
+		t.Fatalf("tokens after lowering rate = %g, want 100", r.tokens)
+	}
+	if delay := r.take(100); delay != 0 {
+		t.Fatalf("take(100) = %s, want 0", delay)
+	}
+
+	// 透支的欠额在调整速率后保持
+	r.take(50)
+	r.setRate(10)
+	if r.tokens > -49 {
+		t.Fatalf("tokens after lowering rate with debt = %g, want about -50", r.tokens)
+	}
+
+	r.setRate(0)
+	if delay := r.take(1e9); delay != 0 || r.tokens != 0 {
+		t.Fatalf("take() after removing the limit = %s (tokens %g), want 0", delay, r.tokens)
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	var r rateLimiter
+	r.setRate(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := r.wait(ctx, 10); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestQuerySlotsLimit(t *testing.T) {
+	var s querySlots
+	s.setLimit(2)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := s.acquire(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 名额用完时等待，直到超时
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := s.acquire(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire() beyond limit = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// 归还名额后等待者被唤醒
+	acquired := make(chan error, 1)
+	go func() { acquired <- s.acquire(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	s.release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken by release")
+	}
+
+	// 提高上限同样唤醒等待者
+	go func() { acquired <- s.acquire(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	s.setLimit(3)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken by raising the limit")
+	}
+	if s.inUse != 3 {
+		t.Fatalf("inUse = %d, want 3", s.inUse)
+	}
+}
+
+func TestQuerySlotsUnlimited(t *testing.T) {
+	var s querySlots
+	for i := 0; i < 100; i++ {
+		if err := s.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// HTTP 协议与服务端传输按读取字节数设置 max_network_bandwidth，显式配置的设置优先
+func TestQuerySettingsNetworkBandwidth(t *testing.T) {
+	config := &Config{}
+	config.Source.Protocol = "http"
+	config.Sync.Limits.Source.BytesPerSecond = 1000
+	config.Sync.Limits.Target.BytesPerSecond = 500
+	l := NewResourceLimiter(config)
+	ctx := context.Background()
+
+	if got := l.Source.querySettings(ctx)["max_network_bandwidth"]; got != uint64(1000) {
+		t.Errorf("http source max_network_bandwidth = %v, want 1000", got)
+	}
+	if got, ok := l.Target.querySettings(ctx)["max_network_bandwidth"]; ok {
+		t.Errorf("native target max_network_bandwidth = %v, want unset", got)
+	}
+	if got := l.Target.querySettings(withRemoteRead(ctx))["max_network_bandwidth"]; got != uint64(1000) {
+		t.Errorf("remote read max_network_bandwidth = %v, want the source limit 1000", got)
+	}
+
+	config.Sync.Limits.Source.Settings = map[string]interface{}{"max_network_bandwidth": 10}
+	l.Update(config.Sync.Limits)
+	if got := l.Source.querySettings(ctx)["max_network_bandwidth"]; got != 10 {
+		t.Errorf("configured max_network_bandwidth = %v, want 10", got)
+	}
+}
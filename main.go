@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 )
 
 func main() {
@@ -21,6 +25,8 @@ func main() {
 	skipConfirm := flag.Bool("yes", false, "跳过确认提示")
 	loopInterval := flag.Int("loop-interval", 10, "循环间隔（秒）")
 	realtimeThreshold := flag.Int("realtime-threshold", 300, "实时模式阈值（秒），延迟超过此值先追平历史")
+	validateOnly := flag.Bool("validate-only", false, "只验证已同步的数据（不复制数据）")
+	repair := flag.Bool("repair", false, "修复验证失败的分段（二分定位后只重新复制差异部分）")
 	flag.Parse()
 
 	// 2. 加载配置
@@ -51,7 +57,7 @@ func main() {
 	}
 
 	// 验证时间范围
-	if err := ValidateTimeRange(&config.TimeRange); err != nil {
+	if err := ValidateTimeRange(&config.TimeRange, config.Sync.Timezone); err != nil {
 		log.Fatalf("❌ 时间范围配置无效: %v", err)
 	}
 
@@ -62,21 +68,49 @@ func main() {
 		return
 	}
 
-	// 6. 连接数据库
+	// 6. 连接数据库（源库与目标库的所有查询经全局限流器，新连接由节点健康状态选择节点）
+	limiter := NewResourceLimiter(config)
+
+	sourceHealth, err := NewClusterHealth("源库", config.Source, config.Sync)
+	if err != nil {
+		log.Fatalf("❌ 源数据库连接配置无效: %v", err)
+	}
+	sourceHealth.Start()
+	defer sourceHealth.Close()
+
+	targetHealth, err := NewClusterHealth("目标库", config.Target, config.Sync)
+	if err != nil {
+		log.Fatalf("❌ 目标数据库连接配置无效: %v", err)
+	}
+	targetHealth.Start()
+	defer targetHealth.Close()
+
 	log.Println("🔌 连接源数据库...")
-	sourceDB, err := ConnectClickHouse(config.Source, config.Sync)
+	sourceDB, err := ConnectClickHouse(config.Source, config.Sync, limiter.Source, sourceHealth)
 	if err != nil {
 		log.Fatalf("❌ 连接源数据库失败: %v", err)
 	}
 	defer sourceDB.Close()
 
+	sourceConn, err := ConnectClickHouseNative(config.Source, config.Sync, limiter.Source, sourceHealth)
+	if err != nil {
+		log.Fatalf("❌ 建立源数据库原生连接失败: %v", err)
+	}
+	defer sourceConn.Close()
+
 	log.Println("🔌 连接目标数据库...")
-	targetDB, err := ConnectClickHouse(config.Target, config.Sync)
+	targetDB, err := ConnectClickHouse(config.Target, config.Sync, limiter.Target, targetHealth)
 	if err != nil {
 		log.Fatalf("❌ 连接目标数据库失败: %v", err)
 	}
 	defer targetDB.Close()
 
+	targetConn, err := ConnectClickHouseNative(config.Target, config.Sync, limiter.Target, targetHealth)
+	if err != nil {
+		log.Fatalf("❌ 建立目标数据库原生连接失败: %v", err)
+	}
+	defer targetConn.Close()
+
 	log.Println("✅ 数据库连接成功")
 
 	// 获取数据库版本信息
@@ -95,10 +129,26 @@ func main() {
 		return
 	}
 
-	// 8. 打印同步计划
+	// 8. 只验证模式
+	if *validateOnly {
+		if !runValidateOnly(sourceDB, targetDB, config) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 9. 修复模式
+	if *repair {
+		if !runRepair(sourceDB, targetDB, sourceConn, targetConn, config) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 10. 打印同步计划
 	PrintSyncPlan(config)
 
-	// 9. 确认执行
+	// 11. 确认执行
 	if !*skipConfirm {
 		if !AskConfirmation("即将开始同步，是否继续?") {
 			log.Println("❌ 取消同步")
@@ -106,7 +156,7 @@ func main() {
 		}
 	}
 
-	// 10. 表结构同步
+	// 12. 表结构同步
 	if config.Sync.SchemaSync.Enabled {
 		log.Println("\n🔧 开始同步表结构...")
 		schemaSyncer := NewSchemaSyncer(sourceDB, targetDB, &config.Sync.SchemaSync)
@@ -125,15 +175,18 @@ func main() {
 		log.Println("✅ 所有表结构同步完成")
 	}
 
-	// 11. 执行数据同步（智能循环模式）
+	// 13. 执行数据同步（智能循环模式）
 	log.Println("🚀 开始数据同步...")
 	ctx := context.Background()
-	coordinator := NewSyncCoordinator(sourceDB, targetDB, config)
+	coordinator := NewSyncCoordinator(sourceDB, targetDB, sourceConn, targetConn, config, limiter, sourceHealth)
 
 	// 设置信号处理（用于优雅退出）
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	// 收到 SIGHUP 时重新加载限流配置（不中断进行中的同步）
+	go reloadLimitsOnSignal(*configPath, coordinator)
+
 	// 智能循环模式
 	log.Printf("🔄 智能循环模式已启用")
 	log.Printf("⚙️  实时阈值: %d 秒（延迟超过此值会先追平历史数据）", *realtimeThreshold)
@@ -149,8 +202,14 @@ func main() {
 		log.Printf("🔄 开始第 %d 次同步循环", cycleCount)
 		log.Printf("========================================\n")
 
+		// 源库或目标库所有节点都熔断时跳过本轮，等待节点恢复
 		startTime := time.Now()
-		err := coordinator.SyncAllTablesWithSmartMode(ctx, realtimeThresholdDuration)
+		var err error
+		if !sourceHealth.Available() || !targetHealth.Available() {
+			err = ErrAllNodesUnavailable
+		} else {
+			err = coordinator.SyncAllTablesWithSmartMode(ctx, realtimeThresholdDuration)
+		}
 		duration := time.Since(startTime)
 
 		if err != nil {
@@ -174,8 +233,107 @@ func main() {
 	}
 }
 
+// runValidateOnly 审计所有启用表的已同步范围，全部通过时返回 true
+func runValidateOnly(sourceDB, targetDB *sql.DB, config *Config) bool {
+	log.Println("🔍 只验证模式：逐分段对比源库与目标库记录数...")
+
+	ctx := context.Background()
+	validator := NewValidator(sourceDB, targetDB, config)
+	stateManager := NewStateManager(config.Sync.StateFile)
+	results := make(map[string]error)
+
+	for _, tableConfig := range config.Tables {
+		if !tableConfig.Enabled {
+			continue
+		}
+
+		// 分区分段的表按分区审计
+		if tableConfig.GetEffectiveSegmentation(config.Sync.Segmentation) == "partition" {
+			results[tableConfig.Name] = validator.AuditPartitions(ctx, tableConfig, stateManager)
+			continue
+		}
+
+		schema, err := DetectTableSchema(sourceDB, tableConfig.Name)
+		if err != nil {
+			results[tableConfig.Name] = fmt.Errorf("failed to detect schema: %w", err)
+			continue
+		}
+		loc, err := ResolveTableLocation(ctx, sourceDB, config, tableConfig, schema)
+		if err != nil {
+			results[tableConfig.Name] = fmt.Errorf("failed to resolve timezone: %w", err)
+			continue
+		}
+
+		timeRange, err := AuditTimeRange(config, stateManager.GetTableState(tableConfig.Name), loc)
+		if err != nil {
+			results[tableConfig.Name] = err
+			continue
+		}
+		results[tableConfig.Name] = validator.AuditTable(ctx, tableConfig, timeRange, loc, stateManager)
+	}
+
+	validator.PrintValidationSummary(results)
+
+	for _, err := range results {
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// runRepair 修复所有启用表中验证失败或未完成修复的分段，全部成功时返回 true
+func runRepair(sourceDB, targetDB *sql.DB, sourceConn, targetConn driver.Conn, config *Config) bool {
+	log.Println("🛠️  修复模式：二分定位差异分段并重新复制...")
+
+	ctx := context.Background()
+	stateManager := NewStateManager(config.Sync.StateFile)
+	success := true
+
+	for _, tableConfig := range config.Tables {
+		if !tableConfig.Enabled {
+			continue
+		}
+
+		syncer, err := NewUniversalSyncer(tableConfig, sourceDB, targetDB, sourceConn, targetConn, config, stateManager)
+		if err != nil {
+			log.Printf("❌ %s: 创建同步器失败: %v", tableConfig.Name, err)
+			success = false
+			continue
+		}
+
+		if err := syncer.RepairDivergentSegments(ctx); err != nil {
+			log.Printf("❌ %s: 修复失败: %v", tableConfig.Name, err)
+			stateManager.MarkTableFailed(tableConfig.Name)
+			success = false
+		}
+	}
+
+	return success
+}
+
+// reloadLimitsOnSignal 每次收到 SIGHUP 时重新读取配置文件中的 sync.limits 并应用到协调器
+func reloadLimitsOnSignal(configPath string, coordinator *SyncCoordinator) {
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	for range hupChan {
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			log.Printf("❌ 重新加载限流配置失败: %v", err)
+			continue
+		}
+		if err := config.Sync.Limits.Validate(); err != nil {
+			log.Printf("❌ 限流配置无效，保持当前配置: %v", err)
+			continue
+		}
+		coordinator.UpdateLimits(config.Sync.Limits)
+	}
+}
+
 func init() {
 	// 设置日志格式
 	log.SetFlags(log.Ldate | log.Ltime)
-	log.SetOutput(os.Stdout)
+	// 日志中的密码等密钥（错误信息、remote() 语句等）替换为 ******
+	log.SetOutput(redactingWriter{w: os.Stdout})
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// insertBatchNative 使用原生协议按列批量插入（整个批次作为一个 Block 发送）
+func (s *UniversalSyncer) insertBatchNative(ctx context.Context, batch *RowBatch, columns []string) (int, error) {
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s)", s.destTable(), strings.Join(columns, ", "))
+	conn := s.targetConn
+	if s.stagingConn != nil {
+		// 替换写入：暂存表只存在于固定连接所在的节点
+		conn = &pinnedConn{Conn: s.targetConn, conn: s.stagingConn}
+	}
+	nativeBatch, err := conn.PrepareBatch(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare batch: %w", err)
+	}
+	defer nativeBatch.Abort()
+
+	if err := batch.AppendTo(nativeBatch); err != nil {
+		return 0, fmt.Errorf("failed to append columns: %w", err)
+	}
+
+	// 多个写入协程时按批次顺序发送
+	if err := awaitCommitTurn(ctx); err != nil {
+		return 0, err
+	}
+	if err := nativeBatch.Send(); err != nil {
+		return 0, fmt.Errorf("failed to send batch: %w", err)
+	}
+
+	return batch.Len(), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// ConnectionBudget 所有表共享的连接预算：限制同时进行的分段同步数量（sync.max_connections）
+// 表之间的并发由协调器控制，表内的分段并发（segment_concurrency）从同一预算中获取名额
+type ConnectionBudget struct {
+	slots chan struct{}
+}
+
+// NewConnectionBudget 创建连接预算
+func NewConnectionBudget(size int) *ConnectionBudget {
+	if size < 1 {
+		size = 1
+	}
+	return &ConnectionBudget{slots: make(chan struct{}, size)}
+}
+
+// Acquire 获取一个名额（预算为 nil 时不限制）
+func (b *ConnectionBudget) Acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release 归还一个名额
+func (b *ConnectionBudget) Release() {
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
+
+// forEachSegment 依次或按 segment_concurrency 并行处理 count 个分段，每个分段占用连接预算中的一个名额
+// 并行时每个工作协程使用同步器的副本（写入表、去重开关等按分段设置的字段互不影响），分段的完成顺序不固定；
+// 任一分段失败后不再启动新的分段，取消进行中的分段并返回第一个错误。所有分段结束后才返回
+func (s *UniversalSyncer) forEachSegment(ctx context.Context, count int, fn func(ctx context.Context, worker *UniversalSyncer, i int) error) error {
+	concurrency := s.tableConfig.GetEffectiveSegmentConcurrency()
+	if concurrency > count {
+		concurrency = count
+	}
+
+	if concurrency <= 1 {
+		for i := 0; i < count; i++ {
+			if err := s.budget.Acquire(ctx); err != nil {
+				return err
+			}
+			err := fn(ctx, s, i)
+			s.budget.Release()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	indexes := make(chan int)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := *s
+			for i := range indexes {
+				if workerCtx.Err() != nil {
+					continue
+				}
+				if err := s.budget.Acquire(workerCtx); err != nil {
+					fail(err)
+					continue
+				}
+				err := fn(workerCtx, &worker, i)
+				s.budget.Release()
+				if err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := 0; i < count; i++ {
+		select {
+		case indexes <- i:
+		case <-workerCtx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 并行分段乱序完成：中间的分段失败、之后的分段已完成时，续传起点必须是失败的分段，而不是已写入的最大时间
+func TestParallelSegmentsResumeFromEarliestIncomplete(t *testing.T) {
+	sm := NewStateManager(filepath.Join(t.TempDir(), "state.json"))
+	s := &UniversalSyncer{
+		tableName:   "events",
+		tableConfig: TableConfig{Name: "events", SegmentConcurrency: 5},
+		state:       sm,
+	}
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	segments := make([]TimeSegment, 5)
+	for i := range segments {
+		segments[i] = TimeSegment{Start: start.AddDate(0, 0, i), End: start.AddDate(0, 0, i+1)}
+	}
+	sm.StartSegments(s.tableName, segments)
+
+	// 分段 3（下标 2）等其余分段全部完成后才失败
+	var others sync.WaitGroup
+	others.Add(len(segments) - 1)
+	errSegment := errors.New("segment failed")
+	err := s.forEachSegment(context.Background(), len(segments), func(ctx context.Context, worker *UniversalSyncer, i int) error {
+		if i == 2 {
+			others.Wait()
+			return errSegment
+		}
+		defer others.Done()
+		worker.state.MarkSegmentCompleted(worker.tableName, segments[i], 10)
+		return nil
+	})
+	if !errors.Is(err, errSegment) {
+		t.Fatalf("forEachSegment() = %v, want %v", err, errSegment)
+	}
+
+	pending, ok := sm.GetEarliestPendingSegment(s.tableName)
+	if !ok || !pending.Start.Equal(segments[2].Start) {
+		t.Fatalf("GetEarliestPendingSegment() = %v, %v, want %v", pending, ok, segments[2])
+	}
+
+	// 重新计划时已完成的分段不再计入待完成分段；失败的分段完成后不再有续传缺口
+	sm.StartSegments(s.tableName, segments[2:])
+	sm.MarkSegmentCompleted(s.tableName, segments[2], 10)
+	if pending, ok := sm.GetEarliestPendingSegment(s.tableName); ok {
+		t.Errorf("GetEarliestPendingSegment() = %v after all segments completed", pending)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Partition 源库表的一个活跃分区
+type Partition struct {
+	ID   string // partition_id
+	Name string // 分区表达式的值（system.parts.partition）
+	Rows uint64 // 活跃数据块的行数合计
+}
+
+// ListPartitions 从 system.parts 列出表的活跃分区及行数（按分区 ID 排序）
+func ListPartitions(ctx context.Context, db *sql.DB, tableName string) ([]Partition, error) {
+	query := `
+		SELECT partition_id, any(partition), sum(rows)
+		FROM system.parts
+		WHERE database = currentDatabase() AND table = ? AND active
+		GROUP BY partition_id
+		ORDER BY partition_id
+	`
+	rows, err := db.QueryContext(ctx, query, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query partitions: %w", err)
+	}
+	defer rows.Close()
+
+	partitions := []Partition{}
+	for rows.Next() {
+		var partition Partition
+		if err := rows.Scan(&partition.ID, &partition.Name, &partition.Rows); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		partitions = append(partitions, partition)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating partitions: %w", err)
+	}
+	return partitions, nil
+}
+
+// PartitionRange 返回分区的查询范围（按时间字段排序读取）
+func PartitionRange(timeField, partitionID string) SegmentRange {
+	return SegmentRange{Field: timeField, Partition: partitionID}
+}
+
+// partitionSync 按分区同步：从源库 system.parts 列出活跃分区，逐个分区去重写入并验证
+// 源库行数与上次完成时相同的分区跳过，行数变化的分区（如仍在写入的最新分区）重新同步
+func (s *UniversalSyncer) partitionSync(ctx context.Context) error {
+	if err := s.checkPartitionKey(); err != nil {
+		return err
+	}
+
+	// 1. 列出源库分区
+	partitions, err := ListPartitions(ctx, s.sourceDB, s.tableName)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		log.Printf("⏭️  %s: 源库无数据，跳过同步", s.tableName)
+		return ErrSourceTableEmpty
+	}
+
+	// 2. 过滤已完成且没有变化的分区
+	pending := []Partition{}
+	var totalRows uint64
+	for _, partition := range partitions {
+		if !s.skipCheckpoint && s.state.IsPartitionCompleted(s.tableName, partition.ID, partition.Rows) {
+			continue
+		}
+		pending = append(pending, partition)
+		totalRows += partition.Rows
+	}
+	if len(pending) == 0 {
+		log.Printf("⏭️  %s: %d 个分区均已同步且没有变化", s.tableName, len(partitions))
+		return nil
+	}
+
+	log.Printf("📦 %s: 按分区同步 %d/%d 个分区，源库共 %s 行",
+		s.tableName, len(pending), len(partitions), FormatNumber(int(totalRows)))
+
+	// 3. 逐个分区同步（segment_concurrency > 1 时并行）
+	var mu sync.Mutex
+	totalRecords := 0
+	var doneRows uint64
+	err = s.forEachSegment(ctx, len(pending), func(ctx context.Context, worker *UniversalSyncer, i int) error {
+		partition := pending[i]
+		r := PartitionRange(worker.tableConfig.TimeField, partition.ID)
+
+		recordCount, err := worker.syncPartition(ctx, partition)
+		if err != nil {
+			return fmt.Errorf("failed to sync partition %s: %w", partition.ID, err)
+		}
+
+		// 验证该分区（未通过则不记录完成，下次运行会重新同步）
+		if !worker.config.Sync.SkipValidation {
+			result, err := worker.validator.ValidateRange(ctx, worker.tableName, r)
+			if err != nil {
+				return fmt.Errorf("failed to validate partition: %w", err)
+			}
+			result.Range = r.String()
+			worker.state.RecordValidation(worker.tableName, *result)
+			if !result.Passed {
+				log.Printf("❌ %s: 分区 %s 验证失败 - 源库 %d 条，目标库 %d 条 (%.2f%%) %s",
+					worker.tableName, partition.ID, result.SourceCount, result.TargetCount,
+					result.Ratio()*100, formatMismatches(result.Mismatches))
+				return fmt.Errorf("partition %s: %w", partition.ID, worker.validator.validationError(result))
+			}
+		}
+
+		if !worker.skipCheckpoint {
+			worker.state.MarkPartitionCompleted(worker.tableName, partition, recordCount)
+		}
+
+		mu.Lock()
+		totalRecords += recordCount
+		doneRows += partition.Rows
+		progress := 100.0
+		if totalRows > 0 {
+			progress = float64(doneRows) / float64(totalRows) * 100
+		}
+		mu.Unlock()
+
+		log.Printf("✅ %s: 分区 %s 完成（%d/%d，进度 %.1f%%），同步 %d 条记录",
+			worker.tableName, partition.Name, i+1, len(pending), progress, recordCount)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("🎉 %s: 分区同步完成，总计 %d 条记录", s.tableName, totalRecords)
+	return nil
+}
+
+// syncPartition 同步一个分区
+func (s *UniversalSyncer) syncPartition(ctx context.Context, partition Partition) (int, error) {
+	if s.insertTable == "" && s.tableConfig.GetEffectiveWriteMode(s.config.Sync.WriteMode) == "replace_segment" {
+		return s.replacePartition(ctx, partition)
+	}
+	return s.copyRange(ctx, PartitionRange(s.tableConfig.TimeField, partition.ID))
+}
+
+// replacePartition 替换写入一个分区：完整复制到暂存表后 REPLACE PARTITION（分区天然对齐，始终原子替换）
+func (s *UniversalSyncer) replacePartition(ctx context.Context, partition Partition) (int, error) {
+	release, err := s.pinStagingConn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	// 恢复或回滚该分区上一次未完成的替换
+	if pending := s.state.GetReplace(s.tableName, TimeSegment{}, partition.ID); pending != nil {
+		recordCount, resumed, err := s.resumeReplace(ctx, *pending)
+		if err != nil || resumed {
+			return recordCount, err
+		}
+	}
+
+	stagingTable := fmt.Sprintf("_ch_sync_stage_%s_p%s", s.tableName, identifierSuffix(partition.ID))
+	if err := s.createStagingTable(ctx, stagingTable); err != nil {
+		return 0, err
+	}
+	s.state.StartReplace(s.tableName, TimeSegment{}, partition.ID, stagingTable)
+
+	log.Printf("🔁 %s: 替换写入分区 %s，先复制到暂存表 %s", s.tableName, partition.ID, stagingTable)
+
+	// 1. 完整复制源库分区到暂存表（不去重）
+	prevTable, prevSkipDedup := s.insertTable, s.skipDedup
+	s.insertTable, s.skipDedup = stagingTable, true
+	recordCount, err := s.copyRange(ctx, PartitionRange(s.tableConfig.TimeField, partition.ID))
+	s.insertTable, s.skipDedup = prevTable, prevSkipDedup
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy partition to staging table: %w", err)
+	}
+	s.state.UpdateReplace(s.tableName, TimeSegment{}, partition.ID, "staged", recordCount)
+
+	// 2. 原子替换目标库分区
+	if err := s.swapPartition(ctx, partition.ID, stagingTable); err != nil {
+		return 0, err
+	}
+
+	s.state.FinishReplace(s.tableName, TimeSegment{}, partition.ID)
+	s.dropStagingTable(stagingTable)
+	return recordCount, nil
+}
+
+// swapPartition 用暂存表中的分区替换目标库分区（暂存表没有该分区时目标库分区被清空）
+func (s *UniversalSyncer) swapPartition(ctx context.Context, partitionID, stagingTable string) error {
+	s.state.UpdateReplace(s.tableName, TimeSegment{}, partitionID, "swapping", -1)
+
+	query := fmt.Sprintf("ALTER TABLE %s REPLACE PARTITION ID %s FROM %s",
+		s.tableName, quoteString(partitionID), stagingTable)
+	if _, err := s.targetSQL().ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to replace partition %s: %w", partitionID, err)
+	}
+
+	log.Printf("🔁 %s: 已通过 REPLACE PARTITION 替换分区 %s", s.tableName, partitionID)
+	return nil
+}
+
+// checkPartitionKey 检查分区分段的前提：源库表有 PARTITION BY，且目标库表的分区表达式相同（分区 ID 一致）
+func (s *UniversalSyncer) checkPartitionKey() error {
+	if s.tableSchema.PartitionBy == "" {
+		return fmt.Errorf("table %s has no PARTITION BY, segmentation 'partition' requires a partitioned table", s.tableName)
+	}
+
+	targetSchema, err := DetectTableSchema(s.targetDB, s.tableName)
+	if err != nil {
+		return fmt.Errorf("failed to detect target schema: %w", err)
+	}
+	if targetSchema.PartitionBy != s.tableSchema.PartitionBy {
+		return fmt.Errorf("partition key of target table %s (%s) differs from source (%s)",
+			s.tableName, targetSchema.PartitionBy, s.tableSchema.PartitionBy)
+	}
+	return nil
+}
+
+// identifierSuffix 把分区 ID 转换为可用于表名的后缀
+func identifierSuffix(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// copyPipeline 分段复制流水线：读取、去重、写入三个阶段并发执行，阶段之间通过有界队列传递批次
+//
+//	读取协程 ──read──▶ 去重协程 ──ready──▶ 写入协程 × insert_workers
+//	    ▲                                         │
+//	    └──────────────── free ◀──────────────────┘
+//
+// 批次在 free 队列中循环复用，在途批次数量固定，下游变慢时读取阶段在 free 上等待（背压），
+// 不会无限制地占用内存。任一阶段出错时取消其余阶段，返回最先发生的错误
+//
+// 多个写入协程并发转换和缓冲批次，但按读取顺序依次提交：批次 N 失败时之后的批次不会先写入目标库，
+// 按目标库最大时间续传（auto_detect、实时模式）不会越过未写入的批次
+type copyPipeline struct {
+	s            *UniversalSyncer
+	r            SegmentRange
+	columns      []string
+	existingKeys KeySet         // memory 去重策略下目标库已存在的键
+	keyTable     *dedupKeyTable // anti_join 去重策略下的键表
+	batchSize    int
+	chunkBatches int // 去重阶段一次最多合并的批次数（anti_join 按键块查询目标库，其它策略为 1）
+	workers      int
+	commits      *commitSequencer // 多个写入协程时按批次序号依次提交（单个写入协程时为 nil）
+
+	free  chan *segmentBatch // 空闲批次（nil 表示尚未分配）
+	read  chan *segmentBatch // 已读取，待去重
+	ready chan *segmentBatch // 已去重，待写入
+
+	mu       sync.Mutex
+	scanned  int
+	inserted int
+	skipped  int
+	updated  int
+	batches  int
+	stats    pipelineStats
+}
+
+// segmentBatch 流水线中传递的批次
+type segmentBatch struct {
+	*RowBatch
+	index int // 批次在分段内按读取顺序的序号（用于 insert_deduplication_token）
+}
+
+// pipelineStats 流水线各阶段的等待时间，用于判断瓶颈所在的阶段
+type pipelineStats struct {
+	ReadBlocked   time.Duration // 读取阶段等待空闲批次或等待去重阶段接收（下游跟不上）
+	DedupStarved  time.Duration // 去重阶段等待读取阶段（源库跟不上）
+	DedupBlocked  time.Duration // 去重阶段等待写入阶段接收
+	InsertStarved time.Duration // 写入协程等待批次的时间合计
+	InsertTime    time.Duration // 写入协程执行插入的时间合计（含等待按序提交）
+	CommitWait    time.Duration // 写入协程等待前面的批次提交的时间合计
+}
+
+// newCopyPipeline 创建分段复制流水线
+func newCopyPipeline(s *UniversalSyncer, r SegmentRange, columns []string, existingKeys KeySet, keyTable *dedupKeyTable) *copyPipeline {
+	queueSize := s.config.Sync.Pipeline.QueueSize
+	workers := s.config.Sync.Pipeline.InsertWorkers
+	if s.stagingConn != nil {
+		// 替换写入时所有批次写入固定连接上的暂存表，一个连接不能并发执行事务
+		workers = 1
+	}
+	batchSize := s.tableConfig.GetEffectiveBatchSize(s.config.Sync.BatchSize)
+	chunkBatches := 1
+	if keyTable != nil && antiJoinChunkRows/batchSize > 1 {
+		chunkBatches = antiJoinChunkRows / batchSize
+	}
+
+	// 在途批次：两个队列各 queueSize 个，加上读取阶段、去重阶段（键块）和每个写入协程手中的批次
+	inFlight := 2*queueSize + 1 + chunkBatches + workers
+	free := make(chan *segmentBatch, inFlight)
+	for i := 0; i < inFlight; i++ {
+		free <- nil
+	}
+
+	var commits *commitSequencer
+	if workers > 1 {
+		commits = newCommitSequencer()
+	}
+
+	return &copyPipeline{
+		s:            s,
+		r:            r,
+		columns:      columns,
+		existingKeys: existingKeys,
+		keyTable:     keyTable,
+		batchSize:    batchSize,
+		chunkBatches: chunkBatches,
+		workers:      workers,
+		commits:      commits,
+		free:         free,
+		read:         make(chan *segmentBatch, queueSize),
+		ready:        make(chan *segmentBatch, queueSize),
+	}
+}
+
+// run 运行流水线直到源库数据读完或出错，所有阶段结束后才返回
+func (p *copyPipeline) run(ctx context.Context, rows driver.Rows, scanner *RowScanner) error {
+	stageCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	// 只记录第一个错误：其余阶段随后因取消而返回的错误不会覆盖真正的原因
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	stage := func(fn func(ctx context.Context) error, done func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if done != nil {
+				defer done()
+			}
+			if err := fn(stageCtx); err != nil {
+				fail(err)
+			}
+		}()
+	}
+
+	stage(func(ctx context.Context) error {
+		return p.readRows(ctx, rows, scanner)
+	}, func() { close(p.read) })
+	stage(p.dedupe, func() { close(p.ready) })
+	for w := 0; w < p.workers; w++ {
+		stage(p.insert, nil)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// readRows 读取阶段：从源库流式扫描行，每满 batch_size 行交给去重阶段
+func (p *copyPipeline) readRows(ctx context.Context, rows driver.Rows, scanner *RowScanner) error {
+	var batch *segmentBatch
+	index := 0
+	for rows.Next() {
+		if batch == nil {
+			var err error
+			if batch, err = p.acquire(ctx, scanner); err != nil {
+				return err
+			}
+			batch.index = index
+			index++
+		}
+
+		// 扫描一行数据（扫描目标跨行复用）
+		if err := scanner.Scan(rows); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		batch.AppendFrom(scanner)
+
+		if batch.Len() >= p.batchSize {
+			if err := p.sendRead(ctx, batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	// 剩余数据
+	if batch != nil && batch.Len() > 0 {
+		return p.sendRead(ctx, batch)
+	}
+	return nil
+}
+
+// acquire 从空闲队列取一个批次（所有批次都在途时等待，即背压）
+func (p *copyPipeline) acquire(ctx context.Context, scanner *RowScanner) (*segmentBatch, error) {
+	start := time.Now()
+	select {
+	case batch := <-p.free:
+		p.stall(&p.stats.ReadBlocked, start)
+		if batch == nil {
+			batch = &segmentBatch{RowBatch: NewRowBatch(scanner, p.batchSize)}
+		}
+		return batch, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// sendRead 把读满的批次交给去重阶段
+func (p *copyPipeline) sendRead(ctx context.Context, batch *segmentBatch) error {
+	p.mu.Lock()
+	p.scanned += batch.Len()
+	p.mu.Unlock()
+	return p.send(ctx, p.read, batch, &p.stats.ReadBlocked)
+}
+
+// dedupe 去重阶段：按去重策略剔除目标库已存在的记录，非空批次交给写入阶段
+func (p *copyPipeline) dedupe(ctx context.Context) error {
+	for {
+		chunk, err := p.receiveChunk(ctx)
+		if err != nil || len(chunk) == 0 {
+			return err
+		}
+
+		skipped, updated, err := p.filter(ctx, chunk)
+		if err != nil {
+			return fmt.Errorf("failed to filter existing rows: %w", err)
+		}
+		p.mu.Lock()
+		p.skipped += skipped
+		p.updated += updated
+		p.mu.Unlock()
+
+		for _, batch := range chunk {
+			if batch.Len() == 0 {
+				// 全部已存在的批次不写入，后面的批次不必等它提交
+				p.commits.done(batch.index)
+				p.recycle(batch)
+				continue
+			}
+			if err := p.send(ctx, p.ready, batch, &p.stats.DedupBlocked); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// receiveChunk 接收下一组待去重的批次：anti_join 策略下合并多个批次（最多 chunkBatches 个、
+// antiJoinChunkRows 行）作为一个键块，读取阶段结束时返回已收到的批次；上游结束且没有批次时返回空
+func (p *copyPipeline) receiveChunk(ctx context.Context) ([]*segmentBatch, error) {
+	chunk := []*segmentBatch{}
+	rows := 0
+	for len(chunk) < p.chunkBatches && rows < antiJoinChunkRows {
+		batch, ok, err := p.receive(ctx, p.read, &p.stats.DedupStarved)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		chunk = append(chunk, batch)
+		rows += batch.Len()
+	}
+	return chunk, nil
+}
+
+// filter 剔除批次中目标库已存在的记录（源库版本更新的记录保留），返回跳过与重新写入的条数
+func (p *copyPipeline) filter(ctx context.Context, chunk []*segmentBatch) (int, int, error) {
+	if p.keyTable != nil {
+		batches := make([]*RowBatch, len(chunk))
+		for i, batch := range chunk {
+			batches[i] = batch.RowBatch
+		}
+		return p.s.filterExistingRows(ctx, batches, p.r, p.keyTable)
+	}
+	if p.existingKeys.Len() == 0 {
+		return 0, 0, nil
+	}
+
+	skipped, updated := 0, 0
+	for _, batch := range chunk {
+		keep := make([]bool, batch.Len())
+		for row := range keep {
+			action := p.s.deduplicator.Classify(p.existingKeys,
+				p.s.deduplicator.BatchKeyValues(batch.RowBatch, row), p.s.deduplicator.BatchVersion(batch.RowBatch, row))
+			keep[row] = action != rowSkip
+			switch action {
+			case rowSkip:
+				skipped++
+			case rowUpdate:
+				updated++
+			}
+		}
+		batch.Filter(keep)
+	}
+
+	return skipped, updated, nil
+}
+
+// insert 写入阶段：把去重后的批次写入目标库（可重试的错误按退避重试），写完的批次归还空闲队列
+func (p *copyPipeline) insert(ctx context.Context) error {
+	for {
+		batch, ok, err := p.receive(ctx, p.ready, &p.stats.InsertStarved)
+		if err != nil || !ok {
+			return err
+		}
+
+		start := time.Now()
+		insertCtx := ctx
+		if p.commits != nil {
+			index := batch.index
+			insertCtx = withCommitTurn(ctx, func(ctx context.Context) error {
+				waitStart := time.Now()
+				err := p.commits.wait(ctx, index)
+				p.stall(&p.stats.CommitWait, waitStart)
+				return err
+			})
+		}
+		token := p.s.insertToken(ctx, p.r.String(), batch.index, batch.RowBatch)
+		inserted, err := p.s.insertBatch(insertCtx, batch.RowBatch, p.columns, token)
+		if err != nil {
+			return fmt.Errorf("failed to insert batch: %w", err)
+		}
+		p.commits.done(batch.index)
+
+		p.mu.Lock()
+		p.stats.InsertTime += time.Since(start)
+		p.inserted += inserted
+		p.batches++
+		batchCount, scanned, totalInserted, skipped := p.batches, p.scanned, p.inserted, p.skipped
+		p.mu.Unlock()
+
+		log.Printf("📦 %s: 批次 #%d 插入 %d 条 | 累计: 扫描 %d, 插入 %d, 跳过 %d",
+			p.s.tableName, batchCount, inserted, scanned, totalInserted, skipped)
+
+		p.recycle(batch)
+	}
+}
+
+// send 把批次发送到下游队列，队列已满时等待并计入 blocked
+func (p *copyPipeline) send(ctx context.Context, queue chan<- *segmentBatch, batch *segmentBatch, blocked *time.Duration) error {
+	start := time.Now()
+	select {
+	case queue <- batch:
+		p.stall(blocked, start)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// receive 从上游队列接收批次，队列为空时等待并计入 starved；上游结束时 ok 为 false
+func (p *copyPipeline) receive(ctx context.Context, queue <-chan *segmentBatch, starved *time.Duration) (*segmentBatch, bool, error) {
+	start := time.Now()
+	select {
+	case batch, ok := <-queue:
+		p.stall(starved, start)
+		return batch, ok, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// recycle 清空批次并归还空闲队列（队列容量等于批次总数，不会阻塞）
+func (p *copyPipeline) recycle(batch *segmentBatch) {
+	batch.Reset()
+	p.free <- batch
+}
+
+// stall 累加阶段等待时间
+func (p *copyPipeline) stall(d *time.Duration, start time.Time) {
+	p.mu.Lock()
+	*d += time.Since(start)
+	p.mu.Unlock()
+}
+
+// logStats 输出各阶段的等待时间
+func (p *copyPipeline) logStats() {
+	p.mu.Lock()
+	stats := p.stats
+	p.mu.Unlock()
+
+	log.Printf("⏱️  %s: 流水线等待 - 读取阻塞 %s | 去重等待读取 %s, 等待写入 %s | 写入等待 %s, 写入耗时 %s, 按序提交等待 %s（%d 个写入协程合计）",
+		p.s.tableName, FormatDuration(stats.ReadBlocked), FormatDuration(stats.DedupStarved),
+		FormatDuration(stats.DedupBlocked), FormatDuration(stats.InsertStarved),
+		FormatDuration(stats.InsertTime), FormatDuration(stats.CommitWait), p.workers)
+}
+
+// commitSequencer 按批次序号依次放行提交：序号为 n 的批次等序号小于 n 的批次全部提交
+// （或在去重阶段整批剔除）之后才能提交
+type commitSequencer struct {
+	mu       sync.Mutex
+	next     int           // 下一个可以提交的序号
+	finished map[int]bool  // 已完成但前面还有未完成批次的序号
+	advanced chan struct{} // next 推进时关闭并替换，唤醒等待的协程
+}
+
+func newCommitSequencer() *commitSequencer {
+	return &commitSequencer{finished: make(map[int]bool), advanced: make(chan struct{})}
+}
+
+// wait 等待轮到序号 index 提交
+func (q *commitSequencer) wait(ctx context.Context, index int) error {
+	for {
+		q.mu.Lock()
+		if q.next == index {
+			q.mu.Unlock()
+			return nil
+		}
+		advanced := q.advanced
+		q.mu.Unlock()
+
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// done 标记序号 index 已提交（或无需提交），推进下一个可以提交的序号（sequencer 为 nil 时不做任何事）
+func (q *commitSequencer) done(index int) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.finished[index] = true
+	if !q.finished[q.next] {
+		return
+	}
+	for q.finished[q.next] {
+		delete(q.finished, q.next)
+		q.next++
+	}
+	close(q.advanced)
+	q.advanced = make(chan struct{})
+}
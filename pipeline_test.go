@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 写入协程乱序拿到批次时，提交顺序仍与批次序号一致；去重阶段剔除的批次不阻塞后面的批次
+func TestCommitSequencerCommitsInIndexOrder(t *testing.T) {
+	q := newCommitSequencer()
+	q.done(1) // 批次 1 在去重阶段被整批剔除
+
+	var (
+		mu        sync.Mutex
+		committed []int
+		wg        sync.WaitGroup
+	)
+	for _, index := range []int{5, 3, 0, 4, 2} {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			if err := q.wait(context.Background(), index); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			committed = append(committed, index)
+			mu.Unlock()
+			q.done(index)
+		}(index)
+	}
+	wg.Wait()
+
+	want := []int{0, 2, 3, 4, 5}
+	if len(committed) != len(want) {
+		t.Fatalf("committed %v, want %v", committed, want)
+	}
+	for i := range want {
+		if committed[i] != want[i] {
+			t.Fatalf("committed %v, want %v", committed, want)
+		}
+	}
+}
+
+// 前面的批次失败（不再提交）时，等待中的批次随流水线取消返回，不会先于它写入
+func TestCommitSequencerWaitCanceled(t *testing.T) {
+	q := newCommitSequencer()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := q.wait(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := q.wait(ctx, 0); err != nil {
+		t.Fatalf("wait(0) = %v, want nil", err)
+	}
+}
+
+func TestAwaitCommitTurnWithoutSequencer(t *testing.T) {
+	if err := awaitCommitTurn(context.Background()); err != nil {
+		t.Fatalf("awaitCommitTurn() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// syncSegmentRemote 服务端传输：在目标库执行 INSERT ... SELECT FROM remote()，数据不经过本进程
+func (s *UniversalSyncer) syncSegmentRemote(ctx context.Context, r SegmentRange) (int, error) {
+	log.Printf("⏰ %s: 服务端同步分段 %s", s.tableName, r)
+
+	// 1. 记录目标库该分段已有的记录数（用于计算新增条数）
+	beforeCount, err := s.countTargetRecords(ctx, r)
+	if err != nil {
+		return 0, err
+	}
+
+	// 2. 构建 INSERT ... SELECT FROM remote() 语句
+	columnsStr := strings.Join(s.tableSchema.GetColumnNames(), ", ")
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s) SELECT %s FROM %s WHERE %s",
+		s.destTable(), columnsStr, columnsStr, s.remoteTableFunction(), r.Where(),
+	)
+	args := r.Args()
+
+	// 去重：在目标库侧排除已存在的去重键
+	if !s.skipDedup {
+		// 配置了版本字段时按（去重键, 版本）排除，源库版本变化的记录会重新写入
+		keysStr := strings.Join(s.deduplicator.keyQueryColumns(), ", ")
+		query += fmt.Sprintf(
+			" AND (%s) NOT IN (SELECT %s FROM %s WHERE %s)",
+			keysStr, keysStr, s.tableName, r.Where(),
+		)
+		args = append(args, r.Args()...)
+	}
+
+	// 3. 在目标库执行（按源库的读取字节数限速）
+	if _, err := s.targetSQL().ExecContext(withRemoteRead(ctx), query, args...); err != nil {
+		return 0, fmt.Errorf("failed to execute remote insert: %w", err)
+	}
+
+	// 4. 统计新增条数
+	afterCount, err := s.countTargetRecords(ctx, r)
+	if err != nil {
+		return 0, err
+	}
+
+	inserted := afterCount - beforeCount
+	if inserted < 0 {
+		inserted = 0
+	}
+
+	log.Printf("✨ %s: 服务端分段完成 - 目标库已有 %d 条, 新增 %d 条", s.tableName, beforeCount, inserted)
+	return inserted, nil
+}
+
+// remoteTableFunction 构建指向源库表的 remote() 表函数（源库启用 TLS 时为 remoteSecure()）
+// 语句中包含源库密码，密码已在加载配置时登记，出现在日志和错误信息中时替换为 ******
+func (s *UniversalSyncer) remoteTableFunction() string {
+	source := s.config.Source
+	addr := source.RemoteAddr
+	if addr == "" {
+		// 多个地址作为副本备选
+		addr = strings.Join(source.Addr, "|")
+	}
+
+	// 源库启用 TLS 时目标库也通过安全连接访问源库
+	function := "remote"
+	if source.TLS.Enabled {
+		function = "remoteSecure"
+	}
+
+	return fmt.Sprintf("%s(%s, %s, %s, %s, %s)", function,
+		quoteString(addr), quoteString(source.Database), quoteString(s.tableName),
+		quoteString(source.Username), quoteString(source.Password))
+}
+
+// quoteString 将字符串转义为 ClickHouse 字符串字面量
+func quoteString(str string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + replacer.Replace(str) + "'"
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// RepairDivergentSegments 修复状态文件中记录的差异分段（未完成的修复 + 验证失败的分段）
+func (s *UniversalSyncer) RepairDivergentSegments(ctx context.Context) error {
+	segments := s.state.GetPendingRepairSegments(s.tableName)
+	if len(segments) == 0 {
+		log.Printf("✅ %s: 没有需要修复的分段（可先运行 --validate-only 发现差异）", s.tableName)
+		return nil
+	}
+
+	log.Printf("🛠️  %s: 发现 %d 个待修复分段（策略: %s）",
+		s.tableName, len(segments), s.config.Sync.Repair.Strategy)
+
+	// 修复模式始终按分段精确处理，不使用实时模式的检查点跳过逻辑
+	s.skipCheckpoint = true
+	s.skipDedup = s.config.Sync.Repair.Strategy == "replacing"
+
+	totalRepaired := 0
+	for i, segment := range segments {
+		segment = segment.In(s.location)
+		log.Printf("🛠️  %s: 修复分段 %d/%d（%s ~ %s）", s.tableName, i+1, len(segments),
+			segment.Start.Format(time.RFC3339), segment.End.Format(time.RFC3339))
+
+		s.state.StartRepair(s.tableName, segment)
+
+		repaired, err := s.repairRange(ctx, segment, segment)
+		if err != nil {
+			return fmt.Errorf("failed to repair segment %v: %w", segment, err)
+		}
+		totalRepaired += repaired
+
+		// 修复后重新验证并更新验证记录
+		result, err := s.validator.ValidateSegment(ctx, s.tableName, s.tableConfig.TimeField, segment)
+		if err != nil {
+			return fmt.Errorf("failed to validate repaired segment: %w", err)
+		}
+		s.state.RecordValidation(s.tableName, *result)
+		if !result.Passed {
+			return fmt.Errorf("segment %s ~ %s still diverges after repair: %w",
+				segment.Start.Format(time.RFC3339), segment.End.Format(time.RFC3339),
+				s.validator.validationError(result))
+		}
+
+		s.state.CompleteRepair(s.tableName, segment)
+		log.Printf("✅ %s: 分段 %d/%d 修复完成，重新复制 %d 条记录", s.tableName, i+1, len(segments), repaired)
+	}
+
+	log.Printf("🎉 %s: 修复完成，总计重新复制 %d 条记录", s.tableName, totalRepaired)
+	return nil
+}
+
+// repairRange 二分定位差异子窗口，只重新复制不一致的部分
+func (s *UniversalSyncer) repairRange(ctx context.Context, segment, window TimeSegment) (int, error) {
+	diverges, err := s.validator.SegmentDiverges(ctx, s.tableName, s.tableConfig.TimeField, window)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compare window: %w", err)
+	}
+	if !diverges {
+		return 0, nil
+	}
+
+	minWindow := time.Duration(s.config.Sync.Repair.MinWindow) * time.Second
+	if window.End.Sub(window.Start) <= minWindow {
+		return s.repairSlice(ctx, segment, window)
+	}
+
+	mid := window.Start.Add(window.End.Sub(window.Start) / 2)
+	left, err := s.repairRange(ctx, segment, TimeSegment{Start: window.Start, End: mid})
+	if err != nil {
+		return left, err
+	}
+	right, err := s.repairRange(ctx, segment, TimeSegment{Start: mid, End: window.End})
+	return left + right, err
+}
+
+// repairSlice 重新复制一个已定位的差异子窗口
+func (s *UniversalSyncer) repairSlice(ctx context.Context, segment, slice TimeSegment) (int, error) {
+	log.Printf("🔧 %s: 定位到差异子窗口 %s ~ %s", s.tableName,
+		slice.Start.Format("2006-01-02 15:04:05"), slice.End.Format("2006-01-02 15:04:05"))
+
+	// delete 策略：先删除目标库该子窗口的数据，再完整重新复制
+	if s.config.Sync.Repair.Strategy == "delete" {
+		if err := s.deleteTargetRange(ctx, slice); err != nil {
+			return 0, err
+		}
+		ctx = withInsertTokenScope(ctx, fmt.Sprintf("repair-%d", time.Now().UnixNano()))
+	}
+
+	recordCount, err := s.syncSegment(ctx, slice)
+	if err != nil {
+		return recordCount, fmt.Errorf("failed to re-copy slice: %w", err)
+	}
+
+	s.state.MarkRepairSliceCompleted(s.tableName, segment, slice, recordCount)
+	return recordCount, nil
+}
+
+// deleteTargetRange 同步删除目标库指定时间窗口内的数据
+func (s *UniversalSyncer) deleteTargetRange(ctx context.Context, window TimeSegment) error {
+	r := TimeSegmentRange(s.tableConfig.TimeField, window)
+	query := fmt.Sprintf("ALTER TABLE %s DELETE WHERE %s", s.tableName, r.Where())
+
+	// mutations_sync = 2：等待所有副本完成删除后再返回
+	ctx = withQuerySettings(ctx, clickhouse.Settings{
+		"mutations_sync": 2,
+	})
+
+	if _, err := s.targetDB.ExecContext(ctx, query, r.Args()...); err != nil {
+		return fmt.Errorf("failed to delete target rows: %w", err)
+	}
+	return nil
+}
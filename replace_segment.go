@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// replaceSegment 替换写入：先把源库分段完整写入暂存表，再整体替换目标库该分段
+// 分段边界与分区对齐时使用 REPLACE PARTITION（原子替换），否则删除分段后从暂存表插入
+func (s *UniversalSyncer) replaceSegment(ctx context.Context, segment TimeSegment) (int, error) {
+	release, err := s.pinStagingConn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	// 恢复或回滚该分段上一次未完成的替换
+	if pending := s.state.GetReplace(s.tableName, segment, ""); pending != nil {
+		recordCount, resumed, err := s.resumeReplace(ctx, *pending)
+		if err != nil || resumed {
+			return recordCount, err
+		}
+	}
+
+	stagingTable := fmt.Sprintf("_ch_sync_stage_%s_%d", s.tableName, segment.Start.Unix())
+	if err := s.createStagingTable(ctx, stagingTable); err != nil {
+		return 0, err
+	}
+	s.state.StartReplace(s.tableName, segment, "", stagingTable)
+
+	log.Printf("🔁 %s: 替换写入，先复制到暂存表 %s", s.tableName, stagingTable)
+
+	// 1. 完整复制源库分段到暂存表（不去重）
+	prevTable, prevSkipDedup := s.insertTable, s.skipDedup
+	s.insertTable, s.skipDedup = stagingTable, true
+	recordCount, err := s.syncSegment(ctx, segment)
+	s.insertTable, s.skipDedup = prevTable, prevSkipDedup
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy segment to staging table: %w", err)
+	}
+	s.state.UpdateReplace(s.tableName, segment, "", "staged", recordCount)
+
+	// 2. 替换目标库分段
+	if err := s.swapSegment(ctx, segment, stagingTable); err != nil {
+		return 0, err
+	}
+
+	s.state.FinishReplace(s.tableName, segment, "")
+	s.dropStagingTable(stagingTable)
+	return recordCount, nil
+}
+
+// pinStagingConn 为替换写入固定一个目标库连接，返回的 release 归还连接（已固定时直接复用）
+// 暂存表是不复制的本地表，只存在于创建它的节点；多节点目标库或故障切换后连接池中的连接可能位于不同节点，
+// 暂存表的创建、写入、对齐检查与替换必须在同一个连接上执行
+func (s *UniversalSyncer) pinStagingConn(ctx context.Context) (func(), error) {
+	if s.stagingConn != nil {
+		return func() {}, nil
+	}
+	conn, err := s.targetDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pin target connection: %w", err)
+	}
+	s.stagingConn = conn
+	return func() {
+		s.stagingConn = nil
+		conn.Close()
+	}, nil
+}
+
+// resumeReplace 处理未完成的替换：暂存表已完整写入则继续替换，否则回滚（删除暂存表）
+// 返回值 resumed 表示是否已在此完成替换
+func (s *UniversalSyncer) resumeReplace(ctx context.Context, pending ReplaceRecord) (int, bool, error) {
+	exists, err := s.targetTableExists(ctx, pending.StagingTable)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if pending.Status != "staging" && exists {
+		log.Printf("♻️  %s: 恢复未完成的替换（%s，暂存表 %s，%d 条）", s.tableName,
+			replaceTarget(pending), pending.StagingTable, pending.RecordsStaged)
+
+		if pending.Partition != "" {
+			err = s.swapPartition(ctx, pending.Partition, pending.StagingTable)
+		} else {
+			err = s.swapSegment(ctx, pending.Segment, pending.StagingTable)
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		s.state.FinishReplace(s.tableName, pending.Segment, pending.Partition)
+		s.dropStagingTable(pending.StagingTable)
+		return pending.RecordsStaged, true, nil
+	}
+
+	// 暂存表未写完（或已丢失）：目标库尚未改动，回滚后重新开始
+	if pending.Status == "swapping" {
+		// 替换进行中但暂存表已丢失，无法确认目标库状态，需人工处理
+		return 0, false, fmt.Errorf("staging table %s for interrupted replace of %s is missing",
+			pending.StagingTable, replaceTarget(pending))
+	}
+
+	log.Printf("↩️  %s: 回滚未完成的替换（%s，暂存表 %s）", s.tableName,
+		replaceTarget(pending), pending.StagingTable)
+	if exists {
+		s.dropStagingTable(pending.StagingTable)
+	}
+	s.state.FinishReplace(s.tableName, pending.Segment, pending.Partition)
+	return 0, false, nil
+}
+
+// replaceTarget 返回替换记录对应的分段或分区（用于日志）
+func replaceTarget(record ReplaceRecord) string {
+	if record.Partition != "" {
+		return "分区 " + record.Partition
+	}
+	return record.Segment.Start.Format(time.RFC3339) + " ~ " + record.Segment.End.Format(time.RFC3339)
+}
+
+// resumePendingReplaces 处理状态文件中所有未完成的替换（例如实时窗口中断后不会再次出现的分段）
+func (s *UniversalSyncer) resumePendingReplaces(ctx context.Context) error {
+	pending := s.state.GetPendingReplaces(s.tableName)
+	if len(pending) == 0 {
+		return nil
+	}
+	release, err := s.pinStagingConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, pending := range pending {
+		pending.Segment = pending.Segment.In(s.location)
+		recordCount, resumed, err := s.resumeReplace(ctx, pending)
+		if err != nil {
+			return fmt.Errorf("failed to resume replace: %w", err)
+		}
+		if resumed {
+			log.Printf("✅ %s: 已完成中断的替换，写入 %d 条记录", s.tableName, recordCount)
+		}
+	}
+	return nil
+}
+
+// swapSegment 用暂存表内容替换目标库的分段
+func (s *UniversalSyncer) swapSegment(ctx context.Context, segment TimeSegment, stagingTable string) error {
+	s.state.UpdateReplace(s.tableName, segment, "", "swapping", -1)
+
+	partitions, aligned, err := s.alignedPartitions(ctx, segment, stagingTable)
+	if err != nil {
+		return err
+	}
+
+	if aligned {
+		// 分段恰好覆盖若干完整分区：逐个分区原子替换
+		for _, partition := range partitions {
+			if partition.staged {
+				query := fmt.Sprintf("ALTER TABLE %s REPLACE PARTITION ID %s FROM %s",
+					s.tableName, quoteString(partition.id), stagingTable)
+				if _, err := s.targetSQL().ExecContext(ctx, query); err != nil {
+					return fmt.Errorf("failed to replace partition %s: %w", partition.id, err)
+				}
+			} else {
+				// 源库该分区已无数据
+				query := fmt.Sprintf("ALTER TABLE %s DROP PARTITION ID %s", s.tableName, quoteString(partition.id))
+				if _, err := s.targetSQL().ExecContext(ctx, query); err != nil {
+					return fmt.Errorf("failed to drop partition %s: %w", partition.id, err)
+				}
+			}
+		}
+		log.Printf("🔁 %s: 已通过 REPLACE PARTITION 替换 %d 个分区", s.tableName, len(partitions))
+		return nil
+	}
+
+	// 分段与分区不对齐：轻量删除分段后从暂存表插入（删除与插入均可重复执行）
+	r := TimeSegmentRange(s.tableConfig.TimeField, segment)
+	deleteCtx := withQuerySettings(ctx, clickhouse.Settings{
+		"mutations_sync": 2,
+	})
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", s.tableName, r.Where())
+	if _, err := s.targetSQL().ExecContext(deleteCtx, query, r.Args()...); err != nil {
+		return fmt.Errorf("failed to delete target segment: %w", err)
+	}
+
+	// 关闭插入去重：重新写入的块与删除前写入的块相同时，Replicated 表会把它们当作重复写入丢弃，分段被清空
+	insertCtx := withQuerySettings(ctx, clickhouse.Settings{
+		"insert_deduplicate": 0,
+	})
+	columnsStr := strings.Join(s.tableSchema.GetColumnNames(), ", ")
+	query = fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", s.tableName, columnsStr, columnsStr, stagingTable)
+	if _, err := s.targetSQL().ExecContext(insertCtx, query); err != nil {
+		return fmt.Errorf("failed to insert from staging table: %w", err)
+	}
+
+	log.Printf("🔁 %s: 已删除并重新写入分段（分段与分区不对齐）", s.tableName)
+	return nil
+}
+
+// segmentPartition 分段涉及的分区
+type segmentPartition struct {
+	id     string
+	staged bool // 暂存表中是否有该分区的数据
+}
+
+// alignedPartitions 查询分段涉及的分区，并判断这些分区是否完全落在分段内
+func (s *UniversalSyncer) alignedPartitions(ctx context.Context, segment TimeSegment, stagingTable string) ([]segmentPartition, bool, error) {
+	r := TimeSegmentRange(s.tableConfig.TimeField, segment)
+
+	query := fmt.Sprintf(`
+		SELECT partition_id, max(staged)
+		FROM (
+			SELECT DISTINCT _partition_id AS partition_id, 1 AS staged FROM %s
+			UNION ALL
+			SELECT DISTINCT _partition_id AS partition_id, 0 AS staged FROM %s WHERE %s
+		)
+		GROUP BY partition_id
+		ORDER BY partition_id
+	`, stagingTable, s.tableName, r.Where())
+
+	rows, err := s.targetSQL().QueryContext(ctx, query, r.Args()...)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query segment partitions: %w", err)
+	}
+	defer rows.Close()
+
+	partitions := []segmentPartition{}
+	ids := []string{}
+	for rows.Next() {
+		var partition segmentPartition
+		var staged uint8
+		if err := rows.Scan(&partition.id, &staged); err != nil {
+			return nil, false, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		partition.staged = staged == 1
+		partitions = append(partitions, partition)
+		ids = append(ids, quoteString(partition.id))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error iterating partitions: %w", err)
+	}
+
+	if len(partitions) == 0 {
+		return partitions, true, nil
+	}
+
+	// 分区中存在分段以外的数据时不能整体替换
+	query = fmt.Sprintf(
+		"SELECT count() FROM %s WHERE _partition_id IN (%s) AND NOT (%s)",
+		s.tableName, strings.Join(ids, ", "), r.Where(),
+	)
+	var outside uint64
+	if err := s.targetSQL().QueryRowContext(ctx, query, r.Args()...).Scan(&outside); err != nil {
+		return nil, false, fmt.Errorf("failed to check partition alignment: %w", err)
+	}
+
+	return partitions, outside == 0, nil
+}
+
+// createStagingTable 按目标表结构创建空的暂存表
+// Replicated 引擎去掉 ZooKeeper 路径参数，避免与目标表冲突
+// 同名暂存表已存在时（进程在登记替换状态前中断遗留）先删除，否则其中的旧数据会随替换重复写入目标表
+func (s *UniversalSyncer) createStagingTable(ctx context.Context, stagingTable string) error {
+	exists, err := s.targetTableExists(ctx, stagingTable)
+	if err != nil {
+		return err
+	}
+	if exists {
+		log.Printf("🧹 %s: 删除遗留的暂存表 %s", s.tableName, stagingTable)
+		if _, err := s.targetSQL().ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", stagingTable)); err != nil {
+			return fmt.Errorf("failed to drop stale staging table: %w", err)
+		}
+	}
+
+	var engine, engineFull string
+	query := "SELECT engine, engine_full FROM system.tables WHERE database = currentDatabase() AND name = ?"
+	if err := s.targetSQL().QueryRowContext(ctx, query, s.tableName).Scan(&engine, &engineFull); err != nil {
+		return fmt.Errorf("failed to query target engine: %w", err)
+	}
+
+	query = fmt.Sprintf("CREATE TABLE %s AS %s", stagingTable, s.tableName)
+	if strings.HasPrefix(engine, "Replicated") {
+		query += " ENGINE = " + stripReplicatedEngine(engineFull)
+	}
+
+	if _, err := s.targetSQL().ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create staging table: %w", err)
+	}
+	return nil
+}
+
+// dropStagingTable 删除暂存表（失败只记录日志）
+func (s *UniversalSyncer) dropStagingTable(stagingTable string) {
+	query := fmt.Sprintf("DROP TABLE IF EXISTS %s", stagingTable)
+	if _, err := s.targetSQL().ExecContext(context.Background(), query); err != nil {
+		log.Printf("⚠️  %s: 删除暂存表 %s 失败: %v", s.tableName, stagingTable, err)
+	}
+}
+
+// targetTableExists 检查目标库中表是否存在
+func (s *UniversalSyncer) targetTableExists(ctx context.Context, tableName string) (bool, error) {
+	var count uint64
+	query := "SELECT count() FROM system.tables WHERE database = currentDatabase() AND name = ?"
+	if err := s.targetSQL().QueryRowContext(ctx, query, tableName).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check table %s: %w", tableName, err)
+	}
+	return count > 0, nil
+}
+
+// stripReplicatedEngine 把 ReplicatedXxx('path', 'replica', args...) 转换为 Xxx(args...)
+// 例如: "ReplicatedReplacingMergeTree('/ch/t', '{replica}', ver) ORDER BY id" → "ReplacingMergeTree(ver) ORDER BY id"
+func stripReplicatedEngine(engineFull string) string {
+	engineFull = strings.TrimPrefix(engineFull, "Replicated")
+
+	open := strings.Index(engineFull, "(")
+	space := strings.Index(engineFull, " ")
+	if open < 0 || (space >= 0 && space < open) {
+		return engineFull // 无参数
+	}
+
+	// 找到与引擎参数左括号匹配的右括号（忽略字符串中的括号）
+	depth, inQuote, closeIdx := 0, false, -1
+	for i := open; i < len(engineFull) && closeIdx < 0; i++ {
+		switch c := engineFull[i]; {
+		case c == '\\' && inQuote:
+			i++
+		case c == '\'':
+			inQuote = !inQuote
+		case c == '(' && !inQuote:
+			depth++
+		case c == ')' && !inQuote:
+			depth--
+			if depth == 0 {
+				closeIdx = i
+			}
+		}
+	}
+	if closeIdx < 0 {
+		return engineFull
+	}
+
+	// 按顶层逗号拆分参数，去掉开头的两个字符串参数（ZooKeeper 路径与副本名）
+	args := splitTopLevel(engineFull[open+1 : closeIdx])
+	if len(args) >= 2 && strings.HasPrefix(args[0], "'") && strings.HasPrefix(args[1], "'") {
+		args = args[2:]
+	}
+
+	return engineFull[:open] + "(" + strings.Join(args, ", ") + ")" + engineFull[closeIdx+1:]
+}
+
+// splitTopLevel 按不在括号或字符串内的逗号拆分
+func splitTopLevel(str string) []string {
+	parts := []string{}
+	depth, inQuote, start := 0, false, 0
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; {
+		case c == '\\' && inQuote:
+			i++
+		case c == '\'':
+			inQuote = !inQuote
+		case (c == '(' || c == '[') && !inQuote:
+			depth++
+		case (c == ')' || c == ']') && !inQuote:
+			depth--
+		case c == ',' && depth == 0 && !inQuote:
+			parts = append(parts, strings.TrimSpace(str[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(str[start:]); last != "" {
+		parts = append(parts, last)
+	}
+	return parts
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStripReplicatedEngine(t *testing.T) {
+	tests := []struct {
+		name   string
+		engine string
+		want   string
+	}{
+		{
+			name:   "path and replica",
+			engine: "ReplicatedMergeTree('/clickhouse/tables/{shard}/events', '{replica}') PARTITION BY toYYYYMM(created_at) ORDER BY id",
+			want:   "MergeTree() PARTITION BY toYYYYMM(created_at) ORDER BY id",
+		},
+		{
+			name:   "version column after path and replica",
+			engine: "ReplicatedReplacingMergeTree('/clickhouse/tables/events', 'r1', updated_at) ORDER BY id",
+			want:   "ReplacingMergeTree(updated_at) ORDER BY id",
+		},
+		{
+			name:   "default path without arguments",
+			engine: "ReplicatedMergeTree ORDER BY id",
+			want:   "MergeTree ORDER BY id",
+		},
+		{
+			name:   "empty arguments",
+			engine: "ReplicatedMergeTree() ORDER BY id",
+			want:   "MergeTree() ORDER BY id",
+		},
+		{
+			name:   "quoted comma and parens in path",
+			engine: "ReplicatedMergeTree('/t/a,(b)', 'r\\'1') ORDER BY (id, ts)",
+			want:   "MergeTree() ORDER BY (id, ts)",
+		},
+		{
+			name:   "nested arguments",
+			engine: "ReplicatedSummingMergeTree('/t/sums', '{replica}', (clicks, cost)) ORDER BY id",
+			want:   "SummingMergeTree((clicks, cost)) ORDER BY id",
+		},
+		{
+			name:   "arguments without path",
+			engine: "ReplicatedReplacingMergeTree(updated_at) ORDER BY id",
+			want:   "ReplacingMergeTree(updated_at) ORDER BY id",
+		},
+		{
+			name:   "not replicated",
+			engine: "MergeTree PARTITION BY toDate(ts) ORDER BY (id, ts) SETTINGS index_granularity = 8192",
+			want:   "MergeTree PARTITION BY toDate(ts) ORDER BY (id, ts) SETTINGS index_granularity = 8192",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripReplicatedEngine(tt.engine); got != tt.want {
+				t.Errorf("stripReplicatedEngine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTopLevel(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{" a , b ,c ", []string{"a", "b", "c"}},
+		{"f(a, b), [1, 2], c", []string{"f(a, b)", "[1, 2]", "c"}},
+		{"'a,b', 'c(d', e", []string{"'a,b'", "'c(d'", "e"}},
+		{`'it\'s, here', x`, []string{`'it\'s, here'`, "x"}},
+		{"tuple(a, (b, c)), d", []string{"tuple(a, (b, c))", "d"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitTopLevel(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitTopLevel(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
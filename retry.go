@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// 可重试的 ClickHouse 错误码（网络、超时、副本不可用、分区片段过多等暂时性错误）
+var retryableExceptionCodes = map[int32]string{
+	159: "TIMEOUT_EXCEEDED",
+	202: "TOO_MANY_SIMULTANEOUS_QUERIES",
+	209: "SOCKET_TIMEOUT",
+	210: "NETWORK_ERROR",
+	225: "NO_ZOOKEEPER",
+	242: "TABLE_IS_READ_ONLY",
+	252: "TOO_MANY_PARTS",
+	279: "ALL_CONNECTION_TRIES_FAILED",
+	285: "TOO_FEW_LIVE_REPLICAS",
+	319: "UNKNOWN_STATUS_OF_INSERT",
+	999: "KEEPER_EXCEPTION",
+}
+
+// IsRetryable 判断错误是否为暂时性错误（重试可能成功）
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var final *finalError
+	if errors.As(err, &final) {
+		return false
+	}
+
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		_, ok := retryableExceptionCodes[exception.Code]
+		return ok
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, clickhouse.ErrAcquireConnTimeout),
+		errors.Is(err, ErrAllNodesUnavailable),
+		errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	return false
+}
+
+// finalError 不再重试的错误：内层已经重试过（避免嵌套重试时次数相乘），或重试会导致重复写入
+type finalError struct {
+	err error
+}
+
+func (e *finalError) Error() string { return e.err.Error() }
+func (e *finalError) Unwrap() error { return e.err }
+
+// withRetry 执行 fn，可重试的错误按指数退避（带随机抖动）重试，最多尝试 max_attempts 次
+// 重试次数用尽后返回的错误不会再被外层的 withRetry 重试
+func withRetry(ctx context.Context, config RetryConfig, operation string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= config.MaxAttempts {
+			if attempt > 1 {
+				return &finalError{err: err}
+			}
+			return err
+		}
+
+		delay := retryBackoff(config, attempt)
+		log.Printf("⚠️  %s失败（第 %d/%d 次），%s 后重试: %v",
+			operation, attempt, config.MaxAttempts, FormatDuration(delay), err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// retryBackoff 第 attempt 次失败后的等待时间：在 [0, min(max_backoff, initial_backoff × 2^(attempt-1))] 内随机取值
+func retryBackoff(config RetryConfig, attempt int) time.Duration {
+	backoff := time.Duration(config.InitialBackoff) * time.Millisecond
+	maxBackoff := time.Duration(config.MaxBackoff) * time.Millisecond
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// insertToken 生成批次的 insert_deduplication_token：由写入表、分段、批次序号和批次内容指纹确定
+// 同一批次重试时 token 相同，Replicated 表会丢弃重复写入；内容指纹保证再次同步同一分段时，
+// 内容不同的批次不会因序号相同被误判为重复
+func (s *UniversalSyncer) insertToken(ctx context.Context, segment string, index int, batch *RowBatch) string {
+	token := fmt.Sprintf("ch_sync:%s:%s:%d:%016x", s.destTable(), segment, index, s.deduplicator.BatchFingerprint(batch))
+	if scope, ok := ctx.Value(insertTokenScopeKey{}).(string); ok {
+		token += ":" + scope
+	}
+	return token
+}
+
+// insertTokenScopeKey 写入 token 附加作用域的 context 键
+type insertTokenScopeKey struct{}
+
+// withInsertTokenScope 为之后写入的 token 附加作用域。删除目标库数据后重新复制相同内容时使用，
+// 否则与删除前写入的 token 相同，会被 Replicated 表当作重复写入丢弃
+func withInsertTokenScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, insertTokenScopeKey{}, scope)
+}
+
+// BatchFingerprint 计算批次内容指纹（按行的全部字段，跨进程稳定）
+// 每行按字段类型归一化编码（与去重键相同的编码），非键字段或版本变化的批次得到不同的指纹
+func (d *Deduplicator) BatchFingerprint(batch *RowBatch) uint64 {
+	h := fnv.New64a()
+	var buf []byte
+	for row := 0; row < batch.Len(); row++ {
+		buf = d.rowEncoder.Encode(buf[:0], batch.Row(row))
+		h.Write(buf)
+	}
+	h.Write(binary.AppendUvarint(buf[:0], uint64(batch.Len())))
+	return h.Sum64()
+}
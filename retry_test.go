@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, true},
+		{"too many parts", &clickhouse.Exception{Code: 252}, true},
+		{"wrapped timeout exception", fmt.Errorf("insert: %w", &clickhouse.Exception{Code: 159}), true},
+		{"syntax error", &clickhouse.Exception{Code: 62}, false},
+		{"unknown table", &clickhouse.Exception{Code: 60}, false},
+		{"eof", io.EOF, true},
+		{"unexpected eof", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"connection refused", fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{"acquire timeout", clickhouse.ErrAcquireConnTimeout, true},
+		{"all nodes unavailable", ErrAllNodesUnavailable, true},
+		{"final error", &finalError{err: io.EOF}, false},
+		{"wrapped final error", fmt.Errorf("batch: %w", &finalError{err: syscall.EPIPE}), false},
+		{"plain error", errors.New("column count mismatch"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	config := RetryConfig{MaxAttempts: 10, InitialBackoff: 100, MaxBackoff: 1000}
+	bounds := map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: 1000 * time.Millisecond, // 1600ms 超过上限
+		9: 1000 * time.Millisecond,
+	}
+	for attempt, bound := range bounds {
+		for i := 0; i < 200; i++ {
+			if delay := retryBackoff(config, attempt); delay < 0 || delay > bound {
+				t.Fatalf("retryBackoff(attempt %d) = %s, want within [0, %s]", attempt, delay, bound)
+			}
+		}
+	}
+
+	if delay := retryBackoff(RetryConfig{MaxAttempts: 3}, 2); delay != 0 {
+		t.Errorf("retryBackoff() without backoff = %s, want 0", delay)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	config := RetryConfig{MaxAttempts: 3, InitialBackoff: 1, MaxBackoff: 1}
+	ctx := context.Background()
+
+	calls := 0
+	err := withRetry(ctx, config, "test", func() error {
+		calls++
+		if calls < 3 {
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("withRetry() = %v after %d calls, want success after 3", err, calls)
+	}
+
+	// 不可重试的错误只执行一次
+	calls = 0
+	err = withRetry(ctx, config, "test", func() error {
+		calls++
+		return &clickhouse.Exception{Code: 62}
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("withRetry() = %v after %d calls, want error after 1", err, calls)
+	}
+
+	// 次数用尽后返回的错误不会被外层再次重试
+	outer := 0
+	err = withRetry(ctx, config, "outer", func() error {
+		outer++
+		return withRetry(ctx, config, "inner", func() error { return io.EOF })
+	})
+	if !errors.Is(err, io.EOF) || outer != 1 {
+		t.Fatalf("nested withRetry() = %v after %d outer calls, want io.EOF after 1", err, outer)
+	}
+}
+
+// fingerprintBatch 按行构建批次（含 Array(Nullable(String)) 与版本字段），返回绑定了字段的去重器
+func fingerprintBatch(t *testing.T, rows ...[]interface{}) (*Deduplicator, *RowBatch) {
+	t.Helper()
+	schema := &TableSchema{
+		TableName: "events",
+		Columns: []ColumnInfo{
+			{Name: "id", Type: "UInt64"},
+			{Name: "name", Type: "String"},
+			{Name: "tags", Type: "Array(Nullable(String))"},
+			{Name: "version", Type: "UInt32"},
+		},
+	}
+	columns := schema.GetColumnNames()
+	scanner, err := NewRowScanner(schema, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := NewRowBatch(scanner, len(rows))
+	for _, row := range rows {
+		fake := &fakeRows{columns: columns, row: row, n: 1}
+		fake.Next()
+		if err := scanner.Scan(fake); err != nil {
+			t.Fatal(err)
+		}
+		batch.AppendFrom(scanner)
+	}
+
+	d := NewDeduplicator([]string{"id"}, "", "version", "exact", false)
+	d.BindColumns(schema, columns)
+	return d, batch
+}
+
+func tags(values ...string) []*string {
+	out := make([]*string, len(values))
+	for i := range values {
+		v := values[i] // 每次分配新的指针：内容相同的批次指针地址不同
+		out[i] = &v
+	}
+	return out
+}
+
+func TestBatchFingerprintStable(t *testing.T) {
+	row := func(id uint64, name string, tagValues []*string, version uint32) []interface{} {
+		return []interface{}{id, name, tagValues, version}
+	}
+	fingerprint := func(rows ...[]interface{}) uint64 {
+		d, batch := fingerprintBatch(t, rows...)
+		return d.BatchFingerprint(batch)
+	}
+
+	base := fingerprint(row(1, "a", tags("x", "y"), 1), row(2, "b", nil, 1))
+
+	// 内容相同（指针、进程不同）时指纹相同
+	if got := fingerprint(row(1, "a", tags("x", "y"), 1), row(2, "b", nil, 1)); got != base {
+		t.Errorf("same content: fingerprint %016x, want %016x", got, base)
+	}
+
+	changed := map[string]uint64{
+		"non-key column":  fingerprint(row(1, "changed", tags("x", "y"), 1), row(2, "b", nil, 1)),
+		"array element":   fingerprint(row(1, "a", tags("x", "z"), 1), row(2, "b", nil, 1)),
+		"array NULL":      fingerprint(row(1, "a", []*string{tags("x")[0], nil}, 1), row(2, "b", nil, 1)),
+		"version":         fingerprint(row(1, "a", tags("x", "y"), 2), row(2, "b", nil, 1)),
+		"row order":       fingerprint(row(2, "b", nil, 1), row(1, "a", tags("x", "y"), 1)),
+		"fewer rows":      fingerprint(row(1, "a", tags("x", "y"), 1)),
+		"shifted element": fingerprint(row(1, "a", tags("xy"), 1), row(2, "b", nil, 1)),
+	}
+	for name, got := range changed {
+		if got == base {
+			t.Errorf("%s changed but fingerprint is unchanged", name)
+		}
+	}
+}
+
+func TestInsertTokenScope(t *testing.T) {
+	d, batch := fingerprintBatch(t, []interface{}{uint64(1), "a", tags("x"), uint32(1)})
+	s := &UniversalSyncer{tableName: "events", deduplicator: d}
+	ctx := context.Background()
+
+	token := s.insertToken(ctx, "seg", 3, batch)
+	if again := s.insertToken(ctx, "seg", 3, batch); again != token {
+		t.Errorf("retry token %q, want %q", again, token)
+	}
+	if other := s.insertToken(ctx, "seg", 4, batch); other == token {
+		t.Error("token does not depend on the batch index")
+	}
+	if scoped := s.insertToken(withInsertTokenScope(ctx, "repair-1"), "seg", 3, batch); scoped != token+":repair-1" {
+		t.Errorf("scoped token %q, want %q", scoped, token+":repair-1")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/column"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	timePtrType = reflect.TypeOf((*time.Time)(nil))
+
+	// ClickHouse DateTime 范围: 1900-01-01 到 2262-04-11，超出范围或零值替换为 1970-01-01
+	minValidDateTime = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxValidDateTime = time.Date(2262, 4, 11, 23, 47, 16, 0, time.UTC)
+	defaultDateTime  = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// RowScanner 基于表结构的行扫描器
+// 每个字段的扫描目标按 ClickHouse 类型分配一次具体 Go 类型（UInt64、decimal.Decimal、time.Time、
+// []string、map、Nullable 对应的指针等），在所有行之间复用
+type RowScanner struct {
+	columns   []ColumnInfo
+	scanTypes []reflect.Type
+	dests     []interface{}   // 传给 rows.Scan 的指针
+	values    []reflect.Value // dests 指向的值
+}
+
+// NewRowScanner 根据表结构为指定字段创建行扫描器
+func NewRowScanner(schema *TableSchema, columns []string) (*RowScanner, error) {
+	rs := &RowScanner{
+		columns:   make([]ColumnInfo, len(columns)),
+		scanTypes: make([]reflect.Type, len(columns)),
+		dests:     make([]interface{}, len(columns)),
+		values:    make([]reflect.Value, len(columns)),
+	}
+
+	for i, name := range columns {
+		info := schema.GetColumn(name)
+		if info == nil {
+			return nil, fmt.Errorf("column %s not found in table %s", name, schema.TableName)
+		}
+
+		col, err := column.Type(info.Type).Column(info.Name, time.UTC)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported type %s for column %s: %w", info.Type, info.Name, err)
+		}
+
+		dest := reflect.New(col.ScanType())
+		rs.columns[i] = *info
+		rs.scanTypes[i] = col.ScanType()
+		rs.dests[i] = dest.Interface()
+		rs.values[i] = dest.Elem()
+	}
+
+	return rs, nil
+}
+
+// Scan 扫描当前行到复用的扫描目标
+func (rs *RowScanner) Scan(rows driver.Rows) error {
+	// 指针类型（Nullable）先置空，避免驱动对部分类型的 NULL 不重置目标时残留上一行的值
+	for i, value := range rs.values {
+		if rs.scanTypes[i].Kind() == reflect.Ptr {
+			value.Set(reflect.Zero(rs.scanTypes[i]))
+		}
+	}
+	return rows.Scan(rs.dests...)
+}
+
+// Value 返回第 i 个字段当前行的值
+func (rs *RowScanner) Value(i int) interface{} {
+	return rs.values[i].Interface()
+}
+
+// Len 返回字段数量
+func (rs *RowScanner) Len() int {
+	return len(rs.columns)
+}
+
+// RowBatch 列式行缓冲：每个字段保存为对应 Go 类型的切片，批次之间复用底层数组
+type RowBatch struct {
+	columns   []ColumnInfo
+	scanTypes []reflect.Type
+	data      []reflect.Value
+	rows      int
+	row       []interface{} // Row() 复用的行缓冲
+}
+
+// NewRowBatch 创建与扫描器字段一致的列式行缓冲
+func NewRowBatch(rs *RowScanner, capacity int) *RowBatch {
+	b := &RowBatch{
+		columns:   rs.columns,
+		scanTypes: rs.scanTypes,
+		data:      make([]reflect.Value, len(rs.columns)),
+		row:       make([]interface{}, len(rs.columns)),
+	}
+	for i, scanType := range rs.scanTypes {
+		// 可寻址的切片：追加时原地 SetLen，不像 reflect.Append 那样每次分配新的切片头
+		b.data[i] = reflect.New(reflect.SliceOf(scanType)).Elem()
+		b.data[i].Set(reflect.MakeSlice(reflect.SliceOf(scanType), 0, capacity))
+	}
+	return b
+}
+
+// AppendFrom 追加扫描器当前行的数据
+func (b *RowBatch) AppendFrom(rs *RowScanner) {
+	for i, value := range rs.values {
+		switch b.scanTypes[i] {
+		case timeType:
+			if t := value.Addr().Interface().(*time.Time); !isValidDateTime(*t) {
+				value = reflect.ValueOf(defaultDateTime)
+			}
+		case timePtrType:
+			if t := value.Interface().(*time.Time); t != nil && !isValidDateTime(*t) {
+				fixed := defaultDateTime
+				value = reflect.ValueOf(&fixed)
+			}
+		}
+		column := b.data[i]
+		if column.Len() == column.Cap() {
+			column.Grow(1)
+		}
+		column.SetLen(b.rows + 1)
+		column.Index(b.rows).Set(value)
+	}
+	b.rows++
+}
+
+// isValidDateTime 检查时间是否在 ClickHouse DateTime 有效范围内
+func isValidDateTime(t time.Time) bool {
+	return !t.IsZero() && !t.Before(minValidDateTime) && !t.After(maxValidDateTime)
+}
+
+// Len 返回当前行数
+func (b *RowBatch) Len() int {
+	return b.rows
+}
+
+// Reset 清空缓冲（保留底层数组）
+func (b *RowBatch) Reset() {
+	for i := range b.data {
+		b.data[i].SetLen(0)
+	}
+	b.rows = 0
+}
+
+// Row 返回第 i 行的值（返回的切片在下次调用时复用）
+func (b *RowBatch) Row(i int) []interface{} {
+	for j := range b.data {
+		b.row[j] = b.data[j].Index(i).Interface()
+	}
+	return b.row
+}
+
+// AppendTo 将整个批次按列追加到原生 Batch
+func (b *RowBatch) AppendTo(batch driver.Batch) error {
+	for i := range b.data {
+		if err := batch.Column(i).Append(b.data[i].Interface()); err != nil {
+			return fmt.Errorf("column %s (%s): %w", b.columns[i].Name, b.columns[i].Type, err)
+		}
+	}
+	return nil
+}
+
+// derefValue 解引用指针值（Nullable 字段扫描结果为指针），空指针返回 nil
+func derefValue(val interface{}) interface{} {
+	if val == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Ptr {
+		return val
+	}
+	if rv.IsNil() {
+		return nil
+	}
+	return rv.Elem().Interface()
+}
+
+// Value 返回第 row 行第 col 个字段的值
+func (b *RowBatch) Value(col, row int) interface{} {
+	return b.data[col].Index(row).Interface()
+}
+
+// Column 返回第 i 个字段的整列数据（对应 Go 类型的切片）
+func (b *RowBatch) Column(i int) interface{} {
+	return b.data[i].Interface()
+}
+
+// Filter 原地保留 keep[i] 为 true 的行
+func (b *RowBatch) Filter(keep []bool) {
+	kept := 0
+	for row := 0; row < b.rows; row++ {
+		if !keep[row] {
+			continue
+		}
+		if kept != row {
+			for i := range b.data {
+				b.data[i].Index(kept).Set(b.data[i].Index(row))
+			}
+		}
+		kept++
+	}
+	for i := range b.data {
+		b.data[i].SetLen(kept)
+	}
+	b.rows = kept
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/shopspring/decimal"
+)
+
+// fakeRows 按固定的一行数据重复返回 n 行的 driver.Rows（扫描目标按驱动的方式直接赋值）
+type fakeRows struct {
+	driver.Rows
+	columns []string
+	row     []interface{}
+	n       int
+}
+
+func (r *fakeRows) Next() bool {
+	if r.n == 0 {
+		return false
+	}
+	r.n--
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	for i, d := range dest {
+		target := reflect.ValueOf(d).Elem()
+		if r.row[i] == nil {
+			target.Set(reflect.Zero(target.Type()))
+			continue
+		}
+		target.Set(reflect.ValueOf(r.row[i]))
+	}
+	return nil
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Err() error        { return nil }
+func (r *fakeRows) Close() error      { return nil }
+
+// benchmarkSchema 基准测试使用的表结构与一行数据
+func benchmarkSchema() (*TableSchema, []string, []interface{}) {
+	schema := &TableSchema{
+		TableName: "events",
+		Columns: []ColumnInfo{
+			{Name: "id", Type: "UInt64"},
+			{Name: "name", Type: "String"},
+			{Name: "note", Type: "Nullable(String)"},
+			{Name: "amount", Type: "Decimal(18, 2)"},
+			{Name: "score", Type: "Float64"},
+			{Name: "created_at", Type: "DateTime64(3)"},
+		},
+	}
+	note := "note"
+	row := []interface{}{
+		uint64(42),
+		"name",
+		&note,
+		decimal.RequireFromString("12.34"),
+		float64(0.5),
+		time.Date(2024, 3, 1, 12, 0, 0, 123000000, time.UTC),
+	}
+	columns := make([]string, len(schema.Columns))
+	for i, col := range schema.Columns {
+		columns[i] = col.Name
+	}
+	return schema, columns, row
+}
+
+const benchmarkBatchSize = 1000
+
+// BenchmarkRowScanner 强类型扫描目标跨行复用，按列追加到复用的列式缓冲
+func BenchmarkRowScanner(b *testing.B) {
+	schema, columns, row := benchmarkSchema()
+	scanner, err := NewRowScanner(schema, columns)
+	if err != nil {
+		b.Fatal(err)
+	}
+	batch := NewRowBatch(scanner, benchmarkBatchSize)
+	rows := &fakeRows{columns: columns, row: row, n: b.N}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for rows.Next() {
+		if err := scanner.Scan(rows); err != nil {
+			b.Fatal(err)
+		}
+		batch.AppendFrom(scanner)
+		if batch.Len() >= benchmarkBatchSize {
+			batch.Reset()
+		}
+	}
+}
+
+// scanRowMap 原来的逐行扫描方式：每行分配 []interface{} 扫描目标并转换为 map
+func scanRowMap(rows driver.Rows, columns []string) (map[string]interface{}, error) {
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	if err := rows.Scan(valuePtrs...); err != nil {
+		return nil, err
+	}
+
+	record := make(map[string]interface{})
+	for i, col := range columns {
+		record[col] = values[i]
+	}
+	return record, nil
+}
+
+// BenchmarkRowMapScan 原来的 map 行缓冲（对照 BenchmarkRowScanner）
+func BenchmarkRowMapScan(b *testing.B) {
+	_, columns, row := benchmarkSchema()
+	batch := make([]map[string]interface{}, 0, benchmarkBatchSize)
+	rows := &fakeRows{columns: columns, row: row, n: b.N}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for rows.Next() {
+		record, err := scanRowMap(rows, columns)
+		if err != nil {
+			b.Fatal(err)
+		}
+		batch = append(batch, record)
+		if len(batch) >= benchmarkBatchSize {
+			batch = batch[:0]
+		}
+	}
+}
+
+func TestRowBatchAppendFilterReset(t *testing.T) {
+	schema, columns, row := benchmarkSchema()
+	scanner, err := NewRowScanner(schema, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 容量小于行数：追加时扩容
+	batch := NewRowBatch(scanner, 2)
+
+	for i := 0; i < 5; i++ {
+		values := append([]interface{}(nil), row...)
+		values[0] = uint64(i)
+		if i == 3 {
+			values[2] = nil
+			values[5] = time.Time{} // 无效时间替换为 1970-01-01
+		}
+		rows := &fakeRows{columns: columns, row: values, n: 1}
+		rows.Next()
+		if err := scanner.Scan(rows); err != nil {
+			t.Fatal(err)
+		}
+		batch.AppendFrom(scanner)
+	}
+
+	if got := batch.Column(0).([]uint64); !reflect.DeepEqual(got, []uint64{0, 1, 2, 3, 4}) {
+		t.Fatalf("ids = %v", got)
+	}
+	if note := batch.Value(2, 3).(*string); note != nil {
+		t.Errorf("row 3 note = %q, want NULL", *note)
+	}
+	if created := batch.Value(5, 3).(time.Time); !created.Equal(defaultDateTime) {
+		t.Errorf("row 3 created_at = %v, want %v", created, defaultDateTime)
+	}
+
+	batch.Filter([]bool{false, true, false, true, true})
+	if got := batch.Column(0).([]uint64); !reflect.DeepEqual(got, []uint64{1, 3, 4}) || batch.Len() != 3 {
+		t.Fatalf("after filter ids = %v, len = %d", got, batch.Len())
+	}
+	if got := batch.Row(1); got[0] != uint64(3) || got[1] != "name" {
+		t.Errorf("row 1 = %v", got)
+	}
+
+	batch.Reset()
+	if batch.Len() != 0 || len(batch.Column(0).([]uint64)) != 0 {
+		t.Fatalf("reset left %d rows", batch.Len())
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPattern 配置文件中的环境变量引用：${NAME} 或 ${NAME:-默认值}，$${NAME} 表示字面量 ${NAME}
+var envPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv 替换 YAML 文档中所有标量（键和值）里的环境变量引用，未设置且没有默认值的变量视为错误
+func expandEnv(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		value, err := expandEnvString(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value != node.Value {
+			node.Value = value
+			// 未加引号的标量按替换后的值重新推断类型（如 batch_size: ${BATCH_SIZE} 为整数）
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+		return nil
+	}
+	for _, child := range node.Content {
+		if err := expandEnv(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandEnvString 替换字符串中的环境变量引用
+func expandEnvString(text string) (string, error) {
+	var missing []string
+	expanded := envPattern.ReplaceAllStringFunc(text, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		match := envPattern.FindStringSubmatch(ref)
+		if value, ok := os.LookupEnv(match[1]); ok {
+			return value
+		}
+		if strings.Contains(ref, ":-") {
+			return match[2]
+		}
+		missing = append(missing, match[1])
+		return ref
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// SecretProvider 密钥（如数据库密码）的来源。新的来源（如其他密钥管理工具）实现该接口，
+// 并在 DatabaseConfig.passwordProvider 中按对应的配置项返回
+type SecretProvider interface {
+	// Resolve 获取密钥的值
+	Resolve(ctx context.Context) (string, error)
+	// Describe 密钥来源的描述（用于预览和错误信息，不包含密钥本身）
+	Describe() string
+}
+
+// fileSecretProvider 从文件读取密钥（如 Docker / Kubernetes 挂载的 secret），去掉末尾的换行
+type fileSecretProvider struct {
+	path string
+}
+
+func (p fileSecretProvider) Resolve(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password_file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func (p fileSecretProvider) Describe() string {
+	return "password_file " + p.path
+}
+
+// commandSecretProvider 执行命令（sh -c）获取密钥，取标准输出并去掉末尾的换行
+type commandSecretProvider struct {
+	command string
+	timeout time.Duration
+}
+
+func (p commandSecretProvider) Resolve(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", p.command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("password_command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+func (p commandSecretProvider) Describe() string {
+	return "password_command"
+}
+
+// passwordCommandTimeout password_command 的最长执行时间
+const passwordCommandTimeout = 30 * time.Second
+
+// passwordProvider 返回配置的密码来源（password_file 或 password_command），直接配置 password 时返回 nil
+func (d DatabaseConfig) passwordProvider() (SecretProvider, error) {
+	sources := 0
+	for _, set := range []bool{d.Password != "", d.PasswordFile != "", d.PasswordCommand != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("only one of password, password_file and password_command can be set")
+	}
+
+	switch {
+	case d.PasswordFile != "":
+		return fileSecretProvider{path: d.PasswordFile}, nil
+	case d.PasswordCommand != "":
+		return commandSecretProvider{command: d.PasswordCommand, timeout: passwordCommandTimeout}, nil
+	}
+	return nil, nil
+}
+
+// resolveSecrets 从配置的来源获取密码，并登记密码与 HTTP 请求头的值，日志输出时替换为 ******
+func (d *DatabaseConfig) resolveSecrets() error {
+	provider, err := d.passwordProvider()
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		password, err := provider.Resolve(context.Background())
+		if err != nil {
+			return err
+		}
+		if password == "" {
+			return fmt.Errorf("%s returned an empty password", provider.Describe())
+		}
+		d.Password = password
+	}
+
+	// 密码无论长短都登记；过短的密码会连同日志中相同的普通文本一起被替换
+	if d.Password != "" && len(d.Password) < minSecretLength {
+		log.Printf("⚠️  %s 的密码少于 %d 个字符，日志中与其相同的文本都会替换为 %s", strings.Join(d.Addr, ","), minSecretLength, redactedText)
+	}
+	registerSecret(d.Password)
+	// 引号转义后的形式（remote() 表函数的参数）
+	registerSecret(strings.Trim(quoteString(d.Password), "'"))
+	for _, value := range d.HTTP.Headers {
+		RegisterSecret(value)
+	}
+	return nil
+}
+
+// describeCredentials 认证信息的描述（密码只显示是否配置及来源，加载配置后 Password 已是获取到的密码）
+func (d DatabaseConfig) describeCredentials() string {
+	switch {
+	case d.PasswordFile != "":
+		return fmt.Sprintf("%s（密码: %s，来自 %s）", d.Username, redactedText, fileSecretProvider{path: d.PasswordFile}.Describe())
+	case d.PasswordCommand != "":
+		return fmt.Sprintf("%s（密码: %s，来自 %s）", d.Username, redactedText, commandSecretProvider{command: d.PasswordCommand}.Describe())
+	case d.Password != "":
+		return fmt.Sprintf("%s（密码: %s）", d.Username, redactedText)
+	}
+	return fmt.Sprintf("%s（无密码）", d.Username)
+}
+
+// redactedText 替换密钥的文本
+const redactedText = "******"
+
+// minSecretLength 经 RegisterSecret 登记的密钥最短长度：过短的值（如请求头中的普通取值）在日志中替换会破坏普通文本。
+// 数据库密码不受此限制
+const minSecretLength = 4
+
+// secretRegistry 已知的密钥值
+var secretRegistry struct {
+	mu       sync.RWMutex
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}
+
+// RegisterSecret 登记密钥值，之后经 RedactSecrets 处理的文本（包括所有日志）中该值替换为 ******
+// 短于 minSecretLength 的值不登记
+func RegisterSecret(value string) {
+	if len(value) < minSecretLength {
+		return
+	}
+	registerSecret(value)
+}
+
+// registerSecret 登记任意非空的密钥值
+func registerSecret(value string) {
+	if value == "" {
+		return
+	}
+
+	secretRegistry.mu.Lock()
+	defer secretRegistry.mu.Unlock()
+
+	if secretRegistry.values == nil {
+		secretRegistry.values = make(map[string]struct{})
+	}
+	secretRegistry.values[value] = struct{}{}
+
+	// 较长的值优先替换（一个密钥包含另一个密钥时不会只替换一部分）
+	values := make([]string, 0, len(secretRegistry.values))
+	for v := range secretRegistry.values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, redactedText)
+	}
+	secretRegistry.replacer = strings.NewReplacer(pairs...)
+}
+
+// RedactSecrets 把文本中已登记的密钥替换为 ******
+func RedactSecrets(text string) string {
+	secretRegistry.mu.RLock()
+	replacer := secretRegistry.replacer
+	secretRegistry.mu.RUnlock()
+
+	if replacer == nil {
+		return text
+	}
+	return replacer.Replace(text)
+}
+
+// redactingWriter 写入前替换已登记的密钥（用作日志输出，错误信息和 SQL 中的密码不会出现在日志中）
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, RedactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
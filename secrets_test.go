@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("CH_HOST", "replica-1")
+	t.Setenv("BATCH_SIZE", "500")
+	t.Setenv("KEY_NAME", "dynamic_key")
+	t.Setenv("CH_PASSWORD", `p"a: ss'#`)
+
+	document := `
+addr: ["${CH_HOST}:9000"]
+batch_size: ${BATCH_SIZE}
+quoted: "${BATCH_SIZE}"
+literal: "$${CH_HOST}"
+fallback: ${CH_UNSET:-default value}
+empty_default: ${CH_UNSET:-}
+set_with_default: ${CH_HOST:-unused}
+${KEY_NAME}: x
+password: ${CH_PASSWORD}
+`
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(document), &node); err != nil {
+		t.Fatal(err)
+	}
+	if err := expandEnv(&node); err != nil {
+		t.Fatalf("expandEnv() = %v", err)
+	}
+	var got map[string]interface{}
+	if err := node.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"batch_size":       500, // 未加引号：按替换后的值推断为整数
+		"quoted":           "500",
+		"literal":          "${CH_HOST}",
+		"fallback":         "default value",
+		"empty_default":    nil, // 未加引号的空值为 null（字符串字段解码为空字符串）
+		"set_with_default": "replica-1",
+		"dynamic_key":      "x",
+		"password":         `p"a: ss'#`, // 值中的引号、冒号不影响配置结构
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %#v, want %#v", key, got[key], value)
+		}
+	}
+	if addr, ok := got["addr"].([]interface{}); !ok || len(addr) != 1 || addr[0] != "replica-1:9000" {
+		t.Errorf("addr = %#v, want [replica-1:9000]", got["addr"])
+	}
+}
+
+func TestExpandEnvMissingVariable(t *testing.T) {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte("source:\n  password: ${CH_MISSING_PASSWORD}\n"), &node); err != nil {
+		t.Fatal(err)
+	}
+	err := expandEnv(&node)
+	if err == nil {
+		t.Fatal("expandEnv() = nil, want error for unset variable")
+	}
+	if !strings.Contains(err.Error(), "CH_MISSING_PASSWORD") || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expandEnv() = %v, want variable name and line", err)
+	}
+
+	// 转义的引用不要求变量存在
+	if got, err := expandEnvString("$${CH_MISSING_PASSWORD}"); err != nil || got != "${CH_MISSING_PASSWORD}" {
+		t.Errorf("expandEnvString() = %q, %v; want literal reference", got, err)
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	RegisterSecret("hdr-token-1234")
+	RegisterSecret("hdr-token-1234-extended")
+	RegisterSecret("k9z") // 过短的请求头取值不登记
+
+	var out bytes.Buffer
+	w := redactingWriter{w: &out}
+	line := "auth hdr-token-1234 then hdr-token-1234-extended, k9z\n"
+	n, err := w.Write([]byte(line))
+	if err != nil || n != len(line) {
+		t.Fatalf("Write() = %d, %v; want %d, nil", n, err, len(line))
+	}
+	// 较长的密钥整体替换，不会只替换其中包含的较短密钥
+	if want := "auth ****** then ******, k9z\n"; out.String() != want {
+		t.Errorf("redacted = %q, want %q", out.String(), want)
+	}
+}
+
+func TestResolveSecretsRedactsPassword(t *testing.T) {
+	for _, password := range []string{"q7", `it's-a-secret`} {
+		config := DatabaseConfig{Addr: []string{"localhost:9000"}, Password: password}
+		if err := config.resolveSecrets(); err != nil {
+			t.Fatal(err)
+		}
+
+		// 短密码同样替换；remote() 参数中引号转义后的形式也替换
+		text := "password=" + password + " remote(" + quoteString(password) + ")"
+		if got := RedactSecrets(text); strings.Contains(got, strings.Trim(quoteString(password), "'")) || strings.Contains(got, password) {
+			t.Errorf("RedactSecrets(%q) = %q, password not redacted", text, got)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// densityBucket 源库按小时统计的行数
+type densityBucket struct {
+	Start time.Time
+	Rows  uint64
+}
+
+// adaptiveSegments 按源库行数密度分段：热点日拆分为若干小时分段，稀疏的连续日合并为一个分段，
+// 每个分段的行数尽量接近 segment_target_rows
+// 分段边界保存在状态文件的 segment_plan 中，断点续传时沿用已保存的边界，只为新增的时间范围规划分段
+func (s *UniversalSyncer) adaptiveSegments(ctx context.Context, timeRange TimeRange) ([]TimeSegment, error) {
+	target := uint64(s.tableConfig.GetEffectiveSegmentTargetRows(s.config.Sync.SegmentTargetRows))
+	start, end := timeRange.Start.In(s.location), timeRange.End.In(s.location)
+
+	// 1. 读取已保存的分段计划（目标行数变化或新范围早于计划起点时重新规划）
+	var boundaries []time.Time
+	if plan, ok := s.state.GetSegmentPlan(s.tableName); ok && plan.TargetRows == int(target) &&
+		len(plan.Boundaries) > 0 && !start.Before(plan.Boundaries[0]) {
+		for _, boundary := range plan.Boundaries {
+			boundaries = append(boundaries, boundary.In(s.location))
+		}
+	}
+
+	// 2. 为计划未覆盖的范围查询行数密度并规划分段
+	planStart := start
+	if len(boundaries) > 0 {
+		planStart = boundaries[len(boundaries)-1]
+	}
+	if planStart.Before(end) {
+		buckets, err := s.queryDensity(ctx, TimeSegment{Start: planStart, End: end})
+		if err != nil {
+			return nil, err
+		}
+
+		planned := packSegments(planStart, end, buckets, target, s.location)
+		if len(boundaries) > 0 {
+			planned = planned[1:] // 与已有计划的最后一个边界相同
+		}
+		boundaries = append(boundaries, planned...)
+
+		s.state.SaveSegmentPlan(s.tableName, SegmentPlan{
+			TargetRows: int(target),
+			Boundaries: boundaries,
+			UpdatedAt:  time.Now(),
+		})
+		log.Printf("🧮 %s: 按行数密度规划分段（目标每段 %s 行），计划共 %d 个分段",
+			s.tableName, FormatNumber(int(target)), len(boundaries)-1)
+	}
+
+	// 3. 按计划边界切分本次范围
+	segments := []TimeSegment{}
+	current := start
+	for _, boundary := range boundaries {
+		if !boundary.After(current) {
+			continue
+		}
+		if !boundary.Before(end) {
+			break
+		}
+		segments = append(segments, TimeSegment{Start: current, End: boundary})
+		current = boundary
+	}
+	if current.Before(end) {
+		segments = append(segments, TimeSegment{Start: current, End: end})
+	}
+	return segments, nil
+}
+
+// queryDensity 查询源库分段内每小时的行数（按表的时区取整点）
+func (s *UniversalSyncer) queryDensity(ctx context.Context, segment TimeSegment) ([]densityBucket, error) {
+	r := TimeSegmentRange(s.tableConfig.TimeField, segment)
+	query := fmt.Sprintf(
+		"SELECT toStartOfHour(%s, %s) AS hour, count() FROM %s WHERE %s GROUP BY hour ORDER BY hour",
+		r.Field, quoteString(s.location.String()), s.tableName, r.Where(),
+	)
+
+	rows, err := s.sourceDB.QueryContext(ctx, query, r.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query row density: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := []densityBucket{}
+	for rows.Next() {
+		var bucket densityBucket
+		if err := rows.Scan(&bucket.Start, &bucket.Rows); err != nil {
+			return nil, fmt.Errorf("failed to scan row density: %w", err)
+		}
+		bucket.Start = bucket.Start.In(s.location)
+		buckets = append(buckets, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating row density: %w", err)
+	}
+	return buckets, nil
+}
+
+// packSegments 把按小时统计的行数打包为分段边界（含首尾）
+// 行数不超过 target 的日按天累积，超过时在日边界切分；单日超过 target 时按小时累积切分
+func packSegments(start, end time.Time, buckets []densityBucket, target uint64, loc *time.Location) []time.Time {
+	boundaries := []time.Time{start}
+	cut := func(t time.Time) {
+		if t.After(boundaries[len(boundaries)-1]) && t.Before(end) {
+			boundaries = append(boundaries, t)
+		}
+	}
+
+	var acc uint64
+	i := 0
+	for dayStart := start; dayStart.Before(end); {
+		dayEnd := startOfNextDay(dayStart, loc)
+		if dayEnd.After(end) {
+			dayEnd = end
+		}
+
+		// 该日的小时行数
+		j := i
+		var dayRows uint64
+		for j < len(buckets) && buckets[j].Start.Before(dayEnd) {
+			dayRows += buckets[j].Rows
+			j++
+		}
+		hours := buckets[i:j]
+		i = j
+
+		if dayRows > target {
+			// 热点日：结束之前累积的分段，按小时切分
+			cut(dayStart)
+			acc = 0
+			for _, hour := range hours {
+				if acc > 0 && acc+hour.Rows > target {
+					cut(hour.Start)
+					acc = 0
+				}
+				acc += hour.Rows
+			}
+			cut(dayEnd)
+			acc = 0
+		} else {
+			// 普通日：与之前的稀疏日合并，超过目标行数时在日边界切分
+			if acc > 0 && acc+dayRows > target {
+				cut(dayStart)
+				acc = 0
+			}
+			acc += dayRows
+		}
+
+		dayStart = dayEnd
+	}
+
+	return append(boundaries, end)
+}
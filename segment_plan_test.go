@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 日边界因夏令时不存在时（America/Santiago 2024-09-08 00:00）分段照常推进，并在切换时刻切分
+func TestPackSegmentsNonexistentMidnight(t *testing.T) {
+	santiago := mustLoadLocation(t, "America/Santiago")
+	start := time.Date(2024, 9, 7, 0, 0, 0, 0, santiago)
+	end := time.Date(2024, 9, 9, 0, 0, 0, 0, santiago)
+
+	buckets := []densityBucket{}
+	for hour := start; hour.Before(end); hour = hour.Add(time.Hour) {
+		buckets = append(buckets, densityBucket{Start: hour, Rows: 10})
+	}
+
+	// 每天约 240 行，目标 300 行：两天不能合并，在日边界切分
+	boundaries := packSegments(start, end, buckets, 300, santiago)
+	want := []string{"2024-09-07 00:00 -04", "2024-09-08 01:00 -03", "2024-09-09 00:00 -03"}
+	if len(boundaries) != len(want) {
+		t.Fatalf("got %d boundaries %v, want %v", len(boundaries), boundaries, want)
+	}
+	for i, boundary := range boundaries {
+		if got := boundary.In(santiago).Format("2006-01-02 15:04 MST"); got != want[i] {
+			t.Errorf("boundary %d = %s, want %s", i, got, want[i])
+		}
+	}
+}
@@ -25,10 +25,64 @@ type SyncState struct {
 
 // TableState 表状态
 type TableState struct {
-	Status            string         `json:"status"` // "pending", "in_progress", "completed"
-	LastSyncedTime    time.Time      `json:"last_synced_time"`
-	RecordsSynced     int            `json:"records_synced"`
-	CompletedSegments []TimeSegment  `json:"completed_segments"`
+	Status            string              `json:"status"` // "pending", "in_progress", "completed", "failed"
+	LastSyncedTime    time.Time           `json:"last_synced_time"`
+	RecordsSynced     int                 `json:"records_synced"`
+	CompletedSegments []TimeSegment       `json:"completed_segments"`
+	PendingSegments   []TimeSegment       `json:"pending_segments,omitempty"` // 并行同步中已计划但尚未完成的分段（完成顺序不固定，决定续传起点）
+	Validations       []SegmentValidation `json:"validations,omitempty"`      // 每个分段最近一次验证结果
+	LastValidation    *SegmentValidation  `json:"last_validation,omitempty"`
+	Repairs           []RepairRecord      `json:"repairs,omitempty"`      // 分段修复记录
+	Replaces          []ReplaceRecord     `json:"replaces,omitempty"`     // 未完成的分段替换
+	Partitions        []PartitionRecord   `json:"partitions,omitempty"`   // 分区分段模式下已完成的分区
+	SegmentPlan       *SegmentPlan        `json:"segment_plan,omitempty"` // adaptive 分段方式下规划的分段边界
+	LastCursor        *uint64             `json:"last_cursor,omitempty"`  // 游标模式下最后同步的 ID
+	LastSweep         *SweepRecord        `json:"last_sweep,omitempty"`   // 最近一次延迟数据回扫
+	LateRowsRecovered int                 `json:"late_rows_recovered"`    // 历次回扫补齐的延迟数据总数
+	Sweeps            int                 `json:"sweeps"`                 // 回扫次数
+}
+
+// SweepRecord 延迟数据回扫记录
+type SweepRecord struct {
+	Range      TimeSegment `json:"range"`
+	Recovered  int         `json:"recovered"` // 本次回扫补齐的记录数
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+}
+
+// SegmentPlan 按行数密度规划的分段边界（断点续传时沿用，保证分段与上次运行一致）
+type SegmentPlan struct {
+	TargetRows int         `json:"target_rows"`
+	Boundaries []time.Time `json:"boundaries"` // 升序，相邻两个边界构成一个分段
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// PartitionRecord 已完成的分区（源库分区行数变化后重新同步）
+type PartitionRecord struct {
+	ID            string    `json:"id"`
+	Rows          uint64    `json:"rows"`           // 完成时源库 system.parts 中的行数
+	RecordsSynced int       `json:"records_synced"` // 本次写入的记录数
+	CompletedAt   time.Time `json:"completed_at"`
+}
+
+// ReplaceRecord 分段替换记录（替换完成后删除）
+type ReplaceRecord struct {
+	Segment       TimeSegment `json:"segment"`
+	Partition     string      `json:"partition,omitempty"` // 分区分段模式下替换的分区 ID（此时 Segment 为空）
+	StagingTable  string      `json:"staging_table"`
+	Status        string      `json:"status"` // "staging"（写入暂存表）, "staged"（暂存完成）, "swapping"（替换目标库中）
+	RecordsStaged int         `json:"records_staged"`
+	StartedAt     time.Time   `json:"started_at"`
+}
+
+// RepairRecord 分段修复记录
+type RepairRecord struct {
+	Segment         TimeSegment   `json:"segment"`
+	Status          string        `json:"status"` // "in_progress", "completed"
+	RepairedSlices  []TimeSegment `json:"repaired_slices"`
+	RecordsRepaired int           `json:"records_repaired"`
+	StartedAt       time.Time     `json:"started_at"`
+	FinishedAt      time.Time     `json:"finished_at,omitempty"`
 }
 
 // TimeSegment 时间分段
@@ -136,6 +190,12 @@ func (sm *StateManager) MarkSegmentCompleted(tableName string, segment TimeSegme
 
 	tableState := sm.state.Tables[tableName]
 	tableState.CompletedSegments = append(tableState.CompletedSegments, segment)
+	for i, pending := range tableState.PendingSegments {
+		if pending.Start.Equal(segment.Start) && pending.End.Equal(segment.End) {
+			tableState.PendingSegments = append(tableState.PendingSegments[:i], tableState.PendingSegments[i+1:]...)
+			break
+		}
+	}
 	tableState.RecordsSynced += recordCount
 	tableState.LastSyncedTime = time.Now()
 
@@ -143,6 +203,228 @@ func (sm *StateManager) MarkSegmentCompleted(tableName string, segment TimeSegme
 	sm.saveStateUnlocked()
 }
 
+// StartSegments 记录并行同步即将处理的分段（已完成的分段除外）
+// 并行分段的完成顺序不固定，较晚的分段可能先写入目标库；在这些分段全部完成前，
+// 续传起点以最早未完成的分段为准，而不是目标库的最大时间
+func (sm *StateManager) StartSegments(tableName string, segments []TimeSegment) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.state.Tables[tableName]; !exists {
+		sm.state.Tables[tableName] = &TableState{
+			Status:            "in_progress",
+			CompletedSegments: []TimeSegment{},
+		}
+	}
+
+	tableState := sm.state.Tables[tableName]
+	pending := []TimeSegment{}
+	for _, segment := range segments {
+		completed := false
+		for _, done := range tableState.CompletedSegments {
+			if done.Start.Equal(segment.Start) && done.End.Equal(segment.End) {
+				completed = true
+				break
+			}
+		}
+		if !completed {
+			pending = append(pending, segment)
+		}
+	}
+	tableState.PendingSegments = pending
+
+	sm.saveStateUnlocked()
+}
+
+// GetEarliestPendingSegment 获取并行同步中最早的未完成分段
+func (sm *StateManager) GetEarliestPendingSegment(tableName string) (TimeSegment, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists || len(tableState.PendingSegments) == 0 {
+		return TimeSegment{}, false
+	}
+
+	earliest := tableState.PendingSegments[0]
+	for _, segment := range tableState.PendingSegments[1:] {
+		if segment.Start.Before(earliest.Start) {
+			earliest = segment
+		}
+	}
+	return earliest, true
+}
+
+// GetSegmentPlan 获取已保存的分段计划
+func (sm *StateManager) GetSegmentPlan(tableName string) (SegmentPlan, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists || tableState.SegmentPlan == nil {
+		return SegmentPlan{}, false
+	}
+	plan := *tableState.SegmentPlan
+	plan.Boundaries = append([]time.Time(nil), plan.Boundaries...)
+	return plan, true
+}
+
+// SaveSegmentPlan 保存分段计划
+func (sm *StateManager) SaveSegmentPlan(tableName string, plan SegmentPlan) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.state.Tables[tableName]; !exists {
+		sm.state.Tables[tableName] = &TableState{
+			Status:            "in_progress",
+			CompletedSegments: []TimeSegment{},
+		}
+	}
+
+	sm.state.Tables[tableName].SegmentPlan = &plan
+	sm.saveStateUnlocked()
+}
+
+// IsPartitionCompleted 检查分区是否已完成且源库行数没有变化
+func (sm *StateManager) IsPartitionCompleted(tableName, partitionID string, rows uint64) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists {
+		return false
+	}
+
+	for _, partition := range tableState.Partitions {
+		if partition.ID == partitionID {
+			return partition.Rows == rows
+		}
+	}
+	return false
+}
+
+// MarkPartitionCompleted 标记分区已完成（记录完成时的源库行数）
+func (sm *StateManager) MarkPartitionCompleted(tableName string, partition Partition, recordCount int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.state.Tables[tableName]; !exists {
+		sm.state.Tables[tableName] = &TableState{
+			Status:            "in_progress",
+			CompletedSegments: []TimeSegment{},
+		}
+	}
+
+	tableState := sm.state.Tables[tableName]
+	record := PartitionRecord{
+		ID:            partition.ID,
+		Rows:          partition.Rows,
+		RecordsSynced: recordCount,
+		CompletedAt:   time.Now(),
+	}
+
+	found := false
+	for i := range tableState.Partitions {
+		if tableState.Partitions[i].ID == partition.ID {
+			tableState.Partitions[i] = record
+			found = true
+			break
+		}
+	}
+	if !found {
+		tableState.Partitions = append(tableState.Partitions, record)
+	}
+	tableState.RecordsSynced += recordCount
+	tableState.LastSyncedTime = time.Now()
+
+	sm.saveStateUnlocked()
+}
+
+// ResetPartition 移除分区的完成记录（验证未通过时使用，下次同步时重新同步该分区）
+func (sm *StateManager) ResetPartition(tableName, partitionID string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists {
+		return
+	}
+
+	for i, partition := range tableState.Partitions {
+		if partition.ID == partitionID {
+			tableState.Partitions = append(tableState.Partitions[:i], tableState.Partitions[i+1:]...)
+			break
+		}
+	}
+
+	sm.saveStateUnlocked()
+}
+
+// GetLastCursor 获取游标模式下最后同步的 ID
+func (sm *StateManager) GetLastCursor(tableName string) (uint64, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists || tableState.LastCursor == nil {
+		return 0, false
+	}
+	return *tableState.LastCursor, true
+}
+
+// MarkCursorSegmentCompleted 标记 ID 分段已完成，游标推进到分段末尾
+func (sm *StateManager) MarkCursorSegmentCompleted(tableName string, segment IDSegment, recordCount int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.state.Tables[tableName]; !exists {
+		sm.state.Tables[tableName] = &TableState{
+			Status:            "in_progress",
+			CompletedSegments: []TimeSegment{},
+		}
+	}
+
+	tableState := sm.state.Tables[tableName]
+	last := segment.End - 1
+	tableState.LastCursor = &last
+	tableState.RecordsSynced += recordCount
+	tableState.LastSyncedTime = time.Now()
+
+	sm.saveStateUnlocked()
+}
+
+// GetLastSweep 获取最近一次回扫记录
+func (sm *StateManager) GetLastSweep(tableName string) (SweepRecord, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists || tableState.LastSweep == nil {
+		return SweepRecord{}, false
+	}
+	return *tableState.LastSweep, true
+}
+
+// RecordSweep 记录一次回扫结果并累计补齐的延迟数据
+func (sm *StateManager) RecordSweep(tableName string, record SweepRecord) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.state.Tables[tableName]; !exists {
+		sm.state.Tables[tableName] = &TableState{
+			CompletedSegments: []TimeSegment{},
+		}
+	}
+
+	tableState := sm.state.Tables[tableName]
+	tableState.LastSweep = &record
+	tableState.LateRowsRecovered += record.Recovered
+	tableState.RecordsSynced += record.Recovered
+	tableState.Sweeps++
+
+	sm.saveStateUnlocked()
+}
+
 // MarkTableCompleted 标记表同步完成
 func (sm *StateManager) MarkTableCompleted(tableName string) {
 	sm.mu.Lock()
@@ -173,6 +455,272 @@ func (sm *StateManager) MarkTableInProgress(tableName string) {
 	sm.saveStateUnlocked()
 }
 
+// MarkTableFailed 标记表同步失败（例如验证未通过）
+func (sm *StateManager) MarkTableFailed(tableName string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.state.Tables[tableName]; !exists {
+		sm.state.Tables[tableName] = &TableState{
+			CompletedSegments: []TimeSegment{},
+		}
+	}
+
+	sm.state.Tables[tableName].Status = "failed"
+	sm.saveStateUnlocked()
+}
+
+// RecordValidation 记录分段验证结果（同一分段只保留最近一次结果）
+func (sm *StateManager) RecordValidation(tableName string, result SegmentValidation) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.state.Tables[tableName]; !exists {
+		sm.state.Tables[tableName] = &TableState{
+			CompletedSegments: []TimeSegment{},
+		}
+	}
+
+	tableState := sm.state.Tables[tableName]
+	replaced := false
+	for i, existing := range tableState.Validations {
+		if existing.Range == result.Range &&
+			existing.Segment.Start.Equal(result.Segment.Start) && existing.Segment.End.Equal(result.Segment.End) {
+			tableState.Validations[i] = result
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		tableState.Validations = append(tableState.Validations, result)
+	}
+	tableState.LastValidation = &result
+
+	sm.saveStateUnlocked()
+}
+
+// StartRepair 开始（或恢复）一个分段修复
+func (sm *StateManager) StartRepair(tableName string, segment TimeSegment) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.state.Tables[tableName]; !exists {
+		sm.state.Tables[tableName] = &TableState{
+			CompletedSegments: []TimeSegment{},
+		}
+	}
+
+	tableState := sm.state.Tables[tableName]
+	if repair := findRepair(tableState, segment); repair != nil {
+		repair.Status = "in_progress"
+	} else {
+		tableState.Repairs = append(tableState.Repairs, RepairRecord{
+			Segment:        segment,
+			Status:         "in_progress",
+			RepairedSlices: []TimeSegment{},
+			StartedAt:      time.Now(),
+		})
+	}
+
+	sm.saveStateUnlocked()
+}
+
+// MarkRepairSliceCompleted 记录分段修复中已重新复制的子窗口
+func (sm *StateManager) MarkRepairSliceCompleted(tableName string, segment, slice TimeSegment, recordCount int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists {
+		return
+	}
+
+	if repair := findRepair(tableState, segment); repair != nil {
+		repair.RepairedSlices = append(repair.RepairedSlices, slice)
+		repair.RecordsRepaired += recordCount
+	}
+
+	sm.saveStateUnlocked()
+}
+
+// CompleteRepair 标记分段修复完成
+func (sm *StateManager) CompleteRepair(tableName string, segment TimeSegment) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists {
+		return
+	}
+
+	if repair := findRepair(tableState, segment); repair != nil {
+		repair.Status = "completed"
+		repair.FinishedAt = time.Now()
+	}
+
+	sm.saveStateUnlocked()
+}
+
+// GetPendingRepairSegments 获取需要修复的分段：未完成的修复 + 最近一次验证未通过的分段
+func (sm *StateManager) GetPendingRepairSegments(tableName string) []TimeSegment {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists {
+		return nil
+	}
+
+	segments := []TimeSegment{}
+	seen := func(segment TimeSegment) bool {
+		for _, s := range segments {
+			if s.Start.Equal(segment.Start) && s.End.Equal(segment.End) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, repair := range tableState.Repairs {
+		if repair.Status != "completed" {
+			segments = append(segments, repair.Segment)
+		}
+	}
+	for _, validation := range tableState.Validations {
+		// 非时间分段（ID 分段、分区）不按时间修复，由游标或分区状态在下次同步时重新同步
+		if !validation.Passed && validation.Range == "" && !seen(validation.Segment) {
+			segments = append(segments, validation.Segment)
+		}
+	}
+
+	return segments
+}
+
+// findRepair 查找分段对应的修复记录（调用方需持有锁）
+func findRepair(tableState *TableState, segment TimeSegment) *RepairRecord {
+	for i := range tableState.Repairs {
+		repair := &tableState.Repairs[i]
+		if repair.Segment.Start.Equal(segment.Start) && repair.Segment.End.Equal(segment.End) {
+			return repair
+		}
+	}
+	return nil
+}
+
+// StartReplace 记录开始一个分段替换（partition 为空表示按时间分段替换）
+func (sm *StateManager) StartReplace(tableName string, segment TimeSegment, partition, stagingTable string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.state.Tables[tableName]; !exists {
+		sm.state.Tables[tableName] = &TableState{
+			CompletedSegments: []TimeSegment{},
+		}
+	}
+
+	tableState := sm.state.Tables[tableName]
+	record := ReplaceRecord{
+		Segment:      segment,
+		Partition:    partition,
+		StagingTable: stagingTable,
+		Status:       "staging",
+		StartedAt:    time.Now(),
+	}
+	if replace := findReplace(tableState, segment, partition); replace != nil {
+		*replace = record
+	} else {
+		tableState.Replaces = append(tableState.Replaces, record)
+	}
+
+	sm.saveStateUnlocked()
+}
+
+// UpdateReplace 更新分段替换的进度（recordCount 小于 0 时不修改暂存条数）
+func (sm *StateManager) UpdateReplace(tableName string, segment TimeSegment, partition, status string, recordCount int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists {
+		return
+	}
+
+	if replace := findReplace(tableState, segment, partition); replace != nil {
+		replace.Status = status
+		if recordCount >= 0 {
+			replace.RecordsStaged = recordCount
+		}
+	}
+
+	sm.saveStateUnlocked()
+}
+
+// FinishReplace 删除分段替换记录（替换完成或已回滚）
+func (sm *StateManager) FinishReplace(tableName string, segment TimeSegment, partition string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists {
+		return
+	}
+
+	for i := range tableState.Replaces {
+		if tableState.Replaces[i].matches(segment, partition) {
+			tableState.Replaces = append(tableState.Replaces[:i], tableState.Replaces[i+1:]...)
+			break
+		}
+	}
+
+	sm.saveStateUnlocked()
+}
+
+// GetReplace 获取分段未完成的替换记录
+func (sm *StateManager) GetReplace(tableName string, segment TimeSegment, partition string) *ReplaceRecord {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists {
+		return nil
+	}
+
+	if replace := findReplace(tableState, segment, partition); replace != nil {
+		record := *replace
+		return &record
+	}
+	return nil
+}
+
+// GetPendingReplaces 获取表所有未完成的替换记录
+func (sm *StateManager) GetPendingReplaces(tableName string) []ReplaceRecord {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	tableState, exists := sm.state.Tables[tableName]
+	if !exists {
+		return nil
+	}
+
+	return append([]ReplaceRecord(nil), tableState.Replaces...)
+}
+
+// findReplace 查找分段对应的替换记录（调用方需持有锁）
+func findReplace(tableState *TableState, segment TimeSegment, partition string) *ReplaceRecord {
+	for i := range tableState.Replaces {
+		replace := &tableState.Replaces[i]
+		if replace.matches(segment, partition) {
+			return replace
+		}
+	}
+	return nil
+}
+
+// matches 判断替换记录是否对应该时间分段或分区
+func (r *ReplaceRecord) matches(segment TimeSegment, partition string) bool {
+	return r.Partition == partition && r.Segment.Start.Equal(segment.Start) && r.Segment.End.Equal(segment.End)
+}
+
 // GetTableState 获取表状态
 func (sm *StateManager) GetTableState(tableName string) *TableState {
 	sm.mu.Lock()
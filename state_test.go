@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ID 分段与分区的验证结果按范围分别记录，不参与按时间分段的修复
+func TestRecordValidationKeepsNonTimeRanges(t *testing.T) {
+	sm := NewStateManager(filepath.Join(t.TempDir(), "state.json"))
+	day := TimeSegment{
+		Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	sm.RecordValidation("events", SegmentValidation{Range: "0 ~ 100", Passed: false})
+	sm.RecordValidation("events", SegmentValidation{Range: "100 ~ 200", Passed: true})
+	sm.RecordValidation("events", SegmentValidation{Range: "0 ~ 100", Passed: true})
+	sm.RecordValidation("events", SegmentValidation{Segment: day, Passed: false})
+
+	validations := sm.state.Tables["events"].Validations
+	if len(validations) != 3 {
+		t.Fatalf("got %d validations, want 3", len(validations))
+	}
+	if !validations[0].Passed {
+		t.Error("re-validated range 0 ~ 100 was not replaced")
+	}
+
+	pending := sm.GetPendingRepairSegments("events")
+	if len(pending) != 1 || !pending[0].Start.Equal(day.Start) {
+		t.Errorf("GetPendingRepairSegments = %v, want only the failed day", pending)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// sweepLateArrivals 周期性回扫：按自己的间隔（与循环间隔无关）重新扫描目标库最新时间之前一段时间的数据，
+// 按去重补齐时间字段早于目标库最新时间、因而被实时窗口错过的延迟数据
+func (s *UniversalSyncer) sweepLateArrivals(ctx context.Context) error {
+	interval := time.Duration(s.config.Sync.Sweep.Interval) * time.Second
+	if interval <= 0 {
+		return nil
+	}
+	if last, ok := s.state.GetLastSweep(s.tableName); ok && time.Since(last.StartedAt) < interval {
+		return nil
+	}
+
+	maxTimeTarget, valid, err := s.timeCursor().Max(ctx, s.targetDB)
+	if err != nil {
+		return fmt.Errorf("failed to query target max time: %w", err)
+	}
+	if !valid {
+		return nil
+	}
+
+	lookback := s.tableConfig.GetEffectiveSweepLookback(s.config.Sync.Sweep.Lookback)
+	sweepRange := TimeRange{Start: maxTimeTarget.Add(-lookback), End: maxTimeTarget}
+	startedAt := time.Now()
+
+	log.Printf("🧹 %s: 开始回扫延迟数据（%s ~ %s）", s.tableName,
+		sweepRange.Start.Format("2006-01-02 15:04:05"), sweepRange.End.Format("2006-01-02 15:04:05"))
+
+	// 回扫始终去重，只写入目标库缺失的记录
+	recovered := 0
+	for _, segment := range SplitTimeRangeByDay(sweepRange, s.location) {
+		recordCount, err := s.syncSegment(ctx, segment)
+		if err != nil {
+			return fmt.Errorf("failed to sweep segment %s ~ %s: %w",
+				segment.Start.Format(time.RFC3339), segment.End.Format(time.RFC3339), err)
+		}
+		recovered += recordCount
+	}
+
+	s.state.RecordSweep(s.tableName, SweepRecord{
+		Range:      TimeSegment{Start: sweepRange.Start, End: sweepRange.End},
+		Recovered:  recovered,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	})
+
+	if recovered > 0 {
+		log.Printf("🧹 %s: 回扫完成，补齐 %d 条延迟数据", s.tableName, recovered)
+	} else {
+		log.Printf("🧹 %s: 回扫完成，没有发现延迟数据", s.tableName)
+	}
+	return nil
+}
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/shopspring/decimal"
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 )
 
 // ErrSourceTableEmpty 源表为空错误（用于跳过同步）
@@ -17,22 +19,33 @@ var ErrSourceTableEmpty = errors.New("source table is empty")
 
 // UniversalSyncer 通用同步器
 type UniversalSyncer struct {
-	tableName      string
-	tableConfig    TableConfig
-	tableSchema    *TableSchema
-	sourceDB       *sql.DB
-	targetDB       *sql.DB
-	config         *Config
-	state          *StateManager
-	deduplicator   *Deduplicator
-	colTypeMap     map[string]string // 列名到类型的映射，用于类型转换
-	skipCheckpoint bool              // 是否跳过断点续传检查（实时模式使用）
+	tableName        string
+	tableConfig      TableConfig
+	tableSchema      *TableSchema
+	sourceDB         *sql.DB
+	targetDB         *sql.DB
+	sourceConn       driver.Conn // 源库原生连接（按类型流式读取使用）
+	targetConn       driver.Conn // 目标库原生连接（列式批量插入使用）
+	config           *Config
+	state            *StateManager
+	deduplicator     *Deduplicator
+	validator        *Validator
+	skipCheckpoint   bool              // 是否跳过断点续传检查（实时模式使用）
+	validateRealtime bool              // 本次实时同步后是否执行验证（由协调器按周期设置）
+	skipDedup        bool              // 是否跳过去重（修复模式下依赖 ReplacingMergeTree 合并时使用）
+	insertTable      string            // 写入表（替换写入时为暂存表，为空表示目标表）
+	stagingConn      *sql.Conn         // 替换写入期间固定的目标库连接（暂存表的创建、写入与替换都在该连接上执行）
+	location         *time.Location    // 表的时区（按天分段的边界、查询参数与状态文件中的时间）
+	timeResolution   time.Duration     // 时间字段的精度（DateTime 为 1 秒，DateTime64(3) 为 1 毫秒）
+	budget           *ConnectionBudget // 所有表共享的连接预算（为 nil 时不限制）
+	sourceHealth     *ClusterHealth    // 源库节点健康状态（为 nil 时只按时间判断源库切换）
 }
 
 // NewUniversalSyncer 创建通用同步器
 func NewUniversalSyncer(
 	tableConfig TableConfig,
 	sourceDB, targetDB *sql.DB,
+	sourceConn, targetConn driver.Conn,
 	config *Config,
 	state *StateManager,
 ) (*UniversalSyncer, error) {
@@ -60,13 +73,31 @@ func NewUniversalSyncer(
 			tableConfig.Name, missingKeys, schema.GetColumnNames())
 	}
 
+	// 验证游标字段是否存在
+	if tableConfig.CursorField != "" && !schema.HasColumn(tableConfig.CursorField) {
+		return nil, fmt.Errorf("cursor field '%s' not found in table %s. Available columns: %v",
+			tableConfig.CursorField, tableConfig.Name, schema.GetColumnNames())
+	}
+
+	// 验证版本字段是否存在
+	if tableConfig.VersionField != "" && !schema.HasColumn(tableConfig.VersionField) {
+		return nil, fmt.Errorf("version field '%s' not found in table %s. Available columns: %v",
+			tableConfig.VersionField, tableConfig.Name, schema.GetColumnNames())
+	}
+
 	// 创建去重器
-	deduplicator := NewDeduplicator(tableConfig.DedupeKeys, tableConfig.TimeField)
+	deduplicator := NewDeduplicator(tableConfig.DedupeKeys, tableConfig.TimeField, tableConfig.VersionField,
+		tableConfig.GetEffectiveKeySet(config.Sync.KeySet), tableConfig.BloomFilter)
 
-	// 构建列类型映射
-	colTypeMap := make(map[string]string)
-	for _, col := range schema.Columns {
-		colTypeMap[col.Name] = col.Type
+	// 去重字段按列下标读取
+	deduplicator.BindColumns(schema, schema.GetColumnNames())
+
+	// 确定表的时区
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	location, err := ResolveTableLocation(ctx, sourceDB, config, tableConfig, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve timezone for %s: %w", tableConfig.Name, err)
 	}
 
 	return &UniversalSyncer{
@@ -75,50 +106,60 @@ func NewUniversalSyncer(
 		tableSchema:    schema,
 		sourceDB:       sourceDB,
 		targetDB:       targetDB,
+		sourceConn:     sourceConn,
+		targetConn:     targetConn,
 		config:         config,
 		state:          state,
 		deduplicator:   deduplicator,
-		colTypeMap:     colTypeMap,
+		validator:      NewValidator(sourceDB, targetDB, config),
 		skipCheckpoint: false, // 默认使用断点续传
+		location:       location,
+		timeResolution: timeResolution(schema.GetColumn(tableConfig.TimeField).Type),
 	}, nil
 }
 
 // Sync 执行同步
 func (s *UniversalSyncer) Sync(ctx context.Context) error {
+	if err := s.resumePendingReplaces(ctx); err != nil {
+		return err
+	}
+
 	mode := s.tableConfig.GetEffectiveMode(s.config.Sync.Mode)
 
 	if mode == "full" {
 		return s.fullSync(ctx)
 	}
+	if s.tableConfig.CursorField != "" {
+		return s.cursorSync(ctx)
+	}
+	if s.tableConfig.GetEffectiveSegmentation(s.config.Sync.Segmentation) == "partition" {
+		return s.partitionSync(ctx)
+	}
 	return s.incrementalSync(ctx)
 }
 
 // SyncWithRealtimeMode 智能同步：先追平历史数据，再进入实时监控模式
 func (s *UniversalSyncer) SyncWithRealtimeMode(ctx context.Context, realtimeThreshold time.Duration) error {
-	// 1. 查询目标库和源库的最新时间
-	timeField := s.tableConfig.TimeField
-	query := fmt.Sprintf("SELECT MAX(%s) FROM %s", timeField, s.tableName)
+	if err := s.resumePendingReplaces(ctx); err != nil {
+		return err
+	}
 
-	var maxTimeTarget sql.NullTime
-	err := s.targetDB.QueryRowContext(ctx, query).Scan(&maxTimeTarget)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("failed to query target max time: %w", err)
+	// 游标字段：追平与实时使用同一套进度（最后同步的 ID）
+	if s.tableConfig.CursorField != "" {
+		return s.cursorSync(ctx)
 	}
 
-	var maxTimeSource sql.NullTime
-	err = s.sourceDB.QueryRowContext(ctx, query).Scan(&maxTimeSource)
-	if err != nil && err != sql.ErrNoRows {
+	// 1. 查询目标库和源库的最新时间
+	cursor := s.timeCursor()
+	maxTimeTarget, targetTimeValid, err := cursor.Max(ctx, s.targetDB)
+	if err != nil {
+		return fmt.Errorf("failed to query target max time: %w", err)
+	}
+	maxTimeSource, sourceTimeValid, err := cursor.Max(ctx, s.sourceDB)
+	if err != nil {
 		return fmt.Errorf("failed to query source max time: %w", err)
 	}
 
-	// 验证时间有效性（ClickHouse 有效范围: 1900-01-01 到 2262-04-11）
-	minValidTime := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-	now := time.Now()
-	maxFutureTime := now.Add(24 * time.Hour)
-
-	targetTimeValid := maxTimeTarget.Valid && maxTimeTarget.Time.After(minValidTime) && maxTimeTarget.Time.Before(maxFutureTime)
-	sourceTimeValid := maxTimeSource.Valid && maxTimeSource.Time.After(minValidTime) && maxTimeSource.Time.Before(maxFutureTime)
-
 	// 2. 判断是否需要历史数据追平
 	needCatchup := false
 
@@ -133,22 +174,33 @@ func (s *UniversalSyncer) SyncWithRealtimeMode(ctx context.Context, realtimeThre
 		needCatchup = true
 	} else if sourceTimeValid {
 		// 都有效，计算延迟（用源库和目标库的差值）
-		lag := maxTimeSource.Time.Sub(maxTimeTarget.Time)
+		lag := maxTimeSource.Sub(maxTimeTarget)
 		if lag > realtimeThreshold {
 			log.Printf("📊 %s: 数据延迟 %s（源库: %s, 目标库: %s），开始追平历史数据...",
 				s.tableName, FormatDuration(lag),
-				maxTimeSource.Time.Format("2006-01-02 15:04:05"),
-				maxTimeTarget.Time.Format("2006-01-02 15:04:05"))
+				maxTimeSource.Format("2006-01-02 15:04:05"),
+				maxTimeTarget.Format("2006-01-02 15:04:05"))
 			needCatchup = true
 		}
 	}
 
+	// 上次并行追平未完成：目标库最大时间之前存在未同步的分段，延迟看起来很小也需要先追平
+	if pending, ok := s.state.GetEarliestPendingSegment(s.tableName); ok && targetTimeValid && !needCatchup {
+		log.Printf("📊 %s: 上次并行追平的分段 %s 未完成，先补齐历史数据...",
+			s.tableName, pending.Start.In(s.location).Format("2006-01-02 15:04:05"))
+		needCatchup = true
+	}
+
 	if needCatchup {
 		// 历史追平模式：使用断点续传
 		s.skipCheckpoint = false
 
-		// 执行历史数据同步
-		if err := s.incrementalSync(ctx); err != nil {
+		// 执行历史数据同步（分区分段时逐个分区追平）
+		catchup := s.incrementalSync
+		if s.tableConfig.GetEffectiveSegmentation(s.config.Sync.Segmentation) == "partition" {
+			catchup = s.partitionSync
+		}
+		if err := catchup(ctx); err != nil {
 			// 如果是源表为空错误，直接返回
 			if errors.Is(err, ErrSourceTableEmpty) {
 				return err
@@ -162,40 +214,120 @@ func (s *UniversalSyncer) SyncWithRealtimeMode(ctx context.Context, realtimeThre
 	// 3. 进入实时增量模式：不使用断点续传
 	log.Printf("🔄 %s: 已进入实时增量模式（监控最新变化）", s.tableName)
 	s.skipCheckpoint = true
-	return s.realtimeIncrementalSync(ctx)
+	if err := s.realtimeIncrementalSync(ctx); err != nil {
+		return err
+	}
+
+	// 4. 周期性回扫延迟到达的数据（按 sweep.interval 的独立周期）
+	if err := s.sweepLateArrivals(ctx); err != nil {
+		return err
+	}
+
+	// 5. 周期性验证（每 N 次实时循环）
+	if s.validateRealtime {
+		return s.validateRealtimeWindow(ctx)
+	}
+	return nil
+}
+
+// validateRealtimeWindow 验证目标库最新时间所在自然日内已同步的数据
+func (s *UniversalSyncer) validateRealtimeWindow(ctx context.Context) error {
+	if s.config.Sync.SkipValidation {
+		return nil
+	}
+
+	maxTimeTarget, valid, err := s.timeCursor().Max(ctx, s.targetDB)
+	if err != nil {
+		return fmt.Errorf("failed to query target max time: %w", err)
+	}
+	if !valid {
+		return nil
+	}
+
+	// 只验证到目标库最新时间（不含），避免与正在写入的边界数据比较
+	end := maxTimeTarget
+	start := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, s.location)
+	if !start.Before(end) {
+		return nil
+	}
+
+	log.Printf("🔎 %s: 执行周期性实时验证（%s ~ %s）",
+		s.tableName, start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"))
+	return s.validateSegment(ctx, TimeSegment{Start: start, End: end})
+}
+
+// validateSegment 验证已同步的分段，并把结果记录到状态文件
+func (s *UniversalSyncer) validateSegment(ctx context.Context, segment TimeSegment) error {
+	if s.config.Sync.SkipValidation {
+		return nil
+	}
+
+	result, err := s.validator.ValidateSegment(ctx, s.tableName, s.tableConfig.TimeField, segment)
+	if err != nil {
+		return fmt.Errorf("failed to validate segment: %w", err)
+	}
+	s.state.RecordValidation(s.tableName, *result)
+
+	if !result.Passed {
+		log.Printf("❌ %s: 分段验证失败 - 源库 %d 条，目标库 %d 条 (%.2f%%, 阈值 %.2f%%) %s",
+			s.tableName, result.SourceCount, result.TargetCount,
+			result.Ratio()*100, s.config.Sync.ValidationRatio*100, formatMismatches(result.Mismatches))
+		return fmt.Errorf("segment %s ~ %s: %w",
+			segment.Start.Format(time.RFC3339), segment.End.Format(time.RFC3339),
+			s.validator.validationError(result))
+	}
+
+	log.Printf("🔎 %s: 分段验证通过 - 源库 %d 条，目标库 %d 条 (%.2f%%)",
+		s.tableName, result.SourceCount, result.TargetCount, result.Ratio()*100)
+	return nil
 }
 
 // realtimeIncrementalSync 实时增量同步（只同步最新的时间窗口）
 // 使用双向时间窗口检查，防止数据库切换时的数据丢失
 func (s *UniversalSyncer) realtimeIncrementalSync(ctx context.Context) error {
-	timeField := s.tableConfig.TimeField
-	query := fmt.Sprintf("SELECT MAX(%s) FROM %s", timeField, s.tableName)
+	cursor := s.timeCursor()
 
 	// 1. 查询目标库最新时间
-	var maxTimeTarget sql.NullTime
-	err := s.targetDB.QueryRowContext(ctx, query).Scan(&maxTimeTarget)
-	if err != nil && err != sql.ErrNoRows {
+	maxTimeTarget, targetTimeValid, err := cursor.Max(ctx, s.targetDB)
+	if err != nil {
 		return fmt.Errorf("failed to query target max time: %w", err)
 	}
 
-	// 2. 查询源库最新时间
-	var maxTimeSource sql.NullTime
-	err = s.sourceDB.QueryRowContext(ctx, query).Scan(&maxTimeSource)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("failed to query source max time: %w", err)
+	// 2. 查询源库最新时间与复制延迟：本轮的窗口查询与读取固定在同一个源库连接（同一副本）上，
+	// 避免最新时间、新记录数和读到的数据来自不同的副本
+	replica, err := s.sourceDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to pin source connection: %w", err)
 	}
+	defer replica.Close()
 
-	// 验证时间有效性
-	minValidTime := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-	now := time.Now()
-	maxFutureTime := now.Add(24 * time.Hour)
+	replicaSettings := clickhouse.Settings{}
+	if s.config.Sync.ReplicaLag.SequentialConsistency {
+		replicaSettings["select_sequential_consistency"] = 1
+	}
+	replicaCtx := withQuerySettings(ctx, replicaSettings)
+	delay := ReplicaDelay(replicaCtx, replica, s.tableName)
+	if maxDelay := time.Duration(s.config.Sync.ReplicaLag.MaxDelay) * time.Second; maxDelay > 0 && delay > maxDelay {
+		log.Printf("⏸️  %s: 源库副本复制延迟 %s 超过上限 %s，本轮跳过",
+			s.tableName, FormatDuration(delay), FormatDuration(maxDelay))
+		return nil
+	}
 
-	targetTimeValid := maxTimeTarget.Valid && maxTimeTarget.Time.After(minValidTime) && maxTimeTarget.Time.Before(maxFutureTime)
-	sourceTimeValid := maxTimeSource.Valid && maxTimeSource.Time.After(minValidTime) && maxTimeSource.Time.Before(maxFutureTime)
+	maxTimeSource, sourceTimeValid, err := cursor.Max(replicaCtx, replica)
+	if err != nil {
+		return fmt.Errorf("failed to query source max time: %w", err)
+	}
+	now := time.Now().In(s.location)
+	switched, node := s.sourceHealth.NodeChanged(s.tableName)
 
 	// 3. 确定同步时间窗口
 	var startTime, endTime time.Time
-	backwardWindow := 5 * time.Minute // 回溯窗口
+	// 回溯窗口：正常情况下使用表的延迟到达窗口，数据库切换时至少回溯 5 分钟
+	lateWindow := s.tableConfig.GetEffectiveLateArrivalWindow(s.config.Sync.LateArrivalWindow)
+	backwardWindow := 5 * time.Minute
+	if lateWindow > backwardWindow {
+		backwardWindow = lateWindow
+	}
 
 	if !targetTimeValid {
 		// 目标库为空或时间无效
@@ -205,23 +337,27 @@ func (s *UniversalSyncer) realtimeIncrementalSync(ctx context.Context) error {
 		}
 		// 从5分钟前开始
 		startTime = now.Add(-backwardWindow)
-		endTime = maxTimeSource.Time
+		endTime = maxTimeSource
 	} else if !sourceTimeValid {
 		// 源库为空（罕见情况），不同步
 		return nil
 	} else {
 		// 4. 双向时间窗口策略
 		// 使用回溯窗口从目标库最新时间往前检查
-		startTime = maxTimeTarget.Time.Add(-backwardWindow)
+		startTime = maxTimeTarget.Add(-backwardWindow)
 		// endTime 使用源库最大时间，并加 1 秒确保包含边界数据
-		endTime = maxTimeSource.Time.Add(1 * time.Second)
+		endTime = maxTimeSource.Add(1 * time.Second)
 
-		// 5. 检测数据库切换场景
-		if maxTimeSource.Time.Before(maxTimeTarget.Time) {
+		// 5. 检测数据库切换场景：源库活动节点切换（确定），或源库时间早于目标库时间（节点未变时的兜底判断）
+		if switched {
+			log.Printf("⚠️  %s: 源库活动节点已切换到 %s", s.tableName, node)
+			log.Printf("🔍 %s: 回溯检查最近 %v 的数据，确保不遗漏切换窗口期的数据...",
+				s.tableName, backwardWindow)
+		} else if maxTimeSource.Before(maxTimeTarget) {
 			log.Printf("⚠️  %s: 检测到源库时间(%s)早于目标库时间(%s)，可能发生了数据库切换",
 				s.tableName,
-				maxTimeSource.Time.Format("2006-01-02 15:04:05"),
-				maxTimeTarget.Time.Format("2006-01-02 15:04:05"))
+				maxTimeSource.Format("2006-01-02 15:04:05"),
+				maxTimeTarget.Format("2006-01-02 15:04:05"))
 			log.Printf("🔍 %s: 回溯检查最近 %v 的数据，确保不遗漏切换窗口期的数据...",
 				s.tableName, backwardWindow)
 
@@ -230,17 +366,29 @@ func (s *UniversalSyncer) realtimeIncrementalSync(ctx context.Context) error {
 			// 这样可以捕获切换窗口期内未同步的数据
 		} else {
 			// 正常场景：源库时间 >= 目标库时间
-			// 使用较小的回溯窗口（5秒），提高实时性
-			startTime = maxTimeTarget.Time.Add(-5 * time.Second)
+			// 使用延迟到达窗口（默认 5 秒），更早的延迟数据由周期性回扫处理
+			startTime = maxTimeTarget.Add(-lateWindow)
 		}
 	}
 
-	// 2. 查询源库是否有新数据
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s >= ? AND %s <= ?",
-		s.tableName, timeField, timeField)
+	// 6. 副本落后 delay 时，源库最新时间之前 delay 内的数据可能尚未完整复制，结束边界相应回退，
+	// 这部分数据留到下一轮（下一轮从目标库最新时间开始）
+	if delay > 0 {
+		endTime = endTime.Add(-delay)
+		log.Printf("🐢 %s: 源库副本复制延迟 %s，同步结束边界回退到 %s",
+			s.tableName, FormatDuration(delay), endTime.Format("15:04:05"))
+		if !endTime.After(startTime) {
+			return nil
+		}
+	}
+
+	// 7. 查询源库是否有新数据
+	segment := TimeSegment{Start: startTime, End: endTime}
+	r := cursor.Range(segment.Start, segment.End)
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", s.tableName, r.Where())
 
 	var newRecordCount int64
-	err = s.sourceDB.QueryRowContext(ctx, countQuery, startTime, endTime).Scan(&newRecordCount)
+	err = replica.QueryRowContext(replicaCtx, countQuery, r.Args()...).Scan(&newRecordCount)
 	if err != nil {
 		return fmt.Errorf("failed to count new records: %w", err)
 	}
@@ -255,9 +403,11 @@ func (s *UniversalSyncer) realtimeIncrementalSync(ctx context.Context) error {
 		startTime.Format("15:04:05"),
 		endTime.Format("15:04:05"))
 
-	// 3. 同步新数据
-	segment := TimeSegment{Start: startTime, End: endTime}
+	// 8. 同步新数据（经固定的源库连接读取）
+	prevConn := s.sourceConn
+	s.sourceConn = &pinnedConn{Conn: prevConn, conn: replica, settings: replicaSettings}
 	recordCount, err := s.syncSegment(ctx, segment)
+	s.sourceConn = prevConn
 	if err != nil {
 		return fmt.Errorf("failed to sync new records: %w", err)
 	}
@@ -269,6 +419,36 @@ func (s *UniversalSyncer) realtimeIncrementalSync(ctx context.Context) error {
 	return nil
 }
 
+// pinnedConn 把读取与批量插入固定在一个 database/sql 连接（同一节点）上，其余操作仍使用原生连接池
+// 源库：实时窗口的最新时间、新记录数与数据读取使用同一个连接，读到的数据与确定窗口时看到的副本状态一致
+// 目标库：替换写入时暂存表只存在于创建它的节点，写入暂存表的批次与创建、替换使用同一个连接
+type pinnedConn struct {
+	driver.Conn
+	conn     *sql.Conn
+	settings clickhouse.Settings // 附加到读取查询的设置（与窗口查询相同）
+}
+
+func (c *pinnedConn) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	rows, err := c.conn.QueryContext(withQuerySettings(ctx, c.settings), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlRows{Rows: rows}, nil
+}
+
+func (c *pinnedConn) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	return &sqlRow{row: c.conn.QueryRowContext(withQuerySettings(ctx, c.settings), query, args...)}
+}
+
+func (c *pinnedConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	return &sqlBatch{
+		ctx:    withQuerySettings(ctx, c.settings),
+		db:     c.conn,
+		query:  query,
+		filled: make(map[int]int),
+	}, nil
+}
+
 // incrementalSync 增量同步
 func (s *UniversalSyncer) incrementalSync(ctx context.Context) error {
 	// 1. 确定时间范围
@@ -286,34 +466,56 @@ func (s *UniversalSyncer) incrementalSync(ctx context.Context) error {
 	log.Printf("📊 %s: 同步时间范围 %s ~ %s",
 		s.tableName, timeRange.Start.Format(time.RFC3339), timeRange.End.Format(time.RFC3339))
 
-	// 2. 按天分段
-	segments := s.segmentTimeRange(timeRange)
-	log.Printf("📦 %s: 分为 %d 个日分段", s.tableName, len(segments))
+	// 2. 分段（按天或按行数密度）
+	segments, err := s.segmentTimeRange(ctx, timeRange)
+	if err != nil {
+		return err
+	}
+	log.Printf("📦 %s: 分为 %d 个分段", s.tableName, len(segments))
+
+	// 并行时分段乱序完成：记录待完成的分段，中断后从最早未完成的分段续传
+	if !s.skipCheckpoint && s.tableConfig.GetEffectiveSegmentConcurrency() > 1 {
+		s.state.StartSegments(s.tableName, segments)
+	}
 
-	// 3. 逐段同步
+	// 3. 逐段同步（segment_concurrency > 1 时并行，检查点按完成顺序记录）
+	var mu sync.Mutex
 	totalRecords := 0
-	for i, segment := range segments {
+	err = s.forEachSegment(ctx, len(segments), func(ctx context.Context, worker *UniversalSyncer, i int) error {
+		segment := segments[i]
+
 		// 检查是否已完成（断点续传）
-		if !s.skipCheckpoint && s.state.IsSegmentCompleted(s.tableName, segment) {
-			log.Printf("⏭️  %s: 分段 %d/%d 已完成，跳过", s.tableName, i+1, len(segments))
-			continue
+		if !worker.skipCheckpoint && worker.state.IsSegmentCompleted(worker.tableName, segment) {
+			log.Printf("⏭️  %s: 分段 %d/%d 已完成，跳过", worker.tableName, i+1, len(segments))
+			return nil
 		}
 
 		// 同步该分段
-		recordCount, err := s.syncSegment(ctx, segment)
+		recordCount, err := worker.syncSegment(ctx, segment)
 		if err != nil {
 			return fmt.Errorf("failed to sync segment %v: %w", segment, err)
 		}
 
+		mu.Lock()
 		totalRecords += recordCount
+		mu.Unlock()
+
+		// 验证该分段（未通过则不记录检查点，下次运行会重新同步）
+		if err := worker.validateSegment(ctx, segment); err != nil {
+			return err
+		}
 
 		// 保存检查点（仅在非跳过检查点模式下）
-		if !s.skipCheckpoint {
-			s.state.MarkSegmentCompleted(s.tableName, segment, recordCount)
+		if !worker.skipCheckpoint {
+			worker.state.MarkSegmentCompleted(worker.tableName, segment, recordCount)
 		}
 
 		log.Printf("✅ %s: 分段 %d/%d 完成，同步 %d 条记录",
-			s.tableName, i+1, len(segments), recordCount)
+			worker.tableName, i+1, len(segments), recordCount)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	log.Printf("🎉 %s: 增量同步完成，总计 %d 条记录", s.tableName, totalRecords)
@@ -331,32 +533,25 @@ func (s *UniversalSyncer) determineTimeRange() (TimeRange, error) {
 	// 确定结束时间
 	if s.config.TimeRange.End != "" {
 		var err error
-		endTime, err = time.Parse(time.RFC3339, s.config.TimeRange.End)
+		endTime, err = ParseTimeInLocation(s.config.TimeRange.End, s.location)
 		if err != nil {
 			return TimeRange{}, fmt.Errorf("invalid end time: %w", err)
 		}
 		log.Printf("⏱️  %s: 使用配置的结束时间: %s", s.tableName, endTime.Format(time.RFC3339))
 	} else {
 		// 查询源库的最新时间作为结束时间
-		query := fmt.Sprintf("SELECT MAX(%s) FROM %s", timeField, s.tableName)
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		var maxTimeSource sql.NullTime
-		err := s.sourceDB.QueryRowContext(ctx, query).Scan(&maxTimeSource)
-		if err != nil && err != sql.ErrNoRows {
+		maxTimeSource, valid, err := s.timeCursor().Max(ctx, s.sourceDB)
+		if err != nil {
 			return TimeRange{}, fmt.Errorf("failed to query source max time: %w", err)
 		}
 
-		// 验证源库时间有效性
-		minValidTime := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-		now := time.Now()
-		maxFutureTime := now.Add(24 * time.Hour)
-
-		if maxTimeSource.Valid && maxTimeSource.Time.After(minValidTime) && maxTimeSource.Time.Before(maxFutureTime) {
+		if valid {
 			// 使用源库最新时间 + 1秒，确保包含边界数据
-			endTime = maxTimeSource.Time.Add(1 * time.Second)
-			log.Printf("⏱️  %s: 使用源库最新时间作为结束时间: %s (含边界)", s.tableName, maxTimeSource.Time.Format(time.RFC3339))
+			endTime = maxTimeSource.Add(1 * time.Second)
+			log.Printf("⏱️  %s: 使用源库最新时间作为结束时间: %s (含边界)", s.tableName, maxTimeSource.Format(time.RFC3339))
 		} else {
 			// 源库无有效数据
 			log.Printf("⏭️  %s: 源库无有效数据，跳过同步", s.tableName)
@@ -368,49 +563,46 @@ func (s *UniversalSyncer) determineTimeRange() (TimeRange, error) {
 	if s.config.TimeRange.AutoDetect {
 		// 查询目标库的最大时间
 		log.Printf("🔍 %s: 正在查询目标库最新时间（字段: %s）...", s.tableName, timeField)
-		query := fmt.Sprintf("SELECT MAX(%s) FROM %s", timeField, s.tableName)
-
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		var maxTime sql.NullTime
-		err := s.targetDB.QueryRowContext(ctx, query).Scan(&maxTime)
-		if err != nil && err != sql.ErrNoRows {
+		maxTime, isValidTime, err := s.timeCursor().Max(ctx, s.targetDB)
+		if err != nil {
 			log.Printf("❌ %s: 查询最大时间失败: %v", s.tableName, err)
 			return TimeRange{}, fmt.Errorf("failed to query max time: %w", err)
 		}
 
-		// 验证时间有效性（ClickHouse 有效范围: 1900-01-01 到 2262-04-11）
-		minValidTime := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-		isValidTime := maxTime.Valid && maxTime.Time.After(minValidTime) && maxTime.Time.Before(endTime.Add(24*time.Hour))
-
 		if isValidTime {
-			startTime = maxTime.Time.Add(1 * time.Millisecond) // 从最大时间后 1ms 开始
-			log.Printf("🔍 %s: 检测到目标库最新时间 %s，从该时间后开始同步", s.tableName, maxTime.Time.Format(time.RFC3339))
+			startTime = maxTime.Add(s.timeResolution) // 从最大时间后的下一个可表示时间开始（按字段精度）
+			log.Printf("🔍 %s: 检测到目标库最新时间 %s，从该时间后开始同步", s.tableName, maxTime.Format(time.RFC3339))
+
+			// 上次并行同步未完成：之后的分段可能已先写入，目标库最大时间之前仍有缺口
+			if pending, ok := s.state.GetEarliestPendingSegment(s.tableName); ok && pending.Start.Before(startTime) {
+				startTime = pending.Start.In(s.location)
+				log.Printf("♻️  %s: 上次并行同步的分段 %s 未完成，从该分段开始续传",
+					s.tableName, startTime.Format(time.RFC3339))
+			}
 		} else {
 			// 目标库为空，检查源库是否有数据
 			log.Printf("🔍 %s: 目标库为空，检查源库是否有数据...", s.tableName)
-			sourceQuery := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s", timeField, timeField, s.tableName)
-
-			var minTimeSource, maxTimeSource sql.NullTime
-			err := s.sourceDB.QueryRowContext(ctx, sourceQuery).Scan(&minTimeSource, &maxTimeSource)
-			if err != nil && err != sql.ErrNoRows {
+			minTimeSource, valid, err := s.timeCursor().Min(ctx, s.sourceDB)
+			if err != nil {
 				log.Printf("❌ %s: 查询源库时间范围失败: %v", s.tableName, err)
 				return TimeRange{}, fmt.Errorf("failed to query source time range: %w", err)
 			}
 
 			// 如果源库也没有数据，跳过同步
-			if !minTimeSource.Valid || !maxTimeSource.Valid {
+			if !valid {
 				log.Printf("⏭️  %s: 源库无数据，跳过同步", s.tableName)
 				return TimeRange{}, ErrSourceTableEmpty
 			}
 
 			// 源库有数据，使用 fallback 时间或源库最小时间
-			fallbackTime := time.Now().AddDate(0, 0, -s.config.TimeRange.FallbackDays)
-			if minTimeSource.Time.After(fallbackTime) {
+			fallbackTime := time.Now().In(s.location).AddDate(0, 0, -s.config.TimeRange.FallbackDays)
+			if minTimeSource.After(fallbackTime) {
 				// 如果源库最早数据比 fallback 时间还新，就从源库最早数据开始
-				startTime = minTimeSource.Time
-				log.Printf("🔍 %s: 源库最早数据时间 %s，从该时间开始同步", s.tableName, minTimeSource.Time.Format(time.RFC3339))
+				startTime = minTimeSource
+				log.Printf("🔍 %s: 源库最早数据时间 %s，从该时间开始同步", s.tableName, minTimeSource.Format(time.RFC3339))
 			} else {
 				// 否则使用 fallback 时间
 				startTime = fallbackTime
@@ -419,13 +611,13 @@ func (s *UniversalSyncer) determineTimeRange() (TimeRange, error) {
 		}
 	} else if s.config.TimeRange.Start != "" {
 		var err error
-		startTime, err = time.Parse(time.RFC3339, s.config.TimeRange.Start)
+		startTime, err = ParseTimeInLocation(s.config.TimeRange.Start, s.location)
 		if err != nil {
 			return TimeRange{}, fmt.Errorf("invalid start time: %w", err)
 		}
 		log.Printf("⏱️  %s: 使用配置的开始时间: %s", s.tableName, startTime.Format(time.RFC3339))
 	} else {
-		startTime = time.Now().AddDate(0, 0, -30) // 默认 30 天
+		startTime = time.Now().In(s.location).AddDate(0, 0, -30) // 默认 30 天
 		log.Printf("⏱️  %s: 使用默认30天前作为开始时间: %s", s.tableName, startTime.Format(time.RFC3339))
 	}
 
@@ -435,135 +627,206 @@ func (s *UniversalSyncer) determineTimeRange() (TimeRange, error) {
 
 // syncSegment 同步一个时间分段
 func (s *UniversalSyncer) syncSegment(ctx context.Context, segment TimeSegment) (int, error) {
-	timeField := s.tableConfig.TimeField
-	batchSize := s.tableConfig.GetEffectiveBatchSize(s.config.Sync.BatchSize)
+	// 替换写入模式：经暂存表整体替换目标库分段
+	if s.insertTable == "" && s.tableConfig.GetEffectiveWriteMode(s.config.Sync.WriteMode) == "replace_segment" {
+		return s.replaceSegment(ctx, segment)
+	}
+
+	r := s.timeCursor().Range(segment.Start, segment.End)
+
+	// 服务端传输模式：由目标库直接从源库拉取
+	if s.tableConfig.Transfer == "remote" {
+		return s.syncSegmentRemote(ctx, r)
+	}
 
-	log.Printf("⏰ %s: 同步时间段 %s ~ %s",
-		s.tableName,
-		segment.Start.Format("2006-01-02 15:04:05"),
-		segment.End.Format("2006-01-02 15:04:05"))
+	// 内存去重：目标库该时间段记录数超过上限时，拆分为更小的时间段
+	dedupeStrategy := s.tableConfig.GetEffectiveDedupeStrategy(s.config.Sync.DedupeStrategy)
+	if limit := s.config.Sync.MaxKeysInMemory; !s.skipDedup && dedupeStrategy == "memory" &&
+		limit > 0 && segment.End.Sub(segment.Start) > time.Minute {
+		existingCount, err := s.countTargetRecords(ctx, r)
+		if err != nil {
+			return 0, err
+		}
+		if existingCount > limit {
+			mid := segment.Start.Add(segment.End.Sub(segment.Start) / 2).Truncate(time.Second)
+			log.Printf("✂️  %s: 目标库该时间段已有 %d 条记录（超过上限 %d），拆分为两段",
+				s.tableName, existingCount, limit)
+			left, err := s.syncSegment(ctx, TimeSegment{Start: segment.Start, End: mid})
+			if err != nil {
+				return left, err
+			}
+			right, err := s.syncSegment(ctx, TimeSegment{Start: mid, End: segment.End})
+			return left + right, err
+		}
+	}
+
+	return s.copyRange(ctx, r)
+}
+
+// copyRange 从源库流式读取一个分段范围，去重后批量写入目标库
+// 源库查询中断等可重试的错误按退避策略重新复制整个分段：重新复制时重新判定目标库已存在的键，已写入的记录不会重复；
+// 不去重（写入暂存表）时只在尚未写入任何数据时重试
+func (s *UniversalSyncer) copyRange(ctx context.Context, r SegmentRange) (int, error) {
+	totalInserted := 0
+	err := withRetry(ctx, s.config.Sync.Retry, fmt.Sprintf("%s: 复制分段 %s ", s.tableName, r), func() error {
+		inserted, err := s.copyRangeOnce(ctx, r)
+		totalInserted += inserted
+		if err != nil && s.skipDedup && inserted > 0 {
+			return &finalError{err: err}
+		}
+		return err
+	})
+	return totalInserted, err
+}
+
+// copyRangeOnce 复制一次分段范围（读取、去重、写入流水线见 pipeline.go）
+func (s *UniversalSyncer) copyRangeOnce(ctx context.Context, r SegmentRange) (int, error) {
+	log.Printf("⏰ %s: 同步分段 %s", s.tableName, r)
 
 	// 1. 查询目标库已存在的去重键
-	existingKeys, err := s.deduplicator.FetchExistingKeys(
-		s.targetDB, s.tableName, segment, s.tableSchema,
-	)
-	if err != nil {
-		return 0, fmt.Errorf("failed to fetch existing keys: %w", err)
+	dedupeStrategy := s.tableConfig.GetEffectiveDedupeStrategy(s.config.Sync.DedupeStrategy)
+	existingKeys := s.deduplicator.NewKeySet()
+	var keyTable *dedupKeyTable
+	if !s.skipDedup && dedupeStrategy == "anti_join" {
+		// 目标库侧反连接去重：按批次过滤，不加载整个分段的键
+		var err error
+		keyTable, err = s.createKeyTable(ctx)
+		if err != nil {
+			return 0, err
+		}
+		defer s.dropKeyTable(keyTable)
+		log.Printf("🔑 %s: 使用目标库反连接去重（键表 %s）", s.tableName, keyTable.name)
+	} else if !s.skipDedup {
+		var err error
+		existingKeys, err = s.deduplicator.FetchExistingKeys(
+			ctx, s.targetConn, s.tableName, r, s.tableSchema,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to fetch existing keys: %w", err)
+		}
+		log.Printf("🔑 %s: 目标库已有 %d 条记录（该分段），去重键内存约 %s",
+			s.tableName, existingKeys.Len(), FormatBytes(existingKeys.MemoryBytes()))
 	}
-	log.Printf("🔑 %s: 目标库已有 %d 条记录（该时间段）", s.tableName, len(existingKeys))
 
 	// 2. 构建查询 SQL（查询所有字段）
 	columns := s.tableSchema.GetColumnNames()
 	columnsStr := strings.Join(columns, ", ")
 
 	query := fmt.Sprintf(
-		"SELECT %s FROM %s WHERE %s >= ? AND %s < ? ORDER BY %s",
-		columnsStr, s.tableName, timeField, timeField, timeField,
+		"SELECT %s FROM %s WHERE %s ORDER BY %s",
+		columnsStr, s.tableName, r.Where(), r.Field,
 	)
 
 	// 3. 流式查询源库数据
 	log.Printf("🔍 %s: 开始查询源库数据...", s.tableName)
-	rows, err := s.sourceDB.QueryContext(ctx, query, segment.Start, segment.End)
+	rows, err := s.sourceConn.Query(ctx, query, r.Args()...)
 	if err != nil {
 		return 0, fmt.Errorf("failed to query source: %w", err)
 	}
 	defer rows.Close()
 
-	// 4. 批量读取、去重、插入
-	totalInserted := 0
-	totalScanned := 0
-	totalSkipped := 0
-	batch := make([]map[string]interface{}, 0, batchSize)
-	batchCount := 0
-
-	for rows.Next() {
-		totalScanned++
-
-		// 扫描一行数据
-		record, err := s.scanRow(rows, columns)
-		if err != nil {
-			return totalInserted, fmt.Errorf("failed to scan row: %w", err)
-		}
+	// 4. 读取、去重、插入分阶段并发执行
+	scanner, err := NewRowScanner(s.tableSchema, columns)
+	if err != nil {
+		return 0, err
+	}
+	pipeline := newCopyPipeline(s, r, columns, existingKeys, keyTable)
+	err = pipeline.run(ctx, rows, scanner)
+	if err != nil {
+		return pipeline.inserted, err
+	}
 
-		// 检查是否已存在（去重）
-		key := s.deduplicator.BuildKey(record)
-		if existingKeys[key] {
-			totalSkipped++
-			continue // 跳过已存在的记录
-		}
+	totalScanned, totalInserted, totalSkipped, totalUpdated :=
+		pipeline.scanned, pipeline.inserted, pipeline.skipped, pipeline.updated
+	log.Printf("✨ %s: 分段完成 - 扫描 %d 条, 新增 %d 条, 跳过 %d 条, 去重键内存约 %s",
+		s.tableName, totalScanned, totalInserted, totalSkipped, FormatBytes(existingKeys.MemoryBytes()))
+	pipeline.logStats()
+	if totalUpdated > 0 {
+		log.Printf("🔄 %s: 其中 %d 条记录源库版本（%s）更新，已重新写入",
+			s.tableName, totalUpdated, s.tableConfig.VersionField)
+	}
 
-		batch = append(batch, record)
+	return totalInserted, nil
+}
 
-		// 批量插入
-		if len(batch) >= batchSize {
-			batchCount++
-			inserted, err := s.insertBatch(ctx, batch, columns)
-			if err != nil {
-				return totalInserted, fmt.Errorf("failed to insert batch: %w", err)
-			}
-			totalInserted += inserted
-			batch = batch[:0] // 清空
+// timeCursor 返回时间字段游标（历史追平、实时同步与回扫共用）
+func (s *UniversalSyncer) timeCursor() syncCursor[time.Time] {
+	return timeCursor{field: s.tableConfig.TimeField, tableName: s.tableName, location: s.location}
+}
 
-			log.Printf("📦 %s: 批次 #%d 插入 %d 条 | 累计: 扫描 %d, 插入 %d, 跳过 %d",
-				s.tableName, batchCount, inserted, totalScanned, totalInserted, totalSkipped)
-		}
+// destTable 返回数据写入的表（替换写入时为暂存表）
+func (s *UniversalSyncer) destTable() string {
+	if s.insertTable != "" {
+		return s.insertTable
 	}
+	return s.tableName
+}
 
-	if err := rows.Err(); err != nil {
-		return totalInserted, fmt.Errorf("error iterating rows: %w", err)
+// targetSQL 返回执行写入相关语句的目标库连接：替换写入期间为固定的暂存连接，否则为连接池
+func (s *UniversalSyncer) targetSQL() sqlConn {
+	if s.stagingConn != nil {
+		return s.stagingConn
 	}
+	return s.targetDB
+}
 
-	// 5. 插入剩余数据
-	if len(batch) > 0 {
-		batchCount++
-		inserted, err := s.insertBatch(ctx, batch, columns)
-		if err != nil {
-			return totalInserted, fmt.Errorf("failed to insert final batch: %w", err)
-		}
-		totalInserted += inserted
+// countTargetRecords 统计写入表指定分段范围的记录数
+func (s *UniversalSyncer) countTargetRecords(ctx context.Context, r SegmentRange) (int, error) {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", s.destTable(), r.Where())
 
-		log.Printf("📦 %s: 批次 #%d 插入 %d 条 | 累计: 扫描 %d, 插入 %d, 跳过 %d",
-			s.tableName, batchCount, inserted, totalScanned, totalInserted, totalSkipped)
+	var count int
+	if err := s.targetSQL().QueryRowContext(ctx, query, r.Args()...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count target records: %w", err)
 	}
+	return count, nil
+}
 
-	log.Printf("✨ %s: 时间段完成 - 扫描 %d 条, 新增 %d 条, 跳过 %d 条",
-		s.tableName, totalScanned, totalInserted, totalSkipped)
+// insertBatch 批量插入数据（按表配置选择原生列式插入或 database/sql 插入），可重试的错误按退避策略重试
+// commitTurnKey 等待提交顺序的函数在 context 中的键
+type commitTurnKey struct{}
 
-	return totalInserted, nil
+// withCommitTurn 设置提交前的等待：批次数据已经转换并缓冲，发送（提交）之前调用 wait 等待轮到该批次
+func withCommitTurn(ctx context.Context, wait func(ctx context.Context) error) context.Context {
+	return context.WithValue(ctx, commitTurnKey{}, wait)
 }
 
-// scanRow 扫描一行数据到 map
-func (s *UniversalSyncer) scanRow(rows *sql.Rows, columns []string) (map[string]interface{}, error) {
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
-	for i := range values {
-		valuePtrs[i] = &values[i]
-	}
-
-	if err := rows.Scan(valuePtrs...); err != nil {
-		return nil, err
+// awaitCommitTurn 等待轮到当前批次提交（未设置提交顺序时直接返回）
+func awaitCommitTurn(ctx context.Context) error {
+	if wait, ok := ctx.Value(commitTurnKey{}).(func(ctx context.Context) error); ok {
+		return wait(ctx)
 	}
+	return nil
+}
 
-	record := make(map[string]interface{})
-	for i, col := range columns {
-		record[col] = values[i]
-	}
+// token 作为 insert_deduplication_token 发送，重试的写入在 Replicated 表上不会重复
+func (s *UniversalSyncer) insertBatch(ctx context.Context, batch *RowBatch, columns []string, token string) (int, error) {
+	ctx = withQuerySettings(ctx, clickhouse.Settings{"insert_deduplication_token": token})
 
-	return record, nil
+	inserted := 0
+	err := withRetry(ctx, s.config.Sync.Retry, fmt.Sprintf("%s: 写入批次", s.tableName), func() error {
+		var err error
+		if s.tableConfig.GetEffectiveInsertMethod(s.config.Sync.InsertMethod) == "native" {
+			inserted, err = s.insertBatchNative(ctx, batch, columns)
+		} else {
+			inserted, err = s.insertBatchSQL(ctx, batch, columns)
+		}
+		return err
+	})
+	return inserted, err
 }
 
-// insertBatch 批量插入数据
-func (s *UniversalSyncer) insertBatch(ctx context.Context, batch []map[string]interface{}, columns []string) (int, error) {
-	if len(batch) == 0 {
+// insertBatchSQL 通过 database/sql 事务逐行写入批量数据
+func (s *UniversalSyncer) insertBatchSQL(ctx context.Context, batch *RowBatch, columns []string) (int, error) {
+	if batch.Len() == 0 {
 		return 0, nil
 	}
 
 	// 使用 ClickHouse 原生批量插入
 	columnsStr := strings.Join(columns, ", ")
-	query := fmt.Sprintf("INSERT INTO %s (%s)", s.tableName, columnsStr)
+	query := fmt.Sprintf("INSERT INTO %s (%s)", s.destTable(), columnsStr)
 
 	// 开始批量插入
-	tx, err := s.targetDB.Begin()
+	tx, err := s.targetSQL().BeginTx(ctx, nil)
 	if err != nil {
 		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
@@ -575,58 +838,23 @@ func (s *UniversalSyncer) insertBatch(ctx context.Context, batch []map[string]in
 	}
 	defer stmt.Close()
 
-	// 逐行插入
-	for _, record := range batch {
-		values := make([]interface{}, len(columns))
-		for i, col := range columns {
-			val := record[col]
-
-			// 特殊处理 Decimal 类型：将 string 转为 decimal.Decimal
-			if typeStr, ok := s.colTypeMap[col]; ok && strings.Contains(typeStr, "Decimal") {
-				if valStr, ok := val.(string); ok {
-					if d, err := decimal.NewFromString(valStr); err == nil {
-						values[i] = d
-						continue
-					}
-				} else if valBytes, ok := val.([]byte); ok {
-					// 某些驱动可能返回 []byte
-					if d, err := decimal.NewFromString(string(valBytes)); err == nil {
-						values[i] = d
-						continue
-					}
-				}
-			}
-
-			// 特殊处理 DateTime 类型：验证时间范围
-			if typeStr, ok := s.colTypeMap[col]; ok && strings.Contains(typeStr, "DateTime") {
-				if t, ok := val.(time.Time); ok {
-					// ClickHouse DateTime 范围: 1900-01-01 到 2262-04-11
-					minTime := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-					maxTime := time.Date(2262, 4, 11, 23, 47, 16, 0, time.UTC)
-
-					if t.Before(minTime) || t.After(maxTime) || t.IsZero() {
-						// 超出范围或零值，使用默认时间（1970-01-01）
-						values[i] = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
-						continue
-					}
-				}
-			}
-
-			values[i] = val
-		}
-
-		_, err := stmt.ExecContext(ctx, values...)
+	// 逐行插入（Decimal、DateTime 已在扫描与缓冲阶段按类型处理）
+	for i := 0; i < batch.Len(); i++ {
+		_, err := stmt.ExecContext(ctx, batch.Row(i)...)
 		if err != nil {
 			return 0, fmt.Errorf("failed to insert row: %w", err)
 		}
 	}
 
-	// 提交事务
+	// 提交事务（多个写入协程时按批次顺序提交）
+	if err := awaitCommitTurn(ctx); err != nil {
+		return 0, err
+	}
 	if err := tx.Commit(); err != nil {
 		return 0, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return len(batch), nil
+	return batch.Len(), nil
 }
 
 // fullSync 全量同步
@@ -639,30 +867,35 @@ func (s *UniversalSyncer) fullSync(ctx context.Context) error {
 
 	query := fmt.Sprintf("SELECT %s FROM %s", columnsStr, s.tableName)
 
-	rows, err := s.sourceDB.QueryContext(ctx, query)
+	rows, err := s.sourceConn.Query(ctx, query)
 	if err != nil {
 		return fmt.Errorf("failed to query source: %w", err)
 	}
 	defer rows.Close()
 
+	scanner, err := NewRowScanner(s.tableSchema, columns)
+	if err != nil {
+		return err
+	}
+	batch := NewRowBatch(scanner, batchSize)
 	totalInserted := 0
-	batch := make([]map[string]interface{}, 0, batchSize)
+	batchIndex := 0
 
 	for rows.Next() {
-		record, err := s.scanRow(rows, columns)
-		if err != nil {
+		if err := scanner.Scan(rows); err != nil {
 			return fmt.Errorf("failed to scan row: %w", err)
 		}
 
-		batch = append(batch, record)
+		batch.AppendFrom(scanner)
 
-		if len(batch) >= batchSize {
-			inserted, err := s.insertBatch(ctx, batch, columns)
+		if batch.Len() >= batchSize {
+			inserted, err := s.insertBatch(ctx, batch, columns, s.insertToken(ctx, "full", batchIndex, batch))
 			if err != nil {
 				return fmt.Errorf("failed to insert batch: %w", err)
 			}
 			totalInserted += inserted
-			batch = batch[:0]
+			batchIndex++
+			batch.Reset()
 
 			log.Printf("📦 %s: 已同步 %d 条记录", s.tableName, totalInserted)
 		}
@@ -672,8 +905,8 @@ func (s *UniversalSyncer) fullSync(ctx context.Context) error {
 		return fmt.Errorf("error iterating rows: %w", err)
 	}
 
-	if len(batch) > 0 {
-		inserted, err := s.insertBatch(ctx, batch, columns)
+	if batch.Len() > 0 {
+		inserted, err := s.insertBatch(ctx, batch, columns, s.insertToken(ctx, "full", batchIndex, batch))
 		if err != nil {
 			return fmt.Errorf("failed to insert final batch: %w", err)
 		}
@@ -684,26 +917,14 @@ func (s *UniversalSyncer) fullSync(ctx context.Context) error {
 	return nil
 }
 
-// segmentTimeRange 将时间范围分割为按天的分段
-func (s *UniversalSyncer) segmentTimeRange(timeRange TimeRange) []TimeSegment {
-	if !s.config.Sync.DailySegmentation {
-		return []TimeSegment{{Start: timeRange.Start, End: timeRange.End}}
+// segmentTimeRange 将时间范围分割为分段（按天，或 adaptive 分段方式下按行数密度）
+func (s *UniversalSyncer) segmentTimeRange(ctx context.Context, timeRange TimeRange) ([]TimeSegment, error) {
+	if s.tableConfig.GetEffectiveSegmentation(s.config.Sync.Segmentation) == "adaptive" {
+		return s.adaptiveSegments(ctx, timeRange)
 	}
-
-	segments := []TimeSegment{}
-	current := timeRange.Start
-
-	for current.Before(timeRange.End) {
-		dayEnd := time.Date(current.Year(), current.Month(), current.Day(), 23, 59, 59, 999999999, current.Location())
-		dayEnd = dayEnd.Add(1 * time.Nanosecond) // 下一天的 00:00:00
-
-		if dayEnd.After(timeRange.End) {
-			dayEnd = timeRange.End
-		}
-
-		segments = append(segments, TimeSegment{Start: current, End: dayEnd})
-		current = dayEnd
+	if !s.config.Sync.DailySegmentation {
+		return []TimeSegment{{Start: timeRange.Start, End: timeRange.End}}, nil
 	}
 
-	return segments
+	return SplitTimeRangeByDay(timeRange, s.location), nil
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 配置中不带时区偏移的时间格式（按表的时区解析）
+var localTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// ResolveTableLocation 确定表的时区：表配置 → 全局配置 → 时间字段类型声明的时区 → 源库服务器时区
+// 按天分段的边界、查询参数与状态文件中的时间都使用该时区
+func ResolveTableLocation(ctx context.Context, db *sql.DB, config *Config, tableConfig TableConfig, schema *TableSchema) (*time.Location, error) {
+	if name := tableConfig.GetEffectiveTimezone(config.Sync.Timezone); name != "" {
+		return time.LoadLocation(name)
+	}
+
+	if col := schema.GetColumn(tableConfig.TimeField); col != nil {
+		if name := columnTimezone(col.Type); name != "" {
+			loc, err := time.LoadLocation(name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load timezone of %s (%s): %w", col.Name, col.Type, err)
+			}
+			return loc, nil
+		}
+	}
+
+	var name string
+	if err := db.QueryRowContext(ctx, "SELECT timezone()").Scan(&name); err != nil {
+		return nil, fmt.Errorf("failed to query server timezone: %w", err)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server timezone %s: %w", name, err)
+	}
+	return loc, nil
+}
+
+// columnTimezone 返回 DateTime('tz') / DateTime64(p, 'tz') 类型声明的时区，未声明时返回空
+func columnTimezone(typeStr string) string {
+	base := unwrapType(typeStr)
+	if !strings.HasPrefix(base, "DateTime") {
+		return ""
+	}
+	start := strings.Index(base, "'")
+	end := strings.LastIndex(base, "'")
+	if start < 0 || end <= start {
+		return ""
+	}
+	return base[start+1 : end]
+}
+
+// dateTimePrecision 返回时间类型的小数秒精度：DateTime64(p) 为 p，其它时间类型为 0
+func dateTimePrecision(typeStr string) int {
+	base := unwrapType(typeStr)
+	if !strings.HasPrefix(base, "DateTime64(") {
+		return 0
+	}
+	args := strings.TrimSuffix(strings.TrimPrefix(base, "DateTime64("), ")")
+	if i := strings.Index(args, ","); i >= 0 {
+		args = args[:i]
+	}
+	precision, err := strconv.Atoi(strings.TrimSpace(args))
+	if err != nil || precision < 0 || precision > 9 {
+		return 0
+	}
+	return precision
+}
+
+// timeResolution 返回时间类型可表示的最小时间间隔（DateTime 为 1 秒，DateTime64(3) 为 1 毫秒）
+func timeResolution(typeStr string) time.Duration {
+	resolution := time.Second
+	for i := 0; i < dateTimePrecision(typeStr); i++ {
+		resolution /= 10
+	}
+	return resolution
+}
+
+// ParseTimeInLocation 解析配置中的时间：带时区偏移的 RFC3339 按偏移解析，
+// 不带偏移的（如 "2024-01-01 00:00:00"、"2024-01-01"）按 loc 解析，结果统一转换到 loc
+func ParseTimeInLocation(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.In(loc), nil
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (expected RFC3339 or \"2006-01-02 15:04:05\")", value)
+}
+
+// In 把分段转换到指定时区（状态文件中读出的时间只带固定偏移）
+func (s TimeSegment) In(loc *time.Location) TimeSegment {
+	return TimeSegment{Start: s.Start.In(loc), End: s.End.In(loc)}
+}
+
+// startOfNextDay 返回 t 在 loc 时区的下一天开始时刻（通常为 00:00:00）
+// 00:00 因夏令时不存在时（如 America/Santiago 在 00:00 切换），time.Date 按切换后的偏移换算，
+// 结果落在前一天的 23:00；此时下一天从切换时刻开始
+func startOfNextDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	next := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+	if y, m, d := next.Date(); y == t.Year() && m == t.Month() && d == t.Day() {
+		_, next = next.ZoneBounds()
+	}
+	return next
+}
+
+// bindTime 把时间边界转换为查询参数：纳秒精度的 UTC 文本（由 toDateTime64(?, 9, 'UTC') 解释），
+// 避免驱动按秒截断 DateTime64 边界；按 UTC 绑定与边界所在的时区无关，
+// 不会因夏令时回拨时重复出现的本地时间（如 America/New_York 的 01:30）而指向另一个时刻
+func bindTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000000000")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// parseBoundTime 按 toDateTime64(?, 9, 'UTC') 的方式解释 bindTime 绑定的参数
+func parseBoundTime(t *testing.T, value interface{}) time.Time {
+	t.Helper()
+	parsed, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", value.(string), time.UTC)
+	if err != nil {
+		t.Fatalf("bound value %q: %v", value, err)
+	}
+	return parsed
+}
+
+func TestBindTimeRoundTrip(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	santiago := mustLoadLocation(t, "America/Santiago")
+
+	// 夏令时回拨后第二次出现的 01:30（EST），本地时间文本与 01:30 EDT 相同
+	firstOneThirty := time.Date(2024, 11, 3, 1, 30, 0, 0, newYork)
+	secondOneThirty := firstOneThirty.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		t    time.Time
+	}{
+		{"midnight", time.Date(2024, 3, 10, 0, 0, 0, 0, newYork)},
+		{"nanoseconds", time.Date(2024, 3, 11, 12, 0, 0, 123456789, newYork)},
+		{"first 01:30 on fall back", firstOneThirty},
+		{"second 01:30 on fall back", secondOneThirty},
+		{"start of day after nonexistent midnight", startOfNextDay(time.Date(2024, 9, 7, 12, 0, 0, 0, santiago), santiago)},
+		{"fixed offset from state file", time.Date(2024, 3, 10, 0, 0, 0, 0, time.FixedZone("", -5*3600))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseBoundTime(t, bindTime(tt.t)); !got.Equal(tt.t) {
+				t.Errorf("bindTime(%s) = %q, which is %s", tt.t, bindTime(tt.t), got)
+			}
+		})
+	}
+
+	if bindTime(firstOneThirty) == bindTime(secondOneThirty) {
+		t.Errorf("ambiguous local times bind to the same value %q", bindTime(firstOneThirty))
+	}
+}
+
+// DateTime64(3/6/9) 字段中恰好落在分段边界上（以及边界前后一个最小时间单位）的行只属于一个分段
+func TestSegmentBoundaryRowsDateTime64(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	santiago := mustLoadLocation(t, "America/Santiago")
+
+	ranges := []struct {
+		name      string
+		loc       *time.Location
+		timeRange TimeRange
+		extraCut  time.Time // 按行数密度切分时可能出现的非日边界（可为零值）
+	}{
+		{
+			name:      "spring forward",
+			loc:       newYork,
+			timeRange: TimeRange{Start: time.Date(2024, 3, 9, 0, 0, 0, 0, newYork), End: time.Date(2024, 3, 12, 0, 0, 0, 0, newYork)},
+		},
+		{
+			name:      "fall back with cut at second 01:30",
+			loc:       newYork,
+			timeRange: TimeRange{Start: time.Date(2024, 11, 2, 0, 0, 0, 0, newYork), End: time.Date(2024, 11, 5, 0, 0, 0, 0, newYork)},
+			extraCut:  time.Date(2024, 11, 3, 1, 30, 0, 0, newYork).Add(time.Hour),
+		},
+		{
+			name:      "nonexistent midnight",
+			loc:       santiago,
+			timeRange: TimeRange{Start: time.Date(2024, 9, 7, 0, 0, 0, 0, santiago), End: time.Date(2024, 9, 10, 0, 0, 0, 0, santiago)},
+		},
+	}
+
+	for _, rg := range ranges {
+		for _, precision := range []int{3, 6, 9} {
+			typeStr := fmt.Sprintf("DateTime64(%d, '%s')", precision, rg.loc)
+			t.Run(fmt.Sprintf("%s/%s", rg.name, typeStr), func(t *testing.T) {
+				segments := SplitTimeRangeByDay(rg.timeRange, rg.loc)
+				if !rg.extraCut.IsZero() {
+					segments = splitSegmentAt(segments, rg.extraCut)
+				}
+
+				// 各分段的条件：Field >= start AND Field < end（按绑定的参数解释）
+				type bounds struct{ start, end time.Time }
+				predicates := make([]bounds, len(segments))
+				for i, segment := range segments {
+					r := TimeSegmentRange("created_at", segment)
+					args := r.Args()
+					predicates[i] = bounds{parseBoundTime(t, args[0]), parseBoundTime(t, args[1])}
+				}
+
+				resolution := timeResolution(typeStr)
+				for i, segment := range segments {
+					boundary := segment.Start
+					for _, row := range []time.Time{boundary.Add(-resolution), boundary, boundary.Add(resolution)} {
+						row = row.Truncate(resolution)
+						if row.Before(rg.timeRange.Start) {
+							continue
+						}
+
+						matches := []int{}
+						for j, p := range predicates {
+							if !row.Before(p.start) && row.Before(p.end) {
+								matches = append(matches, j)
+							}
+						}
+						if len(matches) != 1 {
+							t.Errorf("row %s matches segments %v", row.Format(time.RFC3339Nano), matches)
+							continue
+						}
+						if row.Equal(boundary) && matches[0] != i {
+							t.Errorf("boundary row %s matches segment %d, want %d", row.Format(time.RFC3339Nano), matches[0], i)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// splitSegmentAt 在 cut 处把所在的分段一分为二
+func splitSegmentAt(segments []TimeSegment, cut time.Time) []TimeSegment {
+	out := []TimeSegment{}
+	for _, segment := range segments {
+		if cut.After(segment.Start) && cut.Before(segment.End) {
+			out = append(out, TimeSegment{Start: segment.Start, End: cut}, TimeSegment{Start: cut, End: segment.End})
+			continue
+		}
+		out = append(out, segment)
+	}
+	return out
+}
@@ -35,6 +35,20 @@ func FormatNumber(n int) string {
 	return result.String()
 }
 
+// FormatBytes 格式化字节数
+func FormatBytes(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1fKB", float64(n)/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.1fMB", float64(n)/1024/1024)
+	default:
+		return fmt.Sprintf("%.2fGB", float64(n)/1024/1024/1024)
+	}
+}
+
 // CountEnabledTables 统计启用的表数量
 func CountEnabledTables(tables []TableConfig) int {
 	count := 0
@@ -76,11 +90,15 @@ func PrintSyncPlan(config *Config) {
 	fmt.Println("========================================")
 	fmt.Printf("源数据库: %s @ %v\n", config.Source.Database, config.Source.Addr)
 	fmt.Printf("目标数据库: %s @ %v\n", config.Target.Database, config.Target.Addr)
+	fmt.Printf("源库用户: %s\n", config.Source.describeCredentials())
+	fmt.Printf("目标库用户: %s\n", config.Target.describeCredentials())
 	fmt.Printf("同步模式: %s\n", config.Sync.Mode)
 	fmt.Printf("并发数: %d\n", config.Sync.MaxConcurrency)
 	fmt.Printf("批量大小: %d\n", config.Sync.BatchSize)
 	fmt.Printf("按天分段: %v\n", config.Sync.DailySegmentation)
 	fmt.Printf("启用压缩: %v\n", config.Sync.EnableCompression)
+	fmt.Printf("源库限流: %s\n", describeLimits(config.Sync.Limits.Source))
+	fmt.Printf("目标库限流: %s\n", describeLimits(config.Sync.Limits.Target))
 
 	if config.Sync.SchemaSync.Enabled {
 		fmt.Println("\n表结构同步:")
@@ -130,28 +148,54 @@ func PrintFinalReport(config *Config, duration time.Duration, state *StateManage
 	fmt.Println("========================================")
 }
 
-// ValidateTimeRange 验证时间范围配置
-func ValidateTimeRange(config *TimeRangeConfig) error {
+// SplitTimeRangeByDay 将时间范围按 loc 时区的自然日切分为分段
+// 日边界由 startOfNextDay 计算，夏令时切换日的分段为 23 或 25 小时
+func SplitTimeRangeByDay(timeRange TimeRange, loc *time.Location) []TimeSegment {
+	segments := []TimeSegment{}
+	current := timeRange.Start.In(loc)
+	end := timeRange.End.In(loc)
+
+	for current.Before(end) {
+		dayEnd := startOfNextDay(current, loc)
+
+		if dayEnd.After(end) {
+			dayEnd = end
+		}
+
+		segments = append(segments, TimeSegment{Start: current, End: dayEnd})
+		current = dayEnd
+	}
+
+	return segments
+}
+
+// ValidateTimeRange 验证时间范围配置（不带时区偏移的时间按全局时区解析，未配置时按 UTC）
+func ValidateTimeRange(config *TimeRangeConfig, timezone string) error {
+	loc := time.UTC
+	if timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q: %w", timezone, err)
+		}
+	}
+
+	var start, end time.Time
 	if config.Start != "" {
-		_, err := time.Parse(time.RFC3339, config.Start)
-		if err != nil {
-			return fmt.Errorf("invalid start time format (expected RFC3339): %w", err)
+		var err error
+		if start, err = ParseTimeInLocation(config.Start, loc); err != nil {
+			return fmt.Errorf("invalid start time format: %w", err)
 		}
 	}
 
 	if config.End != "" {
-		_, err := time.Parse(time.RFC3339, config.End)
-		if err != nil {
-			return fmt.Errorf("invalid end time format (expected RFC3339): %w", err)
+		var err error
+		if end, err = ParseTimeInLocation(config.End, loc); err != nil {
+			return fmt.Errorf("invalid end time format: %w", err)
 		}
 	}
 
-	if config.Start != "" && config.End != "" {
-		start, _ := time.Parse(time.RFC3339, config.Start)
-		end, _ := time.Parse(time.RFC3339, config.End)
-		if start.After(end) {
-			return fmt.Errorf("start time must be before end time")
-		}
+	if config.Start != "" && config.End != "" && start.After(end) {
+		return fmt.Errorf("start time must be before end time")
 	}
 
 	return nil
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestSplitTimeRangeByDayDST(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	santiago := mustLoadLocation(t, "America/Santiago")
+
+	tests := []struct {
+		name      string
+		loc       *time.Location
+		start     time.Time
+		end       time.Time
+		durations []time.Duration
+		starts    []string // 各分段开始的本地时间
+	}{
+		{
+			name:      "spring forward 23h day",
+			loc:       newYork,
+			start:     time.Date(2024, 3, 9, 0, 0, 0, 0, newYork),
+			end:       time.Date(2024, 3, 12, 0, 0, 0, 0, newYork),
+			durations: []time.Duration{24 * time.Hour, 23 * time.Hour, 24 * time.Hour},
+			starts:    []string{"2024-03-09 00:00 EST", "2024-03-10 00:00 EST", "2024-03-11 00:00 EDT"},
+		},
+		{
+			name:      "fall back 25h day",
+			loc:       newYork,
+			start:     time.Date(2024, 11, 2, 0, 0, 0, 0, newYork),
+			end:       time.Date(2024, 11, 5, 0, 0, 0, 0, newYork),
+			durations: []time.Duration{24 * time.Hour, 25 * time.Hour, 24 * time.Hour},
+			starts:    []string{"2024-11-02 00:00 EDT", "2024-11-03 00:00 EDT", "2024-11-04 00:00 EST"},
+		},
+		{
+			name:      "europe spring forward with partial first and last day",
+			loc:       berlin,
+			start:     time.Date(2024, 3, 30, 12, 0, 0, 0, berlin),
+			end:       time.Date(2024, 4, 1, 6, 30, 0, 0, berlin),
+			durations: []time.Duration{12 * time.Hour, 23 * time.Hour, 6*time.Hour + 30*time.Minute},
+			starts:    []string{"2024-03-30 12:00 CET", "2024-03-31 00:00 CET", "2024-04-01 00:00 CEST"},
+		},
+		{
+			name:      "europe fall back 25h day",
+			loc:       berlin,
+			start:     time.Date(2024, 10, 27, 0, 0, 0, 0, berlin),
+			end:       time.Date(2024, 10, 28, 0, 0, 0, 0, berlin),
+			durations: []time.Duration{25 * time.Hour},
+			starts:    []string{"2024-10-27 00:00 CEST"},
+		},
+		{
+			// 智利在 00:00 切换到夏令时：2024-09-08 00:00 不存在，当天从 01:00 开始
+			name:      "nonexistent local midnight",
+			loc:       santiago,
+			start:     time.Date(2024, 9, 7, 0, 0, 0, 0, santiago),
+			end:       time.Date(2024, 9, 10, 0, 0, 0, 0, santiago),
+			durations: []time.Duration{24 * time.Hour, 23 * time.Hour, 24 * time.Hour},
+			starts:    []string{"2024-09-07 00:00 -04", "2024-09-08 01:00 -03", "2024-09-09 00:00 -03"},
+		},
+		{
+			// 范围的起点在 UTC 给出，按 loc 的日边界切分
+			name:      "range given in UTC",
+			loc:       newYork,
+			start:     time.Date(2024, 3, 10, 3, 0, 0, 0, time.UTC),
+			end:       time.Date(2024, 3, 11, 4, 0, 0, 0, time.UTC),
+			durations: []time.Duration{2 * time.Hour, 23 * time.Hour},
+			starts:    []string{"2024-03-09 22:00 EST", "2024-03-10 00:00 EST"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := SplitTimeRangeByDay(TimeRange{Start: tt.start, End: tt.end}, tt.loc)
+			if len(segments) != len(tt.durations) {
+				t.Fatalf("got %d segments, want %d: %v", len(segments), len(tt.durations), segments)
+			}
+
+			for i, segment := range segments {
+				if got := segment.End.Sub(segment.Start); got != tt.durations[i] {
+					t.Errorf("segment %d lasts %s, want %s", i, got, tt.durations[i])
+				}
+				if got := segment.Start.Format("2006-01-02 15:04 MST"); got != tt.starts[i] {
+					t.Errorf("segment %d starts at %s, want %s", i, got, tt.starts[i])
+				}
+				if segment.Start.Location() != tt.loc {
+					t.Errorf("segment %d is in %s, want %s", i, segment.Start.Location(), tt.loc)
+				}
+				// 分段首尾相接，覆盖整个范围
+				if i > 0 && !segment.Start.Equal(segments[i-1].End) {
+					t.Errorf("gap between segment %d and %d", i-1, i)
+				}
+			}
+			if !segments[0].Start.Equal(tt.start) || !segments[len(segments)-1].End.Equal(tt.end) {
+				t.Errorf("segments cover %s ~ %s, want %s ~ %s",
+					segments[0].Start, segments[len(segments)-1].End, tt.start, tt.end)
+			}
+		})
+	}
+}
@@ -1,78 +1,319 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"time"
 )
 
+// ErrValidationFailed 验证未通过错误（目标库记录数低于 validation_ratio）
+var ErrValidationFailed = errors.New("validation failed")
+
 // Validator 数据验证器
 type Validator struct {
-	sourceDB *sql.DB
-	targetDB *sql.DB
-	config   *Config
+	sourceDB      *sql.DB
+	targetDB      *sql.DB
+	config        *Config
+	checksumCache map[string][]ColumnInfo // 表名到参与内容验证字段的缓存
+	mu            sync.Mutex
+}
+
+// SegmentValidation 分段验证结果
+type SegmentValidation struct {
+	Segment     TimeSegment `json:"segment"`
+	Range       string      `json:"range,omitempty"` // 非时间分段（ID 分段或分区）的范围描述，此时 Segment 为空
+	SourceCount int         `json:"source_count"`
+	TargetCount int         `json:"target_count"`
+	Passed      bool        `json:"passed"`
+	Method      string      `json:"method,omitempty"`     // "count" 或 "checksum"
+	Mismatches  []string    `json:"mismatches,omitempty"` // 内容验证中不一致的聚合项
+	ValidatedAt time.Time   `json:"validated_at"`
+}
+
+// Ratio 返回目标库与源库记录数的比例（源库为空时视为 100%）
+func (sv *SegmentValidation) Ratio() float64 {
+	if sv.SourceCount == 0 {
+		return 1
+	}
+	return float64(sv.TargetCount) / float64(sv.SourceCount)
 }
 
 // NewValidator 创建验证器
 func NewValidator(sourceDB, targetDB *sql.DB, config *Config) *Validator {
 	return &Validator{
-		sourceDB: sourceDB,
-		targetDB: targetDB,
-		config:   config,
+		sourceDB:      sourceDB,
+		targetDB:      targetDB,
+		config:        config,
+		checksumCache: make(map[string][]ColumnInfo),
 	}
 }
 
 // ValidateTable 验证表的数据完整性
-func (v *Validator) ValidateTable(tableName string, timeField string, timeRange TimeRange) error {
+func (v *Validator) ValidateTable(ctx context.Context, tableName string, timeField string, timeRange TimeRange) error {
 	if v.config.Sync.SkipValidation {
 		return nil
 	}
 
 	log.Printf("🔍 验证 %s 的数据完整性...", tableName)
 
+	result, err := v.ValidateSegment(ctx, tableName, timeField, TimeSegment{Start: timeRange.Start, End: timeRange.End})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("📊 %s: 源库 %d 条，目标库 %d 条", tableName, result.SourceCount, result.TargetCount)
+
+	if !result.Passed {
+		return v.validationError(result)
+	}
+
+	log.Printf("✅ %s: 验证通过 (%.2f%%)", tableName, result.Ratio()*100)
+	return nil
+}
+
+// ValidateSegment 验证单个时间分段（不受 skip_validation 影响，由调用方决定是否调用）
+func (v *Validator) ValidateSegment(ctx context.Context, tableName, timeField string, segment TimeSegment) (*SegmentValidation, error) {
+	result, err := v.ValidateRange(ctx, tableName, TimeSegmentRange(timeField, segment))
+	if err != nil {
+		return nil, err
+	}
+	result.Segment = segment
+	return result, nil
+}
+
+// ValidateRange 验证单个分段范围（时间分段或 ID 分段）
+func (v *Validator) ValidateRange(ctx context.Context, tableName string, r SegmentRange) (*SegmentValidation, error) {
 	// 查询源库记录数
-	sourceCount, err := v.countRecords(v.sourceDB, tableName, timeField, timeRange)
+	sourceCount, err := v.countRecords(ctx, v.sourceDB, tableName, r)
 	if err != nil {
-		return fmt.Errorf("failed to count source records: %w", err)
+		return nil, fmt.Errorf("failed to count source records: %w", err)
 	}
 
 	// 查询目标库记录数
-	targetCount, err := v.countRecords(v.targetDB, tableName, timeField, timeRange)
+	targetCount, err := v.countRecords(ctx, v.targetDB, tableName, r)
 	if err != nil {
-		return fmt.Errorf("failed to count target records: %w", err)
+		return nil, fmt.Errorf("failed to count target records: %w", err)
 	}
 
 	// 验证阈值
 	threshold := float64(sourceCount) * v.config.Sync.ValidationRatio
 
-	log.Printf("📊 %s: 源库 %d 条，目标库 %d 条", tableName, sourceCount, targetCount)
+	result := &SegmentValidation{
+		SourceCount: sourceCount,
+		TargetCount: targetCount,
+		Passed:      float64(targetCount) >= threshold,
+		Method:      v.config.Sync.ValidationMethod,
+		ValidatedAt: time.Now(),
+	}
+
+	// 内容验证：对比字段哈希、数值求和与时间边界
+	if v.config.Sync.ValidationMethod == "checksum" {
+		mismatches, err := v.compareChecksums(ctx, tableName, r)
+		if err != nil {
+			return nil, err
+		}
+		if len(mismatches) > 0 {
+			result.Mismatches = mismatches
+			result.Passed = false
+		}
+	}
 
-	if float64(targetCount) < threshold {
-		return fmt.Errorf(
-			"validation failed: expected ~%d (%.1f%%), got %d",
-			int(threshold), v.config.Sync.ValidationRatio*100, targetCount,
-		)
+	return result, nil
+}
+
+// SegmentDiverges 精确判断分段在源库与目标库之间是否存在差异（用于修复时二分定位）
+// count 方式比较记录数是否完全相等，checksum 方式比较全部内容聚合
+func (v *Validator) SegmentDiverges(ctx context.Context, tableName, timeField string, segment TimeSegment) (bool, error) {
+	r := TimeSegmentRange(timeField, segment)
+	if v.config.Sync.ValidationMethod == "checksum" {
+		mismatches, err := v.compareChecksums(ctx, tableName, r)
+		if err != nil {
+			return false, err
+		}
+		return len(mismatches) > 0, nil
 	}
 
-	log.Printf("✅ %s: 验证通过 (%.2f%%)",
-		tableName, float64(targetCount)/float64(sourceCount)*100)
-	return nil
+	sourceCount, err := v.countRecords(ctx, v.sourceDB, tableName, r)
+	if err != nil {
+		return false, fmt.Errorf("failed to count source records: %w", err)
+	}
+	targetCount, err := v.countRecords(ctx, v.targetDB, tableName, r)
+	if err != nil {
+		return false, fmt.Errorf("failed to count target records: %w", err)
+	}
+	return sourceCount != targetCount, nil
 }
 
-// countRecords 统计记录数
-func (v *Validator) countRecords(db *sql.DB, tableName, timeField string, timeRange TimeRange) (int, error) {
-	query := fmt.Sprintf(
-		"SELECT COUNT(*) FROM %s WHERE %s >= ? AND %s < ?",
-		tableName, timeField, timeField,
+// validationError 根据验证结果构建错误
+func (v *Validator) validationError(result *SegmentValidation) error {
+	if len(result.Mismatches) > 0 {
+		return fmt.Errorf("%w: checksum mismatch on %s", ErrValidationFailed, strings.Join(result.Mismatches, ", "))
+	}
+	threshold := float64(result.SourceCount) * v.config.Sync.ValidationRatio
+	return fmt.Errorf(
+		"%w: expected ~%d (%.1f%%), got %d",
+		ErrValidationFailed, int(threshold), v.config.Sync.ValidationRatio*100, result.TargetCount,
 	)
+}
+
+// countRecords 统计记录数
+func (v *Validator) countRecords(ctx context.Context, db *sql.DB, tableName string, r SegmentRange) (int, error) {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", v.rangeSource(tableName, r, nil))
 
 	var count int
-	err := db.QueryRow(query, timeRange.Start, timeRange.End).Scan(&count)
+	err := db.QueryRowContext(ctx, query, r.Args()...).Scan(&count)
 	return count, err
 }
 
+// rangeSource 返回分段范围的查询来源（FROM 之后的部分，参数由 r.Args 提供）。
+// 配置了版本字段的表在源库与目标库上都按去重键只保留版本最大的一行：源库可能保留同一键的多个版本，
+// 目标库 ReplacingMergeTree 合并前也保留旧版本，按原始行对比记录数、哈希与求和会一直不一致
+func (v *Validator) rangeSource(tableName string, r SegmentRange, columns []string) string {
+	var table *TableConfig
+	for i := range v.config.Tables {
+		if v.config.Tables[i].Name == tableName {
+			table = &v.config.Tables[i]
+			break
+		}
+	}
+	if table == nil || table.VersionField == "" {
+		return fmt.Sprintf("%s WHERE %s", tableName, r.Where())
+	}
+
+	selected := []string{}
+	seen := make(map[string]bool)
+	for _, name := range append(append(append([]string{}, columns...), table.DedupeKeys...), table.VersionField, r.Field) {
+		if !seen[name] {
+			seen[name] = true
+			selected = append(selected, name)
+		}
+	}
+	keys := strings.Join(table.DedupeKeys, ", ")
+	return fmt.Sprintf("(SELECT %s FROM %s WHERE %s ORDER BY %s DESC LIMIT 1 BY %s)",
+		strings.Join(selected, ", "), tableName, r.Where(), table.VersionField, keys)
+}
+
+// AuditTable 按 loc 时区的日分段审计已同步的时间范围（只读，不复制数据），结果记录到状态管理器
+func (v *Validator) AuditTable(ctx context.Context, tableConfig TableConfig, timeRange TimeRange, loc *time.Location, state *StateManager) error {
+	segments := SplitTimeRangeByDay(timeRange, loc)
+	log.Printf("🔍 %s: 审计 %d 个分段（%s ~ %s）", tableConfig.Name, len(segments),
+		timeRange.Start.Format(time.RFC3339), timeRange.End.Format(time.RFC3339))
+
+	failed := 0
+	for i, segment := range segments {
+		result, err := v.ValidateSegment(ctx, tableConfig.Name, tableConfig.TimeField, segment)
+		if err != nil {
+			return fmt.Errorf("failed to validate segment %v: %w", segment, err)
+		}
+		state.RecordValidation(tableConfig.Name, *result)
+
+		if !result.Passed {
+			failed++
+			log.Printf("❌ %s: 分段 %d/%d (%s) 验证失败 - 源库 %d 条，目标库 %d 条 (%.2f%%) %s",
+				tableConfig.Name, i+1, len(segments), segment.Start.Format("2006-01-02"),
+				result.SourceCount, result.TargetCount, result.Ratio()*100, formatMismatches(result.Mismatches))
+		}
+	}
+
+	if failed > 0 {
+		state.MarkTableFailed(tableConfig.Name)
+		return fmt.Errorf("%w: %d/%d segments below %.1f%%",
+			ErrValidationFailed, failed, len(segments), v.config.Sync.ValidationRatio*100)
+	}
+
+	log.Printf("✅ %s: %d 个分段全部验证通过", tableConfig.Name, len(segments))
+	return nil
+}
+
+// AuditPartitions 按源库分区审计（segmentation: partition 的表），
+// 未通过的分区从状态文件的已完成分区中移除，下次同步时重新同步
+func (v *Validator) AuditPartitions(ctx context.Context, tableConfig TableConfig, state *StateManager) error {
+	partitions, err := ListPartitions(ctx, v.sourceDB, tableConfig.Name)
+	if err != nil {
+		return err
+	}
+	log.Printf("🔍 %s: 审计 %d 个分区", tableConfig.Name, len(partitions))
+
+	failed := 0
+	for i, partition := range partitions {
+		result, err := v.ValidateRange(ctx, tableConfig.Name, PartitionRange(tableConfig.TimeField, partition.ID))
+		if err != nil {
+			return fmt.Errorf("failed to validate partition %s: %w", partition.ID, err)
+		}
+
+		if !result.Passed {
+			failed++
+			state.ResetPartition(tableConfig.Name, partition.ID)
+			log.Printf("❌ %s: 分区 %d/%d (%s) 验证失败 - 源库 %d 条，目标库 %d 条 (%.2f%%) %s",
+				tableConfig.Name, i+1, len(partitions), partition.Name,
+				result.SourceCount, result.TargetCount, result.Ratio()*100, formatMismatches(result.Mismatches))
+		}
+	}
+
+	if failed > 0 {
+		state.MarkTableFailed(tableConfig.Name)
+		return fmt.Errorf("%w: %d/%d partitions below %.1f%%",
+			ErrValidationFailed, failed, len(partitions), v.config.Sync.ValidationRatio*100)
+	}
+
+	log.Printf("✅ %s: %d 个分区全部验证通过", tableConfig.Name, len(partitions))
+	return nil
+}
+
+// AuditTimeRange 确定审计的时间范围：优先使用配置的 time_range，其次使用状态文件中已完成的分段范围，
+// 都没有时回退到最近 fallback_days 天；时间统一转换到表的时区 loc
+func AuditTimeRange(config *Config, tableState *TableState, loc *time.Location) (TimeRange, error) {
+	var timeRange TimeRange
+
+	if tableState != nil {
+		for _, segment := range tableState.CompletedSegments {
+			if timeRange.Start.IsZero() || segment.Start.Before(timeRange.Start) {
+				timeRange.Start = segment.Start
+			}
+			if segment.End.After(timeRange.End) {
+				timeRange.End = segment.End
+			}
+		}
+	}
+
+	if config.TimeRange.Start != "" {
+		start, err := ParseTimeInLocation(config.TimeRange.Start, loc)
+		if err != nil {
+			return TimeRange{}, fmt.Errorf("invalid start time: %w", err)
+		}
+		timeRange.Start = start
+	} else if timeRange.Start.IsZero() {
+		timeRange.Start = time.Now().AddDate(0, 0, -config.TimeRange.FallbackDays)
+	}
+
+	if config.TimeRange.End != "" {
+		end, err := ParseTimeInLocation(config.TimeRange.End, loc)
+		if err != nil {
+			return TimeRange{}, fmt.Errorf("invalid end time: %w", err)
+		}
+		timeRange.End = end
+	} else if timeRange.End.IsZero() {
+		timeRange.End = time.Now()
+	}
+
+	return TimeRange{Start: timeRange.Start.In(loc), End: timeRange.End.In(loc)}, nil
+}
+
+// formatMismatches 格式化内容验证不一致项（用于日志）
+func formatMismatches(mismatches []string) string {
+	if len(mismatches) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("不一致项: [%s]", strings.Join(mismatches, ", "))
+}
+
 // ValidateAllTables 验证所有启用的表
-func (v *Validator) ValidateAllTables(timeRange TimeRange) map[string]error {
+func (v *Validator) ValidateAllTables(ctx context.Context, timeRange TimeRange) map[string]error {
 	results := make(map[string]error)
 
 	for _, tableConfig := range v.config.Tables {
@@ -80,7 +321,7 @@ func (v *Validator) ValidateAllTables(timeRange TimeRange) map[string]error {
 			continue
 		}
 
-		err := v.ValidateTable(tableConfig.Name, tableConfig.TimeField, timeRange)
+		err := v.ValidateTable(ctx, tableConfig.Name, tableConfig.TimeField, timeRange)
 		results[tableConfig.Name] = err
 	}
 
@@ -101,7 +342,7 @@ func (v *Validator) PrintValidationSummary(results map[string]error) {
 			fmt.Printf("✅ %s: 验证通过\n", tableName)
 			passCount++
 		} else {
-			fmt.Printf("❌ %s: %v\n", tableName, err)
+			fmt.Printf("❌ %s: %s\n", tableName, RedactSecrets(err.Error()))
 			failCount++
 		}
 	}